@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// Format is the format of an archive.
+type Format string
+
+// Supported archive formats.
+const (
+	Zip   Format = "zip"
+	Tar   Format = "tar"
+	TarGz Format = "tar.gz"
+	TarXz Format = "tar.xz"
+)
+
+// ErrUnknownFormat is returned for archives in an unsupported format.
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// DefaultMaxSize is the default maximum size of the content of the entries
+// of an archive.
+const DefaultMaxSize = 1 << 30
+
+// TooLargeError is returned when an archive, or the content of its entries,
+// exceeds the maximum size.
+type TooLargeError struct {
+	MaxSize int64
+}
+
+func (e TooLargeError) Error() string {
+	return fmt.Sprintf("archive larger than %d bytes", e.MaxSize)
+}
+
+// number of bytes needed to detect the format, the tar magic is
+// at offset 257 of the first header
+const sniffLen = 262
+
+// maximum length of the target of a symbolic link in a zip
+const maxLinkLen = 4096
+
+var magics = []struct {
+	offset int
+	magic  string
+	format Format
+}{
+	{0, "\x1f\x8b", TarGz},
+	{0, "\xfd7zXZ\x00", TarXz},
+	{0, "PK\x03\x04", Zip},
+	{0, "PK\x05\x06", Zip}, // empty zip
+	{257, "ustar", Tar},
+}
+
+// Detect returns the format of an archive from its first bytes.
+func Detect(header []byte) (Format, error) {
+	for _, m := range magics {
+		end := m.offset + len(m.magic)
+		if len(header) >= end && string(header[m.offset:end]) == m.magic {
+			return m.format, nil
+		}
+	}
+	return "", ErrUnknownFormat
+}
+
+// Reader reads the entries of an archive in any of the supported formats.
+// The entries are described by tar headers, as with a tar.Reader.
+type Reader struct {
+	Format Format
+
+	tr      *tar.Reader
+	files   []*zip.File
+	entry   io.ReadCloser // content of the current zip entry
+	content *limitedReader
+}
+
+// NewReader detects the format of the archive and returns a reader of its
+// entries, up to DefaultMaxSize bytes of content. The reader must be closed.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderSize(r, DefaultMaxSize)
+}
+
+// NewReaderSize is like NewReader, but fails with a TooLargeError once
+// more than maxSize bytes of content are read from the entries. The index
+// of zip archives is at the end, so they are read in place from files and
+// other io.ReaderAt, and else in memory up to maxSize bytes.
+func NewReaderSize(r io.Reader, maxSize int64) (*Reader, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, errors.Wrap(err, "could not read archive")
+	}
+	format, err := Detect(header)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &Reader{Format: format, content: &limitedReader{maxSize: maxSize, remaining: maxSize}}
+	switch format {
+	case Tar:
+		ar.tr = tar.NewReader(br)
+	case TarGz:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read gzip")
+		}
+		ar.tr = tar.NewReader(zr)
+	case TarXz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read xz")
+		}
+		ar.tr = tar.NewReader(xr)
+	case Zip:
+		ra, size, err := readerAt(r, br, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read zip")
+		}
+		ar.files = zr.File
+	}
+	if ar.tr != nil {
+		ar.content.r = ar.tr
+	}
+	return ar, nil
+}
+
+// Returns a reader of the whole archive at random, r itself if it is a
+// file or another io.ReaderAt, or else its content read in memory from br,
+// up to maxSize bytes.
+func readerAt(r io.Reader, br *bufio.Reader, maxSize int64) (io.ReaderAt, int64, error) {
+	switch ra := r.(type) {
+	case *os.File:
+		info, err := ra.Stat()
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "could not stat zip")
+		}
+		if info.Mode().IsRegular() {
+			return ra, info.Size(), nil
+		}
+	case interface {
+		io.ReaderAt
+		Size() int64
+	}:
+		return ra, ra.Size(), nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(br, maxSize+1))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not read zip")
+	}
+	if int64(len(data)) > maxSize {
+		return nil, 0, TooLargeError{MaxSize: maxSize}
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// Reads the content of the entries, and fails once more than maxSize bytes
+// are read from all the entries, whatever the sizes in their headers.
+type limitedReader struct {
+	r         io.Reader
+	maxSize   int64
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, TooLargeError{MaxSize: l.maxSize}
+	}
+	return n, err
+}
+
+// Next advances to the next entry of the archive, and returns io.EOF
+// at the end.
+func (r *Reader) Next() (*tar.Header, error) {
+	if r.tr != nil {
+		return r.tr.Next()
+	}
+
+	if r.entry != nil {
+		r.entry.Close()
+		r.entry = nil
+	}
+	if len(r.files) == 0 {
+		return nil, io.EOF
+	}
+	f := r.files[0]
+	r.files = r.files[1:]
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open %s", f.Name)
+	}
+	r.entry = rc
+	r.content.r = rc
+	return zipHeader(f, r.content)
+}
+
+// Converts the header of a zip entry, the target of a symbolic link
+// is the content of the entry.
+func zipHeader(f *zip.File, rc io.Reader) (*tar.Header, error) {
+	var link string
+	if f.Mode()&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(io.LimitReader(rc, maxLinkLen))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read link %s", f.Name)
+		}
+		link = string(target)
+	}
+
+	header, err := tar.FileInfoHeader(f.FileInfo(), link)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unsupported entry %s", f.Name)
+	}
+	// FileInfoHeader only keeps the base name
+	header.Name = f.Name
+	return header, nil
+}
+
+// Read reads the content of the current entry.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.tr == nil && r.entry == nil {
+		return 0, io.EOF
+	}
+	return r.content.Read(p)
+}
+
+// Close releases the resources of the reader.
+func (r *Reader) Close() error {
+	if r.entry != nil {
+		r.entry.Close()
+		r.entry = nil
+	}
+	return nil
+}
+
+// Inflate calls onFile with the name and content of each regular file of
+// the archive, and fails on links.
+func Inflate(r io.Reader, onFile func(string, io.Reader) error) error {
+	ar, err := NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "Inflate: NewReader failed")
+	}
+	defer ar.Close()
+
+	for {
+		header, err := ar.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "Inflate: Next() failed")
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			// do nothing
+		case tar.TypeReg:
+			if err := onFile(header.Name, ar); err != nil {
+				return errors.Wrap(err, "failed to handle file")
+			}
+		default:
+			return errors.Errorf(
+				"Inflate: unknown type: %x in %s",
+				header.Typeflag,
+				header.Name)
+		}
+	}
+	return ar.Close()
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cdnjs/tools/manifest"
+
+	"github.com/pkg/errors"
+)
+
+// modification time of all the entries of the created archives
+var createModTime = time.Unix(0, 0)
+
+// Create creates a reproducible tar.gz of the src directory, the output of
+// process-version, into buf: the entries
+// are in lexical order, without ownership, with fixed permissions and
+// modification times, and the gzip header is empty. Returns the digest of
+// the uncompressed tar, which only depends on the files and their content.
+//
+// The manifest is the first entry, so that consumers can read it before
+// the files it describes. It is archived without its timings, which are
+// only reported in the audit, and it isn't part of the digest, which is
+// the one of the tar of the other entries and so only depends on the
+// published files.
+func Create(src string, buf io.Writer) (string, error) {
+	// tar > (gzip > buf, digest)
+	zr := gzip.NewWriter(buf)
+	zr.Header = gzip.Header{OS: 255} // unknown OS, no name or mtime
+	digest := &switchWriter{w: sha256.New()}
+	tw := tar.NewWriter(io.MultiWriter(zr, digest))
+
+	manifestFile := path.Join(src, manifest.Name)
+	m, err := manifest.Read(manifestFile)
+	if err != nil {
+		return "", err
+	}
+	content, err := m.WithoutTimings().Marshal()
+	if err != nil {
+		return "", err
+	}
+	if err := writeTarEntry(tw, "/"+manifest.Name, content); err != nil {
+		return "", errors.Wrap(err, "could not write manifest")
+	}
+	// pads the manifest entry, before hashing the next ones
+	if err := tw.Flush(); err != nil {
+		return "", err
+	}
+	digest.on = true
+
+	// walk through every file in the folder, filepath.Walk
+	// visits them in lexical order
+	err = filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// remove the /tmp/out** prefix
+		relFile := strings.TrimPrefix(file, src)
+		if relFile == "" || file == manifestFile {
+			// root dir, or already written
+			return nil
+		}
+
+		header := &tar.Header{
+			// must provide real name
+			// (see https://golang.org/src/archive/tar/common.go?#L626)
+			Name:    filepath.ToSlash(relFile),
+			ModTime: createModTime,
+		}
+		switch {
+		case fi.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		case fi.Mode().IsRegular():
+			header.Typeflag = tar.TypeReg
+			header.Mode = 0644
+			header.Size = fi.Size()
+		default:
+			return errors.Errorf("unsupported file mode %s: %s", fi.Mode(), relFile)
+		}
+
+		// write header
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		// if not a dir, write file content
+		if !fi.IsDir() {
+			data, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer data.Close()
+			if _, err := io.Copy(tw, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// produce tar
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	// produce gzip
+	if err := zr.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", digest.w.Sum(nil)), nil
+}
+
+// Writes a regular file as a tar entry.
+func writeTarEntry(tw *tar.Writer, name string, content []byte) error {
+	header := &tar.Header{
+		Name:     name,
+		ModTime:  createModTime,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// Hashes what is written, once turned on.
+type switchWriter struct {
+	w  hash.Hash
+	on bool
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	if !s.on {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
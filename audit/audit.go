@@ -134,6 +134,16 @@ func NewVersionDetected(ctx context.Context, pkgName string, version string) err
 	return nil
 }
 
+func DownloadFailed(ctx context.Context, pkgName string, version string, reason string, err error) error {
+	content := bytes.NewBufferString("")
+	fmt.Fprintf(content, "Download failed (%s): %s\n", reason, err)
+
+	if err := create(ctx, pkgName, version, "download-failed", content); err != nil {
+		return errors.Wrap(err, "could not create audit log file")
+	}
+	return nil
+}
+
 const MAX_LOGS_LENGTH = 1 * 1024 * 1024 // 1 Mb
 
 func ProcessedVersion(ctx context.Context, pkgName string, version string, logs string) error {
@@ -171,6 +181,17 @@ func WroteKV(ctx context.Context, pkgName string, version string,
 	return nil
 }
 
+func YankedVersion(ctx context.Context, pkgName string, version string, changes string) error {
+	content := bytes.NewBufferString("")
+	fmt.Fprintf(content, "Yanked version: %s\n", version)
+	fmt.Fprint(content, changes)
+
+	if err := create(ctx, pkgName, version, "kv-yank", content); err != nil {
+		return errors.Wrap(err, "could not create audit log file")
+	}
+	return nil
+}
+
 func WroteAlgolia(ctx context.Context, pkgName string, currVersion string, lastVersion *string, entry *algolia.SearchEntry) error {
 	content := bytes.NewBufferString("")
 	fmt.Fprintf(content, "current version: %s\n", currVersion)
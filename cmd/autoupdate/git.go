@@ -117,7 +117,11 @@ func doUpdateGit(ctx context.Context, pckg *packages.Package, gitpath string, ve
 
 	for _, gitversion := range versions {
 		git.ForceCheckout(ctx, gitpath, gitversion.Tag)
-		filesToCopy := pckg.NpmFilesFrom(gitpath)
+		filesToCopy, err := pckg.NpmFilesFrom(gitpath)
+		if err != nil {
+			util.Errf(ctx, "could not match files of %s: %s\n", gitversion.Version, err)
+			continue
+		}
 
 		pckgpath := path.Join(pckg.LibraryPath(), gitversion.Version)
 
@@ -96,7 +96,12 @@ func doUpdateNpm(ctx context.Context, pckg *packages.Package, versions []npm.Ver
 		}
 
 		tarballDir := npm.DownloadTar(ctx, version.Tarball)
-		filesToCopy := pckg.NpmFilesFrom(tarballDir)
+		filesToCopy, err := pckg.NpmFilesFrom(tarballDir)
+		if err != nil {
+			util.Errf(ctx, "could not match files of %s: %s\n", version.Version, err)
+			util.Check(os.RemoveAll(tarballDir))
+			continue
+		}
 
 		if len(filesToCopy) > 0 {
 			util.Check(os.MkdirAll(pckgpath, os.ModePerm))
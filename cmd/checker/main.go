@@ -8,12 +8,12 @@ import (
 	"log"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/cdnjs/tools/git"
+	"github.com/cdnjs/tools/manifest"
 	"github.com/cdnjs/tools/npm"
 	"github.com/cdnjs/tools/packages"
 	"github.com/cdnjs/tools/sandbox"
@@ -32,6 +32,9 @@ var (
 
 	// regex for path in cdnjs/packages/
 	pckgPathRegex = regexp.MustCompile("^packages/([a-z0-9])/([a-zA-Z0-9._-]+).json$")
+
+	// runs process-version for show-files
+	sandboxRuntime sandbox.Runtime
 )
 
 func main() {
@@ -74,15 +77,8 @@ func processVersion(ctx context.Context, pckg *packages.Package, v version.Versi
 	}
 	defer os.RemoveAll(inDir)
 
-	buff := version.DownloadTar(ctx, v)
-
-	dst, err := os.Create(path.Join(inDir, "new-version.tgz"))
-	if err != nil {
-		return outDir, errors.Wrap(err, "could not write tmp file")
-	}
-	defer dst.Close()
-	if _, err := dst.Write(buff.Bytes()); err != nil {
-		return outDir, errors.Wrap(err, "could not write new version in sandbox")
+	if err := version.Download(ctx, &v, path.Join(inDir, "new-version")); err != nil {
+		return outDir, errors.Wrap(err, "could not download new version in sandbox")
 	}
 
 	if err := writeConfig(inDir, pckg); err != nil {
@@ -90,7 +86,7 @@ func processVersion(ctx context.Context, pckg *packages.Package, v version.Versi
 	}
 
 	name := fmt.Sprintf("%s_%s", *pckg.Name, v.Version)
-	logs, err := sandbox.Run(ctx, name, inDir, outDir)
+	logs, err := sandboxRuntime.Run(ctx, name, inDir, outDir, sandbox.DefaultOptions())
 	if err != nil {
 		return outDir, errors.Wrap(err, "failed to run sandbox")
 	}
@@ -112,7 +108,11 @@ func showFiles(pckgPath string, noPathValidation bool) error {
 		return nil
 	}
 
-	if err := sandbox.Init(ctx); err != nil {
+	sandboxRuntime, err = sandbox.NewRuntime()
+	if err != nil {
+		return errors.Wrap(err, "could not create sandbox")
+	}
+	if err := sandboxRuntime.Init(ctx); err != nil {
 		log.Fatalf("failed to init sandbox: %s", err)
 	}
 
@@ -124,8 +124,12 @@ func showFiles(pckgPath string, noPathValidation bool) error {
 	switch src {
 	case "npm":
 		{
+			var err error
 			// get npm versions and sort
-			versions, _ = npm.GetVersions(ctx, pckg.Autoupdate)
+			versions, _, err = npm.GetVersions(ctx, pckg.Autoupdate)
+			if err != nil {
+				return errors.Wrap(err, "failed to retrieve npm versions")
+			}
 			sort.Sort(version.ByDate(versions))
 		}
 	case "git":
@@ -205,19 +209,14 @@ func parseHumanPackage(ctx context.Context, pckgPath string, noPathValidation bo
 	return pckg, nil
 }
 
-func filewalker(basedir string, files *[]string) filepath.WalkFunc {
-	return func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return errors.Wrap(err, "failed to walk fs")
-		}
-		ext := filepath.Ext(path)
-		if ext == ".gz" {
-			path = strings.ReplaceAll(path, ".gz", "")
-			path = strings.ReplaceAll(path, basedir+"/", "")
-			*files = append(*files, path)
-		}
-		return nil
+// Lists the files published in the sandbox output, once each whatever
+// their encodings, from its manifest.
+func publishedFiles(outDir string) ([]string, error) {
+	m, err := manifest.Read(path.Join(outDir, manifest.Name))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not inspect sandbox output")
 	}
+	return m.Names(), nil
 }
 
 // Prints the files of a package version, outputting debug
@@ -231,11 +230,9 @@ func printMostRecentVersion(ctx context.Context, p *packages.Package, v version.
 	}
 	defer os.RemoveAll(outDir)
 
-	var files []string
-
-	err = filepath.Walk(outDir, filewalker(outDir, &files))
+	files, err := publishedFiles(outDir)
 	if err != nil {
-		return errors.Wrap(err, "could not inspect sandbox output")
+		return err
 	}
 
 	if len(files) == 0 {
@@ -277,11 +274,9 @@ func printLastVersions(ctx context.Context, p *packages.Package, versions []vers
 			log.Fatalf("failed to process version: %s", err)
 		}
 
-		var files []string
-
-		err = filepath.Walk(outDir, filewalker(outDir, &files))
+		files, err := publishedFiles(outDir)
 		if err != nil {
-			return errors.Wrap(err, "could not inspect sandbox output")
+			return err
 		}
 
 		fmt.Printf("- %s: %d file(s) matched", version.Version, len(files))
@@ -334,7 +329,12 @@ func lintPackage(pckgPath string, noPathValidation bool) error {
 	case "npm":
 		{
 			// check that it exists
-			if !npm.Exists(*pckg.Autoupdate.Target) {
+			exists, err := npm.Exists(ctx, *pckg.Autoupdate.Target)
+			if err != nil {
+				showErr(ctx, fmt.Sprintf("could not check package on npm: %s", err))
+				break
+			}
+			if !exists {
 				showErr(ctx, "package doesn't exist on npm")
 				break
 			}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,6 +16,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cdnjs/tools/archive"
+	"github.com/cdnjs/tools/compress"
+	"github.com/cdnjs/tools/manifest"
+
 	"github.com/pkg/errors"
 )
 
@@ -169,25 +171,48 @@ func addNewVersion(item Item) (*time.Time, error) {
 	}
 
 	hasFiles := false
+	written := make(map[string]bool)
+	// the manifest is the first entry of the archive, if any
+	var entries map[string]manifest.Entry
 	onFile := func(name string, r io.Reader) error {
-		ext := filepath.Ext(name)
-		if ext == ".woff2" {
-			// woff2 files are not compressed, write as is
-			target := path.Join(dest, name)
-			if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
-				return errors.Wrap(err, "failed to create directory")
+		// remove leading slash
+		name = strings.TrimPrefix(name, "/")
+
+		if name == manifest.Name {
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return errors.Wrap(err, "could not read manifest")
 			}
-			if err := writeFile(target, r); err != nil {
-				return errors.Wrap(err, "failed to write file")
+			m, err := manifest.Parse(content)
+			if err != nil {
+				return errors.Wrap(err, "could not parse manifest")
 			}
+			entries = m.Entries()
+			return nil
 		}
-		if ext == ".gz" {
-			name = strings.ReplaceAll(name, ".gz", "")
-			target := path.Join(dest, name)
-			if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
-				return errors.Wrap(err, "failed to create directory")
+
+		original, enc, ok := publishedEntry(entries, name)
+		if !ok {
+			return nil
+		}
+		// the same file is published in several encodings,
+		// only write it once
+		if written[original] {
+			return nil
+		}
+		written[original] = true
+
+		target := path.Join(dest, original)
+		if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+			return errors.Wrap(err, "failed to create directory")
+		}
+		if enc == nil {
+			// not compressed, write as is
+			if err := writeFile(target, r); err != nil {
+				return errors.Wrap(err, "failed to write file")
 			}
-			uncompressed, err := gunzip(r)
+		} else {
+			uncompressed, err := enc.Decode(r)
 			if err != nil {
 				return errors.Wrap(err, "failed to uncompress")
 			}
@@ -199,7 +224,7 @@ func addNewVersion(item Item) (*time.Time, error) {
 		hasFiles = true
 		return nil
 	}
-	if err := inflate(tar, onFile); err != nil {
+	if err := archive.Inflate(tar, onFile); err != nil {
 		return nil, errors.Wrap(err, "failed to extract files")
 	}
 
@@ -220,6 +245,33 @@ func addNewVersion(item Item) (*time.Time, error) {
 	return &t, nil
 }
 
+// Returns the published file an entry of the archive is, and its encoding
+// if it's precompressed. Archives without manifest predate it: the files
+// are found from their extension.
+func publishedEntry(entries map[string]manifest.Entry, name string) (string, *compress.Encoding, bool) {
+	if entries == nil {
+		if original, enc := compress.SplitEncodingExt(name); enc != nil {
+			return original, enc, true
+		}
+		// woff2 files are not compressed
+		return name, nil, filepath.Ext(name) == ".woff2"
+	}
+
+	entry, ok := entries[name]
+	if !ok {
+		return "", nil, false
+	}
+	if entry.Encoding == "" {
+		return entry.File.Name, nil, true
+	}
+	enc, ok := compress.GetEncoding(entry.Encoding)
+	if !ok {
+		log.Printf("unknown encoding %s of %s, ignoring\n", entry.Encoding, name)
+		return "", nil, false
+	}
+	return entry.File.Name, enc, true
+}
+
 func git(args ...string) error {
 	cmd := exec.Command("git", args...)
 	log.Printf("running: %s", cmd)
@@ -292,55 +344,3 @@ func diff(lastSync time.Time, items []Item) ([]Item, error) {
 	}
 	return changes, nil
 }
-
-func inflate(gzipStream io.Reader, onFile func(string, io.Reader) error) error {
-	uncompressedStream, err := gzip.NewReader(gzipStream)
-	if err != nil {
-		return errors.Wrap(err, "ExtractTarGz: NewReader failed")
-	}
-
-	tarReader := tar.NewReader(uncompressedStream)
-
-	for {
-		header, err := tarReader.Next()
-
-		if err == io.EOF {
-			break
-		}
-
-		if err != nil {
-			return errors.Wrap(err, "ExtractTarGz: Next() faileds")
-		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			// ignore
-		case tar.TypeReg:
-			if err := onFile(header.Name, tarReader); err != nil {
-				return errors.Wrap(err, "failed to handle file")
-			}
-		default:
-			return errors.Errorf(
-				"ExtractTarGz: uknown type: %x in %s",
-				header.Typeflag,
-				header.Name)
-		}
-
-	}
-	return nil
-}
-
-func gunzip(in io.Reader) ([]byte, error) {
-	r, err := gzip.NewReader(in)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create gzip reader")
-	}
-
-	var out bytes.Buffer
-	_, err = out.ReadFrom(r)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read from gzip")
-	}
-
-	return out.Bytes(), nil
-}
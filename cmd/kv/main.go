@@ -35,7 +35,7 @@ func isZeroOrOne(bs []bool) bool {
 
 func main() {
 	defer sentry.PanicHandler()
-	var metaOnly, srisOnly, filesOnly, count, noPush, panicOversized, ungzip, unbrotli bool
+	var metaOnly, srisOnly, filesOnly, count, noPush, panicOversized, ungzip, unbrotli, dryRun, repair bool
 	flag.BoolVar(&metaOnly, "meta-only", false, "If set, only version metadata is uploaded to KV (no files, no SRIs).")
 	flag.BoolVar(&srisOnly, "sris-only", false, "If set, only file SRIs are uploaded to KV (no files, no metadata).")
 	flag.BoolVar(&filesOnly, "files-only", false, "If set, only files are uploaded to KV (no metadata, no SRIs).")
@@ -44,6 +44,8 @@ func main() {
 	flag.BoolVar(&noPush, "no-push", false, "If set, nothing will be written to KV. However, theoretical keys will be counted if the -count flag is set.")
 	flag.BoolVar(&ungzip, "ungzip", false, "If set, the file content will be decompressed with gzip.")
 	flag.BoolVar(&unbrotli, "unbrotli", false, "If set, the file content will be decompressed with brotli.")
+	flag.BoolVar(&dryRun, "dry-run", false, "If set, the changes of a yank will be outputted without being made.")
+	flag.BoolVar(&repair, "repair", false, "If set, the inconsistencies found by verify will be repaired.")
 	flag.Parse()
 
 	if util.IsDebug() {
@@ -139,6 +141,24 @@ func main() {
 
 			kv.OutputSRIs(prefix)
 		}
+	case "yank":
+		{
+			args := flag.Args()[1:]
+			if len(args) != 2 {
+				panic("must specify package and version")
+			}
+
+			yank(args[0], args[1], dryRun)
+		}
+	case "verify":
+		{
+			pckg := flag.Arg(1)
+			if pckg == "" {
+				panic("no package specified")
+			}
+
+			verify(pckg, repair)
+		}
 	default:
 		panic(fmt.Sprintf("unknown subcommand: `%s`", subcommand))
 	}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cdnjs/tools/kv"
+)
+
+// Cross-checks the KV namespaces of a package, repairing the
+// inconsistencies if repair is set.
+func verify(pkgName string, repair bool) {
+	ns, err := kv.NewNamespaces(os.Getenv("WORKERS_KV_API_TOKEN"), os.Getenv("WORKERS_KV_ACCOUNT_ID"))
+	if err != nil {
+		log.Fatalf("failed to open kv namespaces: %s", err)
+	}
+
+	inconsistencies, err := kv.VerifyPackage(context.Background(), ns, pkgName, repair)
+	for _, i := range inconsistencies {
+		fmt.Println(i)
+	}
+	if err != nil {
+		log.Fatalf("failed to verify %s: %s", pkgName, err)
+	}
+	fmt.Printf("%s: %d inconsistencies\n", pkgName, len(inconsistencies))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cdnjs/tools/audit"
+	"github.com/cdnjs/tools/kv"
+)
+
+// Deletes a version from KV, or only outputs the changes if dryRun is set.
+func yank(pkgName, version string, dryRun bool) {
+	if err := kv.CheckVersion(version); err != nil {
+		log.Fatalf("cannot yank %s: %s", pkgName, err)
+	}
+
+	ctx := context.Background()
+	ns, err := kv.NewNamespaces(os.Getenv("WORKERS_KV_API_TOKEN"), os.Getenv("WORKERS_KV_ACCOUNT_ID"))
+	if err != nil {
+		log.Fatalf("failed to open kv namespaces: %s", err)
+	}
+
+	deletion, err := kv.DeleteVersion(ctx, ns, pkgName, version, dryRun)
+	if err != nil {
+		log.Fatalf("failed to yank %s %s: %s", pkgName, version, err)
+	}
+
+	if dryRun {
+		fmt.Printf("dry run, would yank %s %s:\n%s", pkgName, version, deletion)
+		return
+	}
+	fmt.Printf("yanked %s %s:\n%s", pkgName, version, deletion)
+
+	if err := audit.YankedVersion(ctx, pkgName, version, deletion.String()); err != nil {
+		log.Fatalf("could not audit: %s", err)
+	}
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
 	b64 "encoding/base64"
 	"encoding/json"
@@ -14,11 +12,11 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"runtime"
-	"strings"
 
+	"github.com/cdnjs/tools/archive"
 	"github.com/cdnjs/tools/audit"
+	"github.com/cdnjs/tools/manifest"
 	"github.com/cdnjs/tools/sandbox"
 	"github.com/cdnjs/tools/sentry"
 
@@ -29,6 +27,8 @@ import (
 var (
 	PROJECT      = os.Getenv("PROJECT")
 	SUBSCRIPTION = os.Getenv("SUBSCRIPTION")
+
+	sandboxRuntime sandbox.Runtime
 )
 
 func init() {
@@ -46,7 +46,11 @@ func main() {
 	sub.ReceiveSettings.MaxOutstandingMessages = 5
 	sub.ReceiveSettings.NumGoroutines = runtime.NumCPU()
 
-	if err := sandbox.Init(ctx); err != nil {
+	sandboxRuntime, err = sandbox.NewRuntime()
+	if err != nil {
+		log.Fatalf("failed to create sandbox: %s", err)
+	}
+	if err := sandboxRuntime.Init(ctx); err != nil {
 		log.Fatalf("failed to init sandbox: %s", err)
 	}
 
@@ -58,6 +62,18 @@ func main() {
 	}
 }
 
+// Returns the default sandbox options, passing the processing
+// configuration of the host to the sandbox.
+func sandboxOptions() sandbox.Options {
+	opts := sandbox.DefaultOptions()
+	for _, key := range []string{"MINIFY_JS", "MINIFY_CSS", "ENCODINGS", "EXTRACT_LINKS"} {
+		if value, ok := os.LookupEnv(key); ok {
+			opts.Env = append(opts.Env, key+"="+value)
+		}
+	}
+	return opts
+}
+
 type Message struct {
 	OutgoingSignedURL string           `json:"outgoingSignedURL"`
 	Tar               string           `json:"tar"`
@@ -103,31 +119,55 @@ func processMessage(ctx context.Context, data []byte) error {
 	}
 
 	name := fmt.Sprintf("%s_%s", message.Pkg, message.Version)
-	logs, err := sandbox.Run(ctx, name, inDir, outDir)
+	logs, err := sandboxRuntime.Run(ctx, name, inDir, outDir, sandboxOptions())
+	if timeoutErr, ok := err.(sandbox.TimeoutError); ok {
+		// keep track of the partial logs of the processing
+		log.Println("logs", len(logs), logs)
+		logs = fmt.Sprintf("%s\n%s", logs, timeoutErr)
+		if err := audit.ProcessedVersion(ctx, message.Pkg, message.Version, logs); err != nil {
+			log.Printf("could not post audit: %s\n", err)
+		}
+		return errors.Wrap(err, "failed to run sandbox")
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to run sandbox")
 	}
 	log.Println("logs", len(logs), logs)
 
+	m, manifestErr := manifest.Read(path.Join(outDir, manifest.Name))
+	if manifestErr != nil {
+		logs = fmt.Sprintf("%s\n%s", logs, manifestErr)
+	} else {
+		logs = fmt.Sprintf("%s\n%s", m.Summary(), logs)
+	}
+
 	if err := audit.ProcessedVersion(ctx, message.Pkg, message.Version, logs); err != nil {
 		return errors.Wrap(err, "could not post audit")
 	}
+	if manifestErr != nil {
+		// the processing didn't complete
+		return errors.Wrap(manifestErr, "failed to read manifest")
+	}
+	if m.Rejected != nil {
+		return errors.Errorf("tarball rejected: %s", m.Rejected.Reason)
+	}
 
 	log.Printf("compressing %s\n", outDir)
 	var buff bytes.Buffer
-	if err := compress(outDir, &buff); err != nil {
+	digest, err := archive.Create(outDir, &buff)
+	if err != nil {
 		return errors.Wrap(err, "failed to compress out dir")
 	}
 
-	log.Println("uploading")
-	if err := uploadToOutgoing(buff, message); err != nil {
+	log.Printf("uploading %s\n", digest)
+	if err := uploadToOutgoing(buff, digest, message); err != nil {
 		return errors.Wrap(err, "failed to upload to outgoing bucket")
 	}
 
 	return nil
 }
 
-func uploadToOutgoing(content bytes.Buffer, msg Message) error {
+func uploadToOutgoing(content bytes.Buffer, digest string, msg Message) error {
 	r := bytes.NewReader(content.Bytes())
 	req, err := http.NewRequest("PUT", msg.OutgoingSignedURL, r)
 	if err != nil {
@@ -139,6 +179,7 @@ func uploadToOutgoing(content bytes.Buffer, msg Message) error {
 	req.Header.Set("x-goog-meta-package", msg.Pkg)
 	req.Header.Set("x-goog-meta-version", msg.Version)
 	req.Header.Set("x-goog-meta-config", encodedConfig)
+	req.Header.Set("x-goog-meta-digest", digest)
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return errors.Wrap(err, "request failed")
@@ -170,7 +211,7 @@ func download(dstDir string, url string) error {
 	}
 	defer resp.Body.Close()
 
-	dst, err := os.Create(path.Join(dstDir, "new-version.tgz"))
+	dst, err := os.Create(path.Join(dstDir, "new-version"))
 	if err != nil {
 		return errors.Wrap(err, "could not write tmp file")
 	}
@@ -179,58 +220,3 @@ func download(dstDir string, url string) error {
 	_, err = io.Copy(dst, resp.Body)
 	return err
 }
-
-func compress(src string, buf io.Writer) error {
-	// tar > gzip > buf
-	zr := gzip.NewWriter(buf)
-	tw := tar.NewWriter(zr)
-
-	// walk through every file in the folder
-	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		// generate tar header
-		header, err := tar.FileInfoHeader(fi, file)
-		if err != nil {
-			return err
-		}
-
-		// remove the /tmp/out** prefix
-		relFile := strings.ReplaceAll(file, src, "")
-
-		// must provide real name
-		// (see https://golang.org/src/archive/tar/common.go?#L626)
-		header.Name = filepath.ToSlash(relFile)
-
-		// write header
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-		// if not a dir, write file content
-		if !fi.IsDir() {
-			data, err := os.Open(file)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(tw, data); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	// produce tar
-	if err := tw.Close(); err != nil {
-		return err
-	}
-	// produce gzip
-	if err := zr.Close(); err != nil {
-		return err
-	}
-	//
-	return nil
-}
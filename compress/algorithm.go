@@ -4,48 +4,11 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"fmt"
 	"io/ioutil"
-	"log"
-	"os/exec"
 
 	"github.com/cdnjs/tools/util"
 )
 
-// Runs an algorithm with a set of arguments,
-// and returns its stdout as bytes.
-// Note, this function will panic if anything is
-// output to stderr.
-func runAlgorithm(ctx context.Context, alg string, args ...string) []byte {
-	cmd := exec.Command(alg, args...)
-	var stdOut, stdErr bytes.Buffer
-	cmd.Stdout, cmd.Stderr = &stdOut, &stdErr
-
-	log.Printf("algorithm: run %s\n", cmd)
-	err := cmd.Run()
-	// log.Println(string(stdOut.Bytes()))
-	// log.Println(string(stdErr.Bytes()))
-	util.Check(err)
-
-	if stdErr.Len() > 0 {
-		panic(fmt.Sprintf("%s failed: %s", alg, stdErr.String()))
-	}
-
-	return stdOut.Bytes()
-}
-
-// Brotli11CLI returns a brotli compressed file as bytes
-// at optimal compression (quality 11).
-func Brotli11CLI(ctx context.Context, src string, out string) {
-	runAlgorithm(ctx, "brotli", "--quality=11", "--output="+out, src)
-}
-
-// UnBrotliCLI returns a brotli compressed file as bytes
-// at optimal compression (quality 11).
-func UnBrotliCLI(ctx context.Context, filePath string) []byte {
-	return runAlgorithm(ctx, "brotli", "--decompress", "--input", filePath)
-}
-
 // Gzip9Native returns a gzip compressed file as bytes
 // at optimal compression (level 9).
 func Gzip9Native(ctx context.Context, src string, out string) {
@@ -58,6 +21,9 @@ func Gzip9Native(ctx context.Context, src string, out string) {
 	util.Check(err)
 }
 
+// Gzip9Bytes returns gzip compressed bytes using the standard library
+// at optimal compression (level 9). Prefer it over GzipZopfli when speed
+// matters more than size.
 func Gzip9Bytes(uncompressed []byte) []byte {
 	var b bytes.Buffer
 
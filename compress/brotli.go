@@ -0,0 +1,104 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+const (
+	brotliQuality = 11
+
+	// window bounds of the brotli format, in bits
+	brotliMinWindow = 10
+	brotliMaxWindow = 24
+)
+
+// Picks the smallest window that fits the input, like the brotli CLI does,
+// so that the output matches the files it used to produce.
+// A negative size means unknown and uses the largest window.
+func brotliWindow(size int64) int {
+	if size < 0 {
+		return brotliMaxWindow
+	}
+	lgwin := brotliMinWindow
+	for lgwin < brotliMaxWindow && int64(1)<<uint(lgwin)-16 < size {
+		lgwin++
+	}
+	return lgwin
+}
+
+// NewBrotli11Writer returns a writer that brotli compresses at optimal
+// compression (quality 11) to w. The size of the input is used to pick the
+// window, pass -1 if it is unknown.
+// The writer must be closed to flush the compressed stream.
+func NewBrotli11Writer(w io.Writer, size int64) io.WriteCloser {
+	return brotli.NewWriterOptions(w, brotli.WriterOptions{
+		Quality: brotliQuality,
+		LGWin:   brotliWindow(size),
+	})
+}
+
+// NewUnBrotliReader returns a reader that decompresses the brotli stream r.
+func NewUnBrotliReader(r io.Reader) io.Reader {
+	return brotli.NewReader(r)
+}
+
+// Brotli11Bytes returns brotli compressed bytes
+// at optimal compression (quality 11).
+func Brotli11Bytes(uncompressed []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w := NewBrotli11Writer(&b, int64(len(uncompressed)))
+	if _, err := w.Write(uncompressed); err != nil {
+		return nil, errors.Wrap(err, "could not compress")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not flush")
+	}
+	return b.Bytes(), nil
+}
+
+// Brotli11 brotli compresses a file into out
+// at optimal compression (quality 11).
+func Brotli11(ctx context.Context, src string, out string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "could not open source file")
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "could not stat source file")
+	}
+
+	dest, err := os.Create(out)
+	if err != nil {
+		return errors.Wrap(err, "could not create dest file")
+	}
+	defer dest.Close()
+
+	w := NewBrotli11Writer(dest, info.Size())
+	if _, err := io.Copy(w, in); err != nil {
+		return errors.Wrap(err, "could not compress")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "could not flush")
+	}
+	return dest.Close()
+}
+
+// UnBrotli uncompresses brotli compressed bytes.
+func UnBrotli(compressed []byte) ([]byte, error) {
+	res, err := ioutil.ReadAll(NewUnBrotliReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decompress")
+	}
+	return res, nil
+}
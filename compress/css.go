@@ -2,9 +2,6 @@ package compress
 
 import (
 	"context"
-	"log"
-	"os"
-	"os/exec"
 	"path"
 	"strings"
 )
@@ -14,35 +11,15 @@ var (
 	cleanCSS = "/node_modules/clean-css-cli/bin/cleancss"
 )
 
-// CSS performs a compression of the file.
-func CSS(ctx context.Context, file string) *string {
-	ext := path.Ext(file)
-	outfile := file[0:len(file)-len(ext)] + ".min.css"
-
-	// compressed file already exists, ignore
-	if _, err := os.Stat(outfile); err == nil {
-		log.Printf("%s already has a compressed version: %s\n", file, outfile)
-		return nil
-	}
-
+// CSS performs a compression of the file with the first of the minifiers
+// that succeeds.
+func CSS(ctx context.Context, file string, minifiers []Minifier) *MinifyResult {
 	// Already minified, ignore
 	if strings.HasSuffix(file, ".min.css") {
-		return nil
+		return &MinifyResult{File: file, SkipReason: "already minified"}
 	}
 
-	args := []string{
-		"--compatibility",
-		"--s0",
-		"-o", outfile,
-		file,
-	}
-
-	cmd := exec.Command(cleanCSS, args...)
-	log.Printf("compress: run %s\n", cmd)
-
-	if _, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("Failed to compress CSS: %v\n", err)
-		return nil
-	}
-	return &outfile
+	ext := path.Ext(file)
+	outfile := file[0:len(file)-len(ext)] + ".min.css"
+	return minify(ctx, minifiers, file, outfile)
 }
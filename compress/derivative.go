@@ -0,0 +1,82 @@
+package compress
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImageDerivative is an alternative format generated for PNG and JPEG files.
+type ImageDerivative struct {
+	// Name is the name of the format in the package configuration.
+	Name string
+	// Ext is the extension appended to the original file name.
+	Ext string
+
+	bin  string
+	args func(src, dest string) []string
+}
+
+var (
+	// WebP generates a lossless WebP for PNG files, and
+	// a high quality WebP for JPEG files.
+	WebP = &ImageDerivative{
+		Name: "webp",
+		Ext:  ".webp",
+		bin:  "cwebp",
+		args: func(src, dest string) []string {
+			quality := []string{"-q", "90"}
+			if isPng(src) {
+				quality = []string{"-lossless", "-z", "9"}
+			}
+			return append(quality, "-metadata", "icc", "-quiet", "-o", dest, src)
+		},
+	}
+	// AVIF generates an AVIF, lossless for PNG files.
+	AVIF = &ImageDerivative{
+		Name: "avif",
+		Ext:  ".avif",
+		bin:  "avifenc",
+		args: func(src, dest string) []string {
+			args := []string{"--speed", "4"}
+			if isPng(src) {
+				args = append(args, "--lossless")
+			}
+			return append(args, src, dest)
+		},
+	}
+
+	// ImageDerivatives lists the supported derivatives.
+	ImageDerivatives = []*ImageDerivative{WebP, AVIF}
+)
+
+// GetImageDerivative gets a derivative by name.
+func GetImageDerivative(name string) (*ImageDerivative, bool) {
+	for _, d := range ImageDerivatives {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// Generate converts the src image into dest.
+func (d *ImageDerivative) Generate(ctx context.Context, src, dest string) error {
+	cmd := exec.CommandContext(ctx, d.bin, d.args(src, dest)...)
+	log.Printf("compress: run %s\n", cmd)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(dest)
+		return errors.Wrapf(err, "%s failed: %s", d.bin, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func isPng(file string) bool {
+	return strings.HasSuffix(strings.ToLower(file), ".png")
+}
@@ -0,0 +1,146 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Encoding is a precompressed form in which files are published,
+// and served to clients that advertise it in Accept-Encoding.
+type Encoding struct {
+	// Name is the Content-Encoding token, for instance `br`.
+	Name string
+	// Ext is the extension appended to the precompressed files.
+	Ext string
+
+	encode func(ctx context.Context, src, out string, o EncodeOptions) error
+	decode func(compressed []byte) ([]byte, error)
+}
+
+// EncodeOptions tunes the encoders.
+type EncodeOptions struct {
+	// Gzip is the gzip backend, defaults to zopfli.
+	Gzip GzipBackend
+}
+
+var (
+	// Brotli is the brotli encoding, at quality 11.
+	Brotli = &Encoding{
+		Name: "br",
+		Ext:  ".br",
+		encode: func(ctx context.Context, src, out string, o EncodeOptions) error {
+			return Brotli11(ctx, src, out)
+		},
+		decode: UnBrotli,
+	}
+	// Gzip is the gzip encoding, using the configured backend.
+	Gzip = &Encoding{
+		Name: "gzip",
+		Ext:  ".gz",
+		encode: func(ctx context.Context, src, out string, o EncodeOptions) error {
+			backend := o.Gzip
+			if backend == nil {
+				backend = GzipZopfli(DefaultZopfliIterations)
+			}
+			return GzipFile(ctx, backend, src, out)
+		},
+		decode: gunzip,
+	}
+	// Zstd is the Zstandard encoding.
+	Zstd = &Encoding{
+		Name: "zstd",
+		Ext:  ".zst",
+		encode: func(ctx context.Context, src, out string, o EncodeOptions) error {
+			return ZstdFile(ctx, src, out)
+		},
+		decode: UnZstd,
+	}
+
+	// Encodings lists all the known encodings, any of them can be
+	// found in the output of process-version.
+	Encodings = []*Encoding{Brotli, Gzip, Zstd}
+
+	// DefaultEncodings are the encodings published for each
+	// compressible file, unless configured otherwise. Zstd is opt-in.
+	DefaultEncodings = []*Encoding{Brotli, Gzip}
+)
+
+// Encode compresses the src file into out.
+func (e *Encoding) Encode(ctx context.Context, src, out string, o EncodeOptions) error {
+	if err := e.encode(ctx, src, out, o); err != nil {
+		return errors.Wrapf(err, "could not %s encode", e.Name)
+	}
+	return nil
+}
+
+// Decode uncompresses a stream of this encoding.
+func (e *Encoding) Decode(r io.Reader) ([]byte, error) {
+	compressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read")
+	}
+	res, err := e.decode(compressed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not %s decode", e.Name)
+	}
+	return res, nil
+}
+
+// ParseEncodings parses a comma-separated list of encoding names,
+// for instance `br,gzip`.
+func ParseEncodings(names string) ([]*Encoding, error) {
+	var list []*Encoding
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		e, ok := GetEncoding(name)
+		if !ok {
+			return nil, errors.Errorf("unknown encoding: %s", name)
+		}
+		list = append(list, e)
+	}
+	if len(list) == 0 {
+		return nil, errors.Errorf("no encoding in `%s`", names)
+	}
+	return list, nil
+}
+
+// GetEncoding gets an encoding by name.
+func GetEncoding(name string) (*Encoding, bool) {
+	for _, e := range Encodings {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// SplitEncodingExt splits a precompressed file name into the name of
+// the original file and its encoding. The encoding is nil if the file
+// isn't precompressed.
+func SplitEncodingExt(name string) (string, *Encoding) {
+	ext := path.Ext(name)
+	for _, e := range Encodings {
+		if e.Ext == ext {
+			return name[0 : len(name)-len(ext)], e
+		}
+	}
+	return name, nil
+}
+
+func gunzip(compressed []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
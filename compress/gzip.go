@@ -0,0 +1,99 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/foobaz/go-zopfli/zopfli"
+	"github.com/pkg/errors"
+)
+
+// DefaultZopfliIterations is the number of zopfli iterations used
+// when a package doesn't configure it, same as the zopfli CLI.
+const DefaultZopfliIterations = 15
+
+// MaxZopfliIterations is the maximum number of zopfli iterations, as
+// allowed by the package schema, so that the processing of a version
+// doesn't exceed its deadline.
+const MaxZopfliIterations = 1000
+
+// GzipBackend is a gzip encoder.
+type GzipBackend interface {
+	// Name identifies the backend in logs.
+	Name() string
+
+	// Gzip writes the gzip compressed form of uncompressed to w.
+	Gzip(w io.Writer, uncompressed []byte) error
+}
+
+// GzipStdlib compresses using the standard library at optimal
+// compression (level 9). It is fast, but produces larger files than zopfli.
+var GzipStdlib GzipBackend = stdlibGzip{}
+
+// GzipZopfli returns a backend that compresses using a zopfli-compatible
+// deflate encoder with a number of iterations. More iterations produce
+// slightly smaller files at the cost of speed. The iterations default to
+// DefaultZopfliIterations and are capped to MaxZopfliIterations.
+func GzipZopfli(iterations int) GzipBackend {
+	if iterations < 1 {
+		iterations = DefaultZopfliIterations
+	}
+	if iterations > MaxZopfliIterations {
+		iterations = MaxZopfliIterations
+	}
+	return zopfliGzip{iterations}
+}
+
+// GzipBytes returns gzip compressed bytes using a backend.
+func GzipBytes(backend GzipBackend, uncompressed []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if err := backend.Gzip(&b, uncompressed); err != nil {
+		return nil, errors.Wrapf(err, "%s failed", backend.Name())
+	}
+	return b.Bytes(), nil
+}
+
+// GzipFile gzip compresses a file into out using a backend.
+func GzipFile(ctx context.Context, backend GzipBackend, src string, out string) error {
+	uncompressed, err := ioutil.ReadFile(src)
+	if err != nil {
+		return errors.Wrap(err, "could not read source file")
+	}
+
+	compressed, err := GzipBytes(backend, uncompressed)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(out, compressed, 0644)
+}
+
+type stdlibGzip struct{}
+
+func (stdlibGzip) Name() string { return "stdlib" }
+
+func (stdlibGzip) Gzip(w io.Writer, uncompressed []byte) error {
+	gw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := gw.Write(uncompressed); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+type zopfliGzip struct {
+	iterations int
+}
+
+func (zopfliGzip) Name() string { return "zopfli" }
+
+func (z zopfliGzip) Gzip(w io.Writer, uncompressed []byte) error {
+	options := zopfli.DefaultOptions()
+	options.NumIterations = z.iterations
+	return zopfli.GzipCompress(&options, uncompressed, w)
+}
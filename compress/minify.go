@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/pkg/errors"
+)
+
+// Minifier represents a JavaScript or CSS minifier.
+type Minifier interface {
+	// Name identifies the minifier in logs and in the MINIFY_JS/MINIFY_CSS
+	// configuration.
+	Name() string
+
+	// Minify minifies the src file into the dest file, and writes a source
+	// map to dest.map when the minifier supports it.
+	// Any non-fatal diagnostics are returned as warnings.
+	Minify(ctx context.Context, src, dest string) (warnings []string, err error)
+}
+
+// MinifyResult is the outcome of minifying a file.
+type MinifyResult struct {
+	File       string   `json:"file"`
+	Output     string   `json:"output,omitempty"`
+	Minifier   string   `json:"minifier,omitempty"`
+	SourceMap  string   `json:"sourceMap,omitempty"`
+	InputSize  int64    `json:"inputSize"`
+	OutputSize int64    `json:"outputSize,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+
+	// SkipReason is set when no minified file was produced.
+	SkipReason string `json:"skipReason,omitempty"`
+	// Failed is set when every minifier failed.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// Skipped returns true if no minified file was produced.
+func (r *MinifyResult) Skipped() bool {
+	return r.SkipReason != ""
+}
+
+// String summarizes the result for logging.
+func (r *MinifyResult) String() string {
+	if r.Skipped() {
+		return fmt.Sprintf("%s skipped: %s", r.File, r.SkipReason)
+	}
+	return fmt.Sprintf("%s -> %s with %s (%d -> %d bytes, %d warning(s))",
+		r.File, r.Output, r.Minifier, r.InputSize, r.OutputSize, len(r.Warnings))
+}
+
+var (
+	// ESBuildJS minifies JavaScript in-process and understands modern syntax.
+	ESBuildJS Minifier = esbuildMinifier{api.LoaderJS}
+	// ESBuildCSS minifies CSS in-process.
+	ESBuildCSS Minifier = esbuildMinifier{api.LoaderCSS}
+	// UglifyJS uses the uglify-js CLI.
+	UglifyJS Minifier = cliMinifier{"uglify-js", UGLIFYJS, uglifyArgs}
+	// UglifyES uses the uglify-es CLI.
+	UglifyES Minifier = cliMinifier{"uglify-es", UGLIFYES, uglifyArgs}
+	// CleanCSS uses the clean-css CLI.
+	CleanCSS Minifier = cliMinifier{"clean-css", cleanCSS, cleanCSSArgs}
+
+	// DefaultJsMinifiers are the JavaScript minifiers that are tried in
+	// order until one of them succeeds, unless configured otherwise.
+	DefaultJsMinifiers = []Minifier{ESBuildJS, UglifyJS, UglifyES}
+	// DefaultCSSMinifiers are the CSS minifiers that are tried in
+	// order until one of them succeeds, unless configured otherwise.
+	DefaultCSSMinifiers = []Minifier{ESBuildCSS, CleanCSS}
+
+	minifiersByName = map[string]Minifier{
+		"esbuild-js":  ESBuildJS,
+		"esbuild-css": ESBuildCSS,
+		"uglify-js":   UglifyJS,
+		"uglify-es":   UglifyES,
+		"clean-css":   CleanCSS,
+	}
+)
+
+// ParseMinifiers parses a comma-separated list of minifier names,
+// for instance `uglify-js,uglify-es`.
+func ParseMinifiers(names string) ([]Minifier, error) {
+	var list []Minifier
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		m, ok := minifiersByName[name]
+		if !ok {
+			return nil, errors.Errorf("unknown minifier: %s", name)
+		}
+		list = append(list, m)
+	}
+	if len(list) == 0 {
+		return nil, errors.Errorf("no minifier in `%s`", names)
+	}
+	return list, nil
+}
+
+// Minifies a file into outfile with the first minifier that succeeds.
+func minify(ctx context.Context, minifiers []Minifier, file, outfile string) *MinifyResult {
+	res := &MinifyResult{File: file}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		res.SkipReason = fmt.Sprintf("could not stat input: %s", err)
+		return res
+	}
+	res.InputSize = info.Size()
+
+	// compressed file already exists, ignore
+	if _, err := os.Stat(outfile); err == nil {
+		res.SkipReason = "minified file already exists: " + outfile
+		return res
+	}
+
+	var failures []string
+	for _, m := range minifiers {
+		warnings, err := m.Minify(ctx, file, outfile)
+		res.Warnings = append(res.Warnings, warnings...)
+		if err != nil {
+			log.Printf("%s failed to minify %s: %s\n", m.Name(), file, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", m.Name(), err))
+			os.Remove(outfile)
+			os.Remove(outfile + ".map")
+			continue
+		}
+
+		out, err := os.Stat(outfile)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: no output: %s", m.Name(), err))
+			continue
+		}
+		res.Output = outfile
+		if _, err := os.Stat(outfile + ".map"); err == nil {
+			res.SourceMap = outfile + ".map"
+		}
+		res.Minifier = m.Name()
+		res.OutputSize = out.Size()
+		return res
+	}
+
+	res.SkipReason = "minifier failure: " + strings.Join(failures, "; ")
+	res.Failed = true
+	return res
+}
+
+// Minifies in-process using esbuild.
+type esbuildMinifier struct {
+	loader api.Loader
+}
+
+func (m esbuildMinifier) Name() string {
+	if m.loader == api.LoaderCSS {
+		return "esbuild-css"
+	}
+	return "esbuild-js"
+}
+
+func (m esbuildMinifier) Minify(ctx context.Context, src, dest string) ([]string, error) {
+	code, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read input")
+	}
+
+	res := api.Transform(string(code), api.TransformOptions{
+		Loader:            m.loader,
+		Sourcefile:        path.Base(src),
+		Sourcemap:         api.SourceMapExternal,
+		SourcesContent:    api.SourcesContentExclude,
+		MinifyWhitespace:  true,
+		MinifyIdentifiers: true,
+		MinifySyntax:      true,
+		LegalComments:     api.LegalCommentsInline,
+	})
+
+	warnings := formatESBuildMessages(res.Warnings)
+	if len(res.Errors) > 0 {
+		return warnings, errors.New(strings.Join(formatESBuildMessages(res.Errors), "; "))
+	}
+
+	if err := ioutil.WriteFile(dest, res.Code, 0644); err != nil {
+		return warnings, errors.Wrap(err, "could not write output")
+	}
+	if err := ioutil.WriteFile(dest+".map", res.Map, 0644); err != nil {
+		return warnings, errors.Wrap(err, "could not write source map")
+	}
+	return warnings, nil
+}
+
+func formatESBuildMessages(msgs []api.Message) []string {
+	var out []string
+	for _, msg := range msgs {
+		if msg.Location != nil {
+			out = append(out, fmt.Sprintf("%d:%d: %s", msg.Location.Line, msg.Location.Column, msg.Text))
+		} else {
+			out = append(out, msg.Text)
+		}
+	}
+	return out
+}
+
+// Minifies using a CLI from /node_modules.
+type cliMinifier struct {
+	name string
+	bin  string
+	args func(src, dest string) []string
+}
+
+func (m cliMinifier) Name() string { return m.name }
+
+func (m cliMinifier) Minify(ctx context.Context, src, dest string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, m.bin, m.args(src, dest)...)
+	log.Printf("compress: run %s\n", cmd)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", strings.TrimSpace(string(out)))
+	}
+	if s := strings.TrimSpace(string(out)); s != "" {
+		return []string{s}, nil
+	}
+	return nil, nil
+}
+
+func uglifyArgs(src, dest string) []string {
+	return []string{
+		"--mangle",
+		"--compress",
+		"if_return=true",
+		"--source-map", fmt.Sprintf("base='%s',url='%s.map'", path.Dir(src), path.Base(dest)),
+		"-o", dest,
+		src,
+	}
+}
+
+func cleanCSSArgs(src, dest string) []string {
+	return []string{
+		"--compatibility",
+		"--s0",
+		"--source-map",
+		"-o", dest,
+		src,
+	}
+}
@@ -0,0 +1,435 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SourceMap represents a version 3 source map.
+type SourceMap struct {
+	Version        int       `json:"version"`
+	File           string    `json:"file,omitempty"`
+	SourceRoot     string    `json:"sourceRoot,omitempty"`
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent,omitempty"`
+	Names          []string  `json:"names"`
+	Mappings       string    `json:"mappings"`
+
+	// only used to detect sectioned source maps
+	Sections []json.RawMessage `json:"sections,omitempty"`
+}
+
+// A decoded mapping segment. All fields are absolute.
+type mapping struct {
+	genCol  int
+	hasSrc  bool
+	src     int
+	srcLine int
+	srcCol  int
+	hasName bool
+	name    int
+}
+
+var (
+	// matches both `//# sourceMappingURL=` and the legacy `//@ sourceMappingURL=`
+	jsSourceMappingURL = regexp.MustCompile(`(?m)^[ \t]*//[#@][ \t]+sourceMappingURL=([^\s'"]*)[ \t]*\r?\n?`)
+	// matches `/*# sourceMappingURL= */`
+	cssSourceMappingURL = regexp.MustCompile(`/\*[#@][ \t]+sourceMappingURL=([^\s'"*]*)[ \t]*\*/[ \t]*\r?\n?`)
+)
+
+func sourceMappingURLRegexp(file string) *regexp.Regexp {
+	if path.Ext(file) == ".css" {
+		return cssSourceMappingURL
+	}
+	return jsSourceMappingURL
+}
+
+// FindSourceMappingURL returns the last sourceMappingURL referenced in a
+// JavaScript or CSS file, or an empty string if there is none.
+func FindSourceMappingURL(file string, content []byte) string {
+	matches := sourceMappingURLRegexp(file).FindAllSubmatch(content, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return string(matches[len(matches)-1][1])
+}
+
+// SetSourceMappingURL removes all sourceMappingURL comments from a JavaScript
+// or CSS file and, if the url is not empty, appends a new one.
+func SetSourceMappingURL(file string, content []byte, url string) []byte {
+	out := sourceMappingURLRegexp(file).ReplaceAll(content, nil)
+	if url == "" {
+		return out
+	}
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	if path.Ext(file) == ".css" {
+		return append(out, []byte("/*# sourceMappingURL="+url+" */\n")...)
+	}
+	return append(out, []byte("//# sourceMappingURL="+url+"\n")...)
+}
+
+// IsRemoteSourceMappingURL returns true if the url doesn't point
+// to a file relative to the source, for instance a data URI or
+// an absolute URL.
+func IsRemoteSourceMappingURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && parsed.Scheme != ""
+}
+
+// ParseSourceMap parses a source map, rejecting sectioned
+// (indexed) source maps which are not supported.
+func ParseSourceMap(data []byte) (*SourceMap, error) {
+	// strip the optional XSSI prefix
+	data = bytes.TrimPrefix(data, []byte(")]}'"))
+
+	var m SourceMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "invalid source map")
+	}
+	if m.Version != 3 {
+		return nil, errors.Errorf("unsupported source map version %d", m.Version)
+	}
+	if len(m.Sections) > 0 {
+		return nil, errors.New("sectioned source maps are not supported")
+	}
+	if _, err := decodeMappings(m.Mappings); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// LoadSourceMap loads the source map referenced by a sourceMappingURL
+// found in file. Inline data URIs and relative paths are supported.
+// The returned path is the location of the map on disk, or an empty
+// string for data URIs.
+func LoadSourceMap(file, ref string) (*SourceMap, string, error) {
+	if strings.HasPrefix(ref, "data:") {
+		comma := strings.Index(ref, ",")
+		if comma < 0 {
+			return nil, "", errors.New("invalid data URI")
+		}
+		meta, payload := ref[len("data:"):comma], ref[comma+1:]
+		var data []byte
+		if strings.HasSuffix(meta, ";base64") {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(payload); err != nil {
+				return nil, "", errors.Wrap(err, "invalid base64 data URI")
+			}
+		} else {
+			unescaped, err := url.PathUnescape(payload)
+			if err != nil {
+				return nil, "", errors.Wrap(err, "invalid data URI")
+			}
+			data = []byte(unescaped)
+		}
+		m, err := ParseSourceMap(data)
+		return m, "", err
+	}
+
+	if IsRemoteSourceMappingURL(ref) || path.IsAbs(ref) {
+		return nil, "", errors.Errorf("source map is not local: %s", ref)
+	}
+
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	mapFile := path.Join(path.Dir(file), ref)
+	data, err := ioutil.ReadFile(mapFile)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not read source map")
+	}
+	m, err := ParseSourceMap(data)
+	return m, mapFile, err
+}
+
+// Marshal marshals the source map into JSON.
+func (m *SourceMap) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// RebaseSources rewrites the relative sources of a map loaded from
+// fromDir so that they resolve from toDir instead.
+func (m *SourceMap) RebaseSources(fromDir, toDir string) {
+	if m.SourceRoot != "" || path.Clean(fromDir) == path.Clean(toDir) {
+		return
+	}
+	for i, source := range m.Sources {
+		if IsRemoteSourceMappingURL(source) || path.IsAbs(source) {
+			continue
+		}
+		m.Sources[i] = relPath(toDir, path.Join(fromDir, source))
+	}
+}
+
+// Computes a relative slash-separated path from the directory
+// base to target, both being absolute or both being relative.
+func relPath(base, target string) string {
+	baseParts := strings.Split(path.Clean(base), "/")
+	targetParts := strings.Split(path.Clean(target), "/")
+	i := 0
+	for i < len(baseParts) && i < len(targetParts) && baseParts[i] == targetParts[i] {
+		i++
+	}
+	var parts []string
+	for j := i; j < len(baseParts); j++ {
+		if baseParts[j] != "." {
+			parts = append(parts, "..")
+		}
+	}
+	parts = append(parts, targetParts[i:]...)
+	return strings.Join(parts, "/")
+}
+
+// ChainSourceMap composes two source maps: outer maps a generated file to an
+// intermediate file (for instance a minified file to its input), and inner
+// maps that intermediate file to the original sources. The result maps the
+// generated file directly to the original sources.
+// Positions of the generated file that cannot be traced back are dropped.
+func ChainSourceMap(outer, inner *SourceMap) (*SourceMap, error) {
+	outerLines, err := decodeMappings(outer.Mappings)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid outer mappings")
+	}
+	innerLines, err := decodeMappings(inner.Mappings)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid inner mappings")
+	}
+
+	res := &SourceMap{
+		Version:    3,
+		File:       outer.File,
+		SourceRoot: inner.SourceRoot,
+		Sources:    []string{},
+		Names:      []string{},
+	}
+	sources := make(map[int]int)
+	names := make(map[string]int)
+
+	lines := make([][]mapping, len(outerLines))
+	for i, line := range outerLines {
+		for _, seg := range line {
+			if !seg.hasSrc {
+				continue
+			}
+			orig, ok := lookupMapping(innerLines, seg.srcLine, seg.srcCol)
+			if !ok {
+				continue
+			}
+
+			src, ok := sources[orig.src]
+			if !ok {
+				src = len(res.Sources)
+				sources[orig.src] = src
+				res.Sources = append(res.Sources, sliceString(inner.Sources, orig.src))
+				if len(inner.SourcesContent) > 0 {
+					var content *string
+					if orig.src < len(inner.SourcesContent) {
+						content = inner.SourcesContent[orig.src]
+					}
+					res.SourcesContent = append(res.SourcesContent, content)
+				}
+			}
+
+			chained := mapping{
+				genCol:  seg.genCol,
+				hasSrc:  true,
+				src:     src,
+				srcLine: orig.srcLine,
+				srcCol:  orig.srcCol,
+			}
+
+			// prefer the original name, fallback to the intermediate one
+			var name string
+			if orig.hasName {
+				name = sliceString(inner.Names, orig.name)
+			} else if seg.hasName {
+				name = sliceString(outer.Names, seg.name)
+			}
+			if name != "" {
+				idx, ok := names[name]
+				if !ok {
+					idx = len(res.Names)
+					names[name] = idx
+					res.Names = append(res.Names, name)
+				}
+				chained.hasName = true
+				chained.name = idx
+			}
+
+			lines[i] = append(lines[i], chained)
+		}
+	}
+
+	res.Mappings = encodeMappings(lines)
+	return res, nil
+}
+
+func sliceString(list []string, i int) string {
+	if i < 0 || i >= len(list) {
+		return ""
+	}
+	return list[i]
+}
+
+// Finds the segment on a line with the greatest generated column
+// lower or equal to col.
+func lookupMapping(lines [][]mapping, line, col int) (mapping, bool) {
+	if line < 0 || line >= len(lines) {
+		return mapping{}, false
+	}
+	var found mapping
+	var ok bool
+	for _, seg := range lines[line] {
+		if seg.genCol > col {
+			break
+		}
+		if seg.hasSrc {
+			found, ok = seg, true
+		}
+	}
+	return found, ok
+}
+
+const base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+var base64Values = func() [256]int {
+	var values [256]int
+	for i := range values {
+		values[i] = -1
+	}
+	for i := 0; i < len(base64Chars); i++ {
+		values[base64Chars[i]] = i
+	}
+	return values
+}()
+
+// Decodes the mappings field into absolute segments, one slice per line.
+// Segments of a line are sorted by generated column.
+func decodeMappings(s string) ([][]mapping, error) {
+	var lines [][]mapping
+	var line []mapping
+	var src, srcLine, srcCol, name int
+
+	for _, rawLine := range strings.Split(s, ";") {
+		genCol := 0
+		line = nil
+		for _, rawSeg := range strings.Split(rawLine, ",") {
+			if rawSeg == "" {
+				continue
+			}
+			fields, err := decodeVLQs(rawSeg)
+			if err != nil {
+				return nil, err
+			}
+
+			var seg mapping
+			switch len(fields) {
+			case 1, 4, 5:
+			default:
+				return nil, errors.Errorf("invalid mapping segment `%s`", rawSeg)
+			}
+			genCol += fields[0]
+			seg.genCol = genCol
+			if len(fields) >= 4 {
+				src += fields[1]
+				srcLine += fields[2]
+				srcCol += fields[3]
+				seg.hasSrc, seg.src, seg.srcLine, seg.srcCol = true, src, srcLine, srcCol
+			}
+			if len(fields) == 5 {
+				name += fields[4]
+				seg.hasName, seg.name = true, name
+			}
+			line = append(line, seg)
+		}
+		sort.SliceStable(line, func(i, j int) bool { return line[i].genCol < line[j].genCol })
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func decodeVLQs(s string) ([]int, error) {
+	var out []int
+	value, shift := 0, uint(0)
+	for i := 0; i < len(s); i++ {
+		digit := base64Values[s[i]]
+		if digit < 0 {
+			return nil, errors.Errorf("invalid base64 VLQ character `%c`", s[i])
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			out = append(out, -(value >> 1))
+		} else {
+			out = append(out, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, errors.New("truncated base64 VLQ")
+	}
+	return out, nil
+}
+
+// Encodes absolute segments back into the mappings field.
+func encodeMappings(lines [][]mapping) string {
+	var b strings.Builder
+	var src, srcLine, srcCol, name int
+
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		genCol := 0
+		for j, seg := range line {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			encodeVLQ(&b, seg.genCol-genCol)
+			genCol = seg.genCol
+			if seg.hasSrc {
+				encodeVLQ(&b, seg.src-src)
+				encodeVLQ(&b, seg.srcLine-srcLine)
+				encodeVLQ(&b, seg.srcCol-srcCol)
+				src, srcLine, srcCol = seg.src, seg.srcLine, seg.srcCol
+				if seg.hasName {
+					encodeVLQ(&b, seg.name-name)
+					name = seg.name
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+func encodeVLQ(b *strings.Builder, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 31
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 32
+		}
+		b.WriteByte(base64Chars[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
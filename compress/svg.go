@@ -0,0 +1,445 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// namespaces of the data that vector editors leave in SVG files,
+	// which is not used for rendering
+	svgEditorNamespaces = map[string]bool{
+		"http://www.inkscape.org/namespaces/inkscape":            true,
+		"http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd":     true,
+		"http://www.bohemiancoding.com/sketch/ns":                true,
+		"http://www.figma.com/figma/ns":                          true,
+		"http://ns.adobe.com/AdobeIllustrator/10.0/":             true,
+		"http://ns.adobe.com/Graphs/1.0/":                        true,
+		"http://ns.adobe.com/AdobeSVGViewerExtensions/3.0/":      true,
+		"http://ns.adobe.com/Variables/1.0/":                     true,
+		"http://ns.adobe.com/SaveForWeb/1.0/":                    true,
+		"http://ns.adobe.com/Extensibility/1.0/":                 true,
+		"http://ns.adobe.com/Flows/1.0/":                         true,
+		"http://ns.adobe.com/ImageReplacement/1.0/":              true,
+		"http://ns.adobe.com/GenericCustomNamespace/1.0/":        true,
+		"http://ns.adobe.com/XPath/1.0/":                         true,
+		"http://schemas.microsoft.com/visio/2003/SVGExtensions/": true,
+		"http://taptrix.com/vectorillustrator/svg_extensions":    true,
+	}
+	// namespaces only used in <metadata>, their declarations
+	// are removed with it if they are not used anywhere else
+	svgMetadataNamespaces = map[string]bool{
+		"http://www.w3.org/1999/02/22-rdf-syntax-ns#": true,
+		"http://purl.org/dc/elements/1.1/":            true,
+		"http://creativecommons.org/ns#":              true,
+		"http://web.resource.org/cc/":                 true,
+	}
+	// elements in which whitespace is significant
+	svgPreserveWhitespace = map[string]bool{
+		"text":          true,
+		"tspan":         true,
+		"textPath":      true,
+		"title":         true,
+		"desc":          true,
+		"style":         true,
+		"script":        true,
+		"foreignObject": true,
+	}
+)
+
+// Svg performs an in-place minification of the file. The file is left
+// untouched if it can't be parsed.
+func Svg(ctx context.Context, file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "could not read file")
+	}
+
+	minified, err := MinifySVG(content)
+	if err != nil {
+		return errors.Wrap(err, "could not minify")
+	}
+	log.Printf("compress: svg %s (%d -> %d bytes)\n", file, len(content), len(minified))
+
+	return ioutil.WriteFile(file, minified, 0644)
+}
+
+// MinifySVG conservatively minifies an SVG document, without changing
+// how it renders. It removes:
+//   - comments, except the ones starting with `<!--!`
+//   - <metadata> elements
+//   - elements and attributes in the namespaces of vector editors
+//   - whitespace between elements, except where it is significant
+//
+// Everything else, including attribute values, is kept as is.
+func MinifySVG(src []byte) ([]byte, error) {
+	tokens, err := tokenizeSVG(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	editorPrefixes, metadataPrefixes := svgPrefixes(tokens)
+
+	// first pass: remove the cruft
+	var kept []*svgToken
+	var stack []*svgToken
+	preserve := 0 // number of open elements preserving whitespace
+	skip := 0     // depth inside a removed element
+
+	for _, t := range tokens {
+		if skip > 0 {
+			switch t.kind {
+			case svgStartTag:
+				if !t.selfClosing {
+					skip++
+				}
+			case svgEndTag:
+				skip--
+			}
+			continue
+		}
+
+		switch t.kind {
+		case svgComment:
+			if strings.HasPrefix(t.raw, "<!--!") {
+				kept = append(kept, t)
+			}
+		case svgText:
+			if preserve == 0 && strings.TrimSpace(t.raw) == "" {
+				continue
+			}
+			kept = append(kept, t)
+		case svgStartTag:
+			if t.name == "metadata" || editorPrefixes[svgPrefix(t.name)] {
+				if !t.selfClosing {
+					skip = 1
+				}
+				continue
+			}
+			t.attrs = filterSVGAttrs(t.attrs, editorPrefixes)
+			kept = append(kept, t)
+			if !t.selfClosing {
+				stack = append(stack, t)
+				if t.preservesWhitespace() {
+					preserve++
+				}
+			}
+		case svgEndTag:
+			if len(stack) == 0 || stack[len(stack)-1].name != t.name {
+				return nil, errors.Errorf("unexpected closing tag </%s>", t.name)
+			}
+			if stack[len(stack)-1].preservesWhitespace() {
+				preserve--
+			}
+			stack = stack[:len(stack)-1]
+			kept = append(kept, t)
+		default:
+			kept = append(kept, t)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, errors.Errorf("unclosed tag <%s>", stack[len(stack)-1].name)
+	}
+
+	// second pass: remove the declarations of metadata namespaces
+	// that are not used anymore
+	used := make(map[string]bool)
+	for _, t := range kept {
+		if t.kind != svgStartTag && t.kind != svgEndTag {
+			continue
+		}
+		used[svgPrefix(t.name)] = true
+		for _, a := range t.attrs {
+			if p := svgPrefix(a.name); p != "xmlns" {
+				used[p] = true
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	for _, t := range kept {
+		if t.kind != svgStartTag {
+			out.WriteString(t.raw)
+			continue
+		}
+
+		out.WriteString("<" + t.name)
+		for _, a := range t.attrs {
+			if p := strings.TrimPrefix(a.name, "xmlns:"); p != a.name && metadataPrefixes[p] && !used[p] {
+				continue
+			}
+			out.WriteString(" " + a.name)
+			if a.value != "" {
+				out.WriteString("=" + a.value)
+			}
+		}
+		if t.selfClosing {
+			out.WriteString("/>")
+		} else {
+			out.WriteString(">")
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// Finds the prefixes bound to editor and metadata namespaces. A prefix
+// bound to several namespaces is only considered if they all are.
+func svgPrefixes(tokens []*svgToken) (editor, metadata map[string]bool) {
+	editor, metadata = make(map[string]bool), make(map[string]bool)
+	other := make(map[string]bool)
+
+	for _, t := range tokens {
+		if t.kind != svgStartTag {
+			continue
+		}
+		for _, a := range t.attrs {
+			if !strings.HasPrefix(a.name, "xmlns:") {
+				continue
+			}
+			p, uri := a.name[len("xmlns:"):], svgUnquote(a.value)
+			switch {
+			case svgEditorNamespaces[uri]:
+				editor[p] = true
+			case svgMetadataNamespaces[uri]:
+				metadata[p] = true
+			default:
+				other[p] = true
+			}
+		}
+	}
+	for p := range other {
+		delete(editor, p)
+		delete(metadata, p)
+	}
+	return editor, metadata
+}
+
+func filterSVGAttrs(attrs []svgAttr, editorPrefixes map[string]bool) []svgAttr {
+	var out []svgAttr
+	for _, a := range attrs {
+		if editorPrefixes[svgPrefix(a.name)] {
+			continue
+		}
+		if p := strings.TrimPrefix(a.name, "xmlns:"); p != a.name && editorPrefixes[p] {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// Returns the namespace prefix of a name, or an empty string.
+func svgPrefix(name string) string {
+	if i := strings.IndexByte(name, ':'); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func svgUnquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+type svgTokenKind int
+
+const (
+	svgText svgTokenKind = iota
+	svgStartTag
+	svgEndTag
+	svgComment
+	svgOther // CDATA, processing instructions and DOCTYPE, kept as is
+)
+
+type svgAttr struct {
+	name  string
+	value string // raw value, including its quotes
+}
+
+type svgToken struct {
+	kind        svgTokenKind
+	raw         string
+	name        string
+	attrs       []svgAttr
+	selfClosing bool
+}
+
+func (t *svgToken) preservesWhitespace() bool {
+	if svgPreserveWhitespace[t.name] {
+		return true
+	}
+	for _, a := range t.attrs {
+		if a.name == "xml:space" && svgUnquote(a.value) == "preserve" {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits an XML document into tokens. This is not a validating
+// parser, it only understands enough of XML to rewrite SVG files.
+func tokenizeSVG(s string) ([]*svgToken, error) {
+	var tokens []*svgToken
+
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			end := strings.IndexByte(s[i:], '<')
+			if end == -1 {
+				end = len(s) - i
+			}
+			tokens = append(tokens, &svgToken{kind: svgText, raw: s[i : i+end]})
+			i += end
+			continue
+		}
+
+		rest := s[i:]
+		var (
+			t   *svgToken
+			n   int
+			err error
+		)
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			n, err = svgIndexEnd(rest, "-->")
+			t = &svgToken{kind: svgComment}
+		case strings.HasPrefix(rest, "<![CDATA["):
+			n, err = svgIndexEnd(rest, "]]>")
+			t = &svgToken{kind: svgOther}
+		case strings.HasPrefix(rest, "<?"):
+			n, err = svgIndexEnd(rest, "?>")
+			t = &svgToken{kind: svgOther}
+		case strings.HasPrefix(rest, "<!"):
+			n, err = svgIndexDoctypeEnd(rest)
+			t = &svgToken{kind: svgOther}
+		case strings.HasPrefix(rest, "</"):
+			n, err = svgIndexEnd(rest, ">")
+			if err == nil {
+				t = &svgToken{kind: svgEndTag, name: strings.TrimSpace(rest[2 : n-1])}
+			}
+		default:
+			t, n, err = parseSVGStartTag(rest)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "at offset %d", i)
+		}
+		t.raw = rest[:n]
+		tokens = append(tokens, t)
+		i += n
+	}
+	return tokens, nil
+}
+
+// Returns the length of s up to and including the end delimiter.
+func svgIndexEnd(s, end string) (int, error) {
+	i := strings.Index(s, end)
+	if i == -1 {
+		return 0, errors.Errorf("missing %s", end)
+	}
+	return i + len(end), nil
+}
+
+// Returns the length of a DOCTYPE, which can contain an internal subset.
+func svgIndexDoctypeEnd(s string) (int, error) {
+	depth := 0
+	var quote byte
+	for i := 2; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '>' && depth == 0:
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("unterminated declaration")
+}
+
+func parseSVGStartTag(s string) (*svgToken, int, error) {
+	t := &svgToken{kind: svgStartTag}
+
+	i := 1
+	for i < len(s) && !isSVGSpace(s[i]) && s[i] != '/' && s[i] != '>' {
+		i++
+	}
+	t.name = s[1:i]
+	if t.name == "" {
+		return nil, 0, errors.New("missing tag name")
+	}
+
+	for {
+		for i < len(s) && isSVGSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, errors.Errorf("unterminated tag <%s>", t.name)
+		}
+
+		switch s[i] {
+		case '>':
+			return t, i + 1, nil
+		case '/':
+			if i+1 < len(s) && s[i+1] == '>' {
+				t.selfClosing = true
+				return t, i + 2, nil
+			}
+			return nil, 0, errors.Errorf("unexpected / in tag <%s>", t.name)
+		}
+
+		start := i
+		for i < len(s) && !isSVGSpace(s[i]) && s[i] != '=' && s[i] != '/' && s[i] != '>' {
+			i++
+		}
+		if i == start {
+			return nil, 0, errors.Errorf("missing attribute name in tag <%s>", t.name)
+		}
+		attr := svgAttr{name: s[start:i]}
+
+		j := i
+		for j < len(s) && isSVGSpace(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '=' {
+			j++
+			for j < len(s) && isSVGSpace(s[j]) {
+				j++
+			}
+			if j >= len(s) {
+				return nil, 0, errors.Errorf("unterminated tag <%s>", t.name)
+			}
+			valueStart := j
+			if q := s[j]; q == '"' || q == '\'' {
+				end := strings.IndexByte(s[j+1:], q)
+				if end == -1 {
+					return nil, 0, errors.Errorf("unterminated attribute %s", attr.name)
+				}
+				j += end + 2
+			} else {
+				for j < len(s) && !isSVGSpace(s[j]) && s[j] != '>' {
+					j++
+				}
+			}
+			attr.value = s[valueStart:j]
+			i = j
+		}
+		t.attrs = append(t.attrs, attr)
+	}
+}
+
+func isSVGSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
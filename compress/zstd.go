@@ -0,0 +1,49 @@
+package compress
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// ZstdBytes returns zstd compressed bytes at the best compression.
+func ZstdBytes(uncompressed []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create encoder")
+	}
+	defer enc.Close()
+	return enc.EncodeAll(uncompressed, nil), nil
+}
+
+// ZstdFile zstd compresses a file into out at the best compression.
+func ZstdFile(ctx context.Context, src string, out string) error {
+	uncompressed, err := ioutil.ReadFile(src)
+	if err != nil {
+		return errors.Wrap(err, "could not read source file")
+	}
+
+	compressed, err := ZstdBytes(uncompressed)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(out, compressed, 0644)
+}
+
+// UnZstd uncompresses zstd compressed bytes.
+func UnZstd(compressed []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create decoder")
+	}
+	defer dec.Close()
+
+	res, err := dec.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decompress")
+	}
+	return res, nil
+}
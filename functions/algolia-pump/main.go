@@ -12,15 +12,18 @@ import (
 	"os"
 	"path/filepath"
 
-	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/pkg/errors"
 
 	"github.com/cdnjs/tools/algolia"
+	"github.com/cdnjs/tools/archive"
 	"github.com/cdnjs/tools/audit"
+	"github.com/cdnjs/tools/compress"
 	"github.com/cdnjs/tools/gcp"
 	"github.com/cdnjs/tools/kv"
+	"github.com/cdnjs/tools/manifest"
 	"github.com/cdnjs/tools/packages"
 	"github.com/cdnjs/tools/sentry"
+	"github.com/cdnjs/tools/sri"
 )
 
 var (
@@ -29,13 +32,13 @@ var (
 	CF_ACCOUNT_ID = os.Getenv("CF_ACCOUNT_ID")
 )
 
-func getExistingVersions(p *packages.Package) ([]string, error) {
-	cfapi, err := cloudflare.NewWithAPIToken(KV_TOKEN, cloudflare.UsingAccount(CF_ACCOUNT_ID))
+func getExistingVersions(ctx context.Context, p *packages.Package) ([]string, error) {
+	ns, err := kv.NewNamespaces(KV_TOKEN, CF_ACCOUNT_ID)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cloudflare API client")
+		return nil, errors.Wrap(err, "failed to open kv namespaces")
 	}
 
-	versions, err := kv.GetVersions(cfapi, *p.Name)
+	versions, err := kv.GetVersions(ctx, ns.Versions, *p.Name)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get verions")
 	}
@@ -63,40 +66,64 @@ func Invoke(ctx context.Context, e gcp.GCSEvent) error {
 		return fmt.Errorf("could not decode config: %v", err)
 	}
 	// update package version with latest
-	versions, err := getExistingVersions(pkg)
+	versions, err := getExistingVersions(ctx, pkg)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve existing versions: %s", err)
 	}
-	archive, err := gcp.ReadObject(ctx, e.Bucket, e.Name)
+	data, err := gcp.ReadObject(ctx, e.Bucket, e.Name)
 	if err != nil {
 		return fmt.Errorf("could not read object: %v", err)
 	}
 
 	sris := make(map[string]string)
-	files := make([]string, 0)
+	var result *manifest.Manifest
+	entries := make([]string, 0)
 	onFile := func(name string, r io.Reader) error {
-		ext := filepath.Ext(name)
 		// remove leading slash
 		name = name[1:]
-		filename := name[0 : len(name)-len(ext)]
 
-		if ext == ".sri" {
+		if name == manifest.Name {
 			content, err := ioutil.ReadAll(r)
 			if err != nil {
 				return errors.Wrap(err, "could not read file")
 			}
-			sris[filename] = string(content)
+			result, err = manifest.Parse(content)
+			if err != nil {
+				return errors.Wrap(err, "could not parse manifest")
+			}
+			return nil
 		}
 
-		if ext == ".gz" || ext == ".woff2" {
-			files = append(files, filename)
+		if name == sri.ManifestFile {
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return errors.Wrap(err, "could not read file")
+			}
+			integrity, err := sri.ParseManifest(content)
+			if err != nil {
+				return errors.Wrap(err, "could not parse integrity manifest")
+			}
+			for _, file := range integrity.Files() {
+				i, _ := integrity.Get(file)
+				sris[file] = i.SHA512
+			}
+			return nil
 		}
+
+		entries = append(entries, name)
 		return nil
 	}
-	if err := gcp.Inflate(bytes.NewReader(archive), onFile); err != nil {
+	if err := archive.Inflate(bytes.NewReader(data), onFile); err != nil {
 		return fmt.Errorf("could not inflate archive: %s", err)
 	}
 
+	var files []string
+	if result != nil {
+		files = result.Names()
+	} else {
+		files = publishedFiles(entries)
+	}
+
 	log.Printf("%s: %d files, SRIs: %s\n", pkgName, len(files), sris)
 
 	if len(files) > 0 {
@@ -128,6 +155,24 @@ func Invoke(ctx context.Context, e gcp.GCSEvent) error {
 	return nil
 }
 
+// Lists the files published in an archive without manifest, from the
+// extension of its entries.
+func publishedFiles(entries []string) []string {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range entries {
+		filename, enc := compress.SplitEncodingExt(name)
+		if enc == nil && filepath.Ext(name) != ".woff2" {
+			continue
+		}
+		if !seen[filename] {
+			seen[filename] = true
+			files = append(files, filename)
+		}
+	}
+	return files
+}
+
 func printStrPtr(v *string) string {
 	if v == nil {
 		return "<nil>"
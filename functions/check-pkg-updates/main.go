@@ -1,6 +1,7 @@
 package check_pkg_updates
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -9,12 +10,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cdnjs/tools/git"
 	"github.com/cdnjs/tools/kv"
+	"github.com/cdnjs/tools/npm"
 	"github.com/cdnjs/tools/packages"
 	"github.com/cdnjs/tools/sentry"
 	"github.com/cdnjs/tools/util"
 
-	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/pkg/errors"
 )
 
@@ -29,18 +31,20 @@ type APIPackage struct {
 	Versions []string `json:"versions"`
 }
 
-func getExistingVersions(p *packages.Package) ([]string, error) {
-	cfapi, err := cloudflare.NewWithAPIToken(KV_TOKEN, cloudflare.UsingAccount(CF_ACCOUNT_ID))
+// Gets the versions in KV, and the yanked versions, which count as existing
+// so that they aren't published again.
+func getExistingVersions(ctx context.Context, ns *kv.Namespaces, p *packages.Package) ([]string, error) {
+	versions, err := kv.GetVersions(ctx, ns.Versions, *p.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cloudflare API client")
+		return nil, errors.Wrap(err, "failed to get verions")
 	}
 
-	versions, err := kv.GetVersions(cfapi, *p.Name)
+	yanked, err := kv.GetYankedVersions(ctx, ns, *p.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get verions")
+		return nil, err
 	}
 
-	return versions, nil
+	return append(versions, yanked...), nil
 }
 
 func Invoke(w http.ResponseWriter, r *http.Request) {
@@ -58,19 +62,39 @@ func Invoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ns, err := kv.NewNamespaces(KV_TOKEN, CF_ACCOUNT_ID)
+	if err != nil {
+		http.Error(w, "failed to open kv namespaces", 500)
+		fmt.Println(err)
+		return
+	}
+
 	// shuffle package order
 	rand.Seed(time.Now().UnixNano())
 	rand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
 
 	for _, pkg := range list {
-		if err := checkPackage(pkg); err != nil {
+		if err := checkPackage(ns, pkg); err != nil {
 			log.Printf("failed to update package %s: %s", *pkg.Name, err)
+			if isRateLimited(err) {
+				// the next packages would fail the same way
+				log.Printf("stopping: %s\n", errors.Cause(err))
+				break
+			}
 		}
 	}
 
 	fmt.Fprint(w, "OK")
 }
 
+func isRateLimited(err error) bool {
+	switch errors.Cause(err).(type) {
+	case git.RateLimitError, npm.RateLimitError:
+		return true
+	}
+	return false
+}
+
 func isAllowed(pkg string) bool {
 	if os.Getenv("RESTRICT_PKGS") == "" {
 		return true
@@ -83,7 +107,7 @@ func isAllowed(pkg string) bool {
 	return false
 }
 
-func checkPackage(pkg *packages.Package) error {
+func checkPackage(ns *kv.Namespaces, pkg *packages.Package) error {
 	if !isAllowed(*pkg.Name) {
 		return nil
 	}
@@ -105,7 +129,7 @@ func checkPackage(pkg *packages.Package) error {
 	switch src {
 	case "npm", "git":
 		{
-			if err := updatePackage(ctx, pkg, src); err != nil {
+			if err := updatePackage(ctx, ns, pkg, src); err != nil {
 				return errors.Wrap(err, "failed to update package via "+src)
 			}
 		}
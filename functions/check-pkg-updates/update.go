@@ -2,14 +2,16 @@ package check_pkg_updates
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
-	"path"
+	"os"
 	"sort"
 	"strings"
 
 	"github.com/cdnjs/tools/audit"
 	"github.com/cdnjs/tools/gcp"
 	"github.com/cdnjs/tools/git"
+	"github.com/cdnjs/tools/kv"
 	"github.com/cdnjs/tools/npm"
 	"github.com/cdnjs/tools/packages"
 	"github.com/cdnjs/tools/util"
@@ -18,8 +20,8 @@ import (
 	"github.com/pkg/errors"
 )
 
-func updatePackage(ctx context.Context, pkg *packages.Package, src string) error {
-	existingVersionSet, err := getExistingVersions(pkg)
+func updatePackage(ctx context.Context, ns *kv.Namespaces, pkg *packages.Package, src string) error {
+	existingVersionSet, err := getExistingVersions(ctx, ns, pkg)
 	if err != nil {
 		return errors.Wrap(err, "could not detect existing versions")
 	}
@@ -34,7 +36,10 @@ func updatePackage(ctx context.Context, pkg *packages.Package, src string) error
 			return errors.Wrap(err, "failed to get git versions")
 		}
 	case "npm":
-		versions, _ = npm.GetVersions(ctx, pkg.Autoupdate)
+		versions, _, err = npm.GetVersions(ctx, pkg.Autoupdate)
+		if err != nil {
+			return errors.Wrap(err, "failed to get npm versions")
+		}
 	default:
 		panic("unreachable")
 	}
@@ -97,8 +102,29 @@ func DoUpdate(ctx context.Context, pkg *packages.Package, versions []version.Ver
 	v := versions[0]
 
 	log.Printf("%s: new version detected: %s\n", *pkg.Name, v.Version)
-	tarball := version.DownloadTar(ctx, v)
-	if err := gcp.AddIncomingFile(path.Base(v.Tarball), tarball, pkg, v); err != nil {
+	file, err := ioutil.TempFile("", "version")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := version.Download(ctx, &v, file.Name()); err != nil {
+		// the version isn't stored, record why
+		if downloadErr, ok := err.(version.DownloadError); ok {
+			if err := audit.DownloadFailed(ctx, *pkg.Name, v.Version, downloadErr.Reason, downloadErr.Err); err != nil {
+				log.Printf("%s: could not audit: %s\n", *pkg.Name, err)
+			}
+		}
+		return errors.Wrap(err, "could not download")
+	}
+
+	tarball, err := os.Open(file.Name())
+	if err != nil {
+		return errors.Wrap(err, "could not open download")
+	}
+	defer tarball.Close()
+	if err := gcp.AddIncomingFile(v.FileName(), tarball, pkg, v); err != nil {
 		return errors.Wrap(err, "could not store in GCS: %s")
 	}
 
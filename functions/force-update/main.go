@@ -4,9 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"path"
+	"os"
 
 	"github.com/cdnjs/tools/audit"
 	"github.com/cdnjs/tools/gcp"
@@ -50,7 +51,12 @@ func Invoke(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 			case "npm":
-				versions, _ = npm.GetVersions(ctx, pkg.Autoupdate)
+				versions, _, err = npm.GetVersions(ctx, pkg.Autoupdate)
+				if err != nil {
+					http.Error(w, "failed to fetch versions", 500)
+					fmt.Println(err)
+					return
+				}
 			default:
 				panic("unreachable")
 			}
@@ -67,8 +73,24 @@ func Invoke(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "target version not found", 500)
 				return
 			}
-			tarball := version.DownloadTar(ctx, *targetVersion)
-			if err := gcp.AddIncomingFile(path.Base(targetVersion.Tarball), tarball, pkg, *targetVersion); err != nil {
+			file, err := ioutil.TempFile("", "version")
+			if err != nil {
+				log.Fatalf("could not create temporary file: %s", err)
+			}
+			file.Close()
+			defer os.Remove(file.Name())
+
+			if err := version.Download(ctx, targetVersion, file.Name()); err != nil {
+				http.Error(w, "failed to download version", 500)
+				fmt.Println(err)
+				return
+			}
+			tarball, err := os.Open(file.Name())
+			if err != nil {
+				log.Fatalf("could not open download: %s", err)
+			}
+			defer tarball.Close()
+			if err := gcp.AddIncomingFile(targetVersion.FileName(), tarball, pkg, *targetVersion); err != nil {
 				log.Fatalf("could not store in GCS: %s", err)
 			}
 			if err := audit.NewVersionDetected(ctx, *pkg.Name, targetVersion.Version); err != nil {
@@ -12,23 +12,25 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
+	"github.com/cdnjs/tools/archive"
 	"github.com/cdnjs/tools/audit"
+	"github.com/cdnjs/tools/compress"
 	"github.com/cdnjs/tools/gcp"
 	"github.com/cdnjs/tools/kv"
+	"github.com/cdnjs/tools/manifest"
 	"github.com/cdnjs/tools/packages"
 	"github.com/cdnjs/tools/sentry"
+	"github.com/cdnjs/tools/sri"
 
-	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/pkg/errors"
 )
 
 var (
-	KV_TOKEN              = os.Getenv("KV_TOKEN")
-	CF_ACCOUNT_ID         = os.Getenv("CF_ACCOUNT_ID")
-	FILES_KV_NAMESPACE_ID = os.Getenv("FILES_KV_NAMESPACE_ID")
-	SRI_KV_NAMESPACE      = os.Getenv("WORKERS_KV_SRIS_NAMESPACE_ID")
+	KV_TOKEN      = os.Getenv("KV_TOKEN")
+	CF_ACCOUNT_ID = os.Getenv("CF_ACCOUNT_ID")
 )
 
 func Invoke(ctx context.Context, e gcp.GCSEvent) error {
@@ -44,87 +46,124 @@ func Invoke(ctx context.Context, e gcp.GCSEvent) error {
 		return fmt.Errorf("could not decode config: %v", err)
 	}
 
-	archive, err := gcp.ReadObject(ctx, e.Bucket, e.Name)
+	data, err := gcp.ReadObject(ctx, e.Bucket, e.Name)
 	if err != nil {
 		return fmt.Errorf("could not read object: %v", err)
 	}
 
-	cfapi, err := cloudflare.NewWithAPIToken(KV_TOKEN, cloudflare.UsingAccount(CF_ACCOUNT_ID))
+	ns, err := kv.NewNamespaces(KV_TOKEN, CF_ACCOUNT_ID)
 	if err != nil {
-		return errors.Wrap(err, "failed to create cloudflare API client")
+		return errors.Wrap(err, "failed to open kv namespaces")
 	}
 
 	var pairs []kv.WriteRequest
 	kvKeys := make([]string, 0)
-	sris := make(map[string]string)
-	kvfiles := make([]string, 0)
+	var integrity *sri.Manifest
+	var result *manifest.Manifest
+	var entries map[string]manifest.Entry
+	contents := make(map[string][]byte)
 
 	onFile := func(name string, r io.Reader) error {
-		ext := filepath.Ext(name)
 		// remove leading slash
 		name = name[1:]
-		key := fmt.Sprintf("%s/%s/%s", pkgName, version, name)
+
+		// the manifest is the first entry, the files it doesn't list
+		// are skipped without being read
+		if name != manifest.Name && name != sri.ManifestFile && !isKVFile(entries, name) {
+			return nil
+		}
 
 		content, err := ioutil.ReadAll(r)
 		if err != nil {
 			return errors.Wrap(err, "could not read file")
 		}
 
-		if ext == ".sri" {
-			filename := key[0 : len(key)-len(ext)]
-			sris[filename] = string(content)
-			return nil
-		}
-
-		if ext == ".gz" || ext == ".br" || ext == ".woff2" {
-			kvKeys = append(kvKeys, key)
-			kvfiles = append(kvfiles, name)
-
-			meta := newMetadata(len(content))
-			writePair := &kv.ConsumableWriteRequest{
-				Key:   key,
-				Name:  key,
-				Value: content,
-				Meta:  meta,
+		switch name {
+		case manifest.Name:
+			result, err = manifest.Parse(content)
+			if err != nil {
+				return errors.Wrap(err, "could not parse manifest")
+			}
+			entries = result.Entries()
+		case sri.ManifestFile:
+			integrity, err = sri.ParseManifest(content)
+			if err != nil {
+				return errors.Wrap(err, "could not parse integrity manifest")
 			}
-			pairs = append(pairs, writePair)
+		default:
+			contents[name] = content
 		}
 		return nil
 	}
-	if err := gcp.Inflate(bytes.NewReader(archive), onFile); err != nil {
+	if err := archive.Inflate(bytes.NewReader(data), onFile); err != nil {
 		return fmt.Errorf("could not inflate archive: %s", err)
 	}
 
+	kvfiles, err := listKVFiles(result, contents)
+	if err != nil {
+		return fmt.Errorf("could not list files: %s", err)
+	}
+	for _, name := range kvfiles {
+		key := fmt.Sprintf("%s/%s/%s", pkgName, version, name)
+		kvKeys = append(kvKeys, key)
+
+		content := contents[name]
+		// only referenced by the write request, which releases it once written
+		delete(contents, name)
+		meta := newMetadata(len(content))
+		writePair := &kv.ConsumableWriteRequest{
+			Key:   key,
+			Name:  key,
+			Value: content,
+			Meta:  meta,
+		}
+		pairs = append(pairs, writePair)
+	}
+
 	if len(pairs) > 0 {
-		_, err = kv.EncodeAndWriteKVBulk(ctx, cfapi, pairs, FILES_KV_NAMESPACE_ID, false)
+		results, err := kv.EncodeAndWriteKVBulk(ctx, ns.Files, pairs, kv.BulkOptions{Progress: logProgress(pkgName)})
 		if err != nil {
-			return fmt.Errorf("failed to write KV: %s", err)
+			return fmt.Errorf("failed to write KV (%d/%d keys written): %s", len(kv.Written(results)), len(pairs), err)
 		}
 	} else {
 		log.Printf("%s: no files to publish\n", pkgName)
 	}
 
-	newFiles := cleanNewKVFiles(kvfiles)
+	var newFiles []string
+	if result != nil {
+		newFiles = result.Names()
+	} else {
+		newFiles = cleanNewKVFiles(kvfiles)
+	}
 
 	pkg := new(packages.Package)
 	if err := json.Unmarshal([]byte(configStr), &pkg); err != nil {
 		return fmt.Errorf("failed to parse config: %s", err)
 	}
 
-	if err := updateVersions(ctx, cfapi, pkg, version, newFiles); err != nil {
+	if err := updateVersions(ctx, ns, pkg, version, newFiles); err != nil {
 		return fmt.Errorf("failed to update versions: %s", err)
 	}
 
-	if err := updateAggregatedMetadata(ctx, cfapi, pkg, version, newFiles); err != nil {
+	if err := updateAggregatedMetadata(ctx, ns, pkg, version, newFiles); err != nil {
 		return fmt.Errorf("failed to update aggregated metadata: %s", err)
 	}
 
-	if err := updatePackage(ctx, cfapi, pkg, version, newFiles); err != nil {
+	if err := updatePackage(ctx, ns, pkg, version, newFiles); err != nil {
 		return fmt.Errorf("failed to update package: %s", err)
 	}
 
-	if err := updateSRIs(ctx, cfapi, sris); err != nil {
-		return fmt.Errorf("failed to update SRIs: %s", err)
+	sris := make(map[string]string)
+	if integrity != nil {
+		if err := updateSRIs(ctx, ns, pkgName, version, integrity); err != nil {
+			return fmt.Errorf("failed to update SRIs: %s", err)
+		}
+		for _, file := range integrity.Files() {
+			i, _ := integrity.Get(file)
+			sris[fmt.Sprintf("%s/%s/%s", pkgName, version, file)] = i.SHA512
+		}
+	} else {
+		log.Printf("%s: no integrity manifest\n", pkgName)
 	}
 
 	if err := audit.WroteKV(ctx, pkgName, version, sris, kvKeys, string(configStr)); err != nil {
@@ -134,14 +173,45 @@ func Invoke(ctx context.Context, e gcp.GCSEvent) error {
 	return nil
 }
 
-// KV has optimized files (ending in .gz/br), if we want the original files we
+// Returns whether a file of the archive is written in KV, which is the case
+// of the entries of the manifest. Archives without manifest predate it: the
+// files are found from their extension.
+func isKVFile(entries map[string]manifest.Entry, name string) bool {
+	if entries != nil {
+		_, ok := entries[name]
+		return ok
+	}
+	_, enc := compress.SplitEncodingExt(name)
+	return enc != nil || filepath.Ext(name) == ".woff2"
+}
+
+// Lists the files of the archive to write in KV, checking that none of the
+// entries of the manifest is missing.
+func listKVFiles(result *manifest.Manifest, contents map[string][]byte) ([]string, error) {
+	files := make([]string, 0)
+	if result != nil {
+		for name := range result.Entries() {
+			if _, ok := contents[name]; !ok {
+				return nil, errors.Errorf("%s is missing from the archive", name)
+			}
+			files = append(files, name)
+		}
+	} else {
+		for name := range contents {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// KV has optimized files (ending in .gz/.br/.zst), if we want the original files we
 // need to dedup them and remove their compression ext
 func cleanNewKVFiles(files []string) []string {
 	seen := make(map[string]bool)
 	out := make([]string, 0)
 	for _, file := range files {
-		ext := filepath.Ext(file)
-		name := file[0 : len(file)-len(ext)]
+		name, _ := compress.SplitEncodingExt(file)
 
 		if _, ok := seen[name]; ok {
 			continue
@@ -153,8 +223,8 @@ func cleanNewKVFiles(files []string) []string {
 	return out
 }
 
-func getExistingVersions(cfapi *cloudflare.API, p *packages.Package) ([]string, error) {
-	versions, err := kv.GetVersions(cfapi, *p.Name)
+func getExistingVersions(ctx context.Context, ns *kv.Namespaces, p *packages.Package) ([]string, error) {
+	versions, err := kv.GetVersions(ctx, ns.Versions, *p.Name)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get verions")
 	}
@@ -162,9 +232,9 @@ func getExistingVersions(cfapi *cloudflare.API, p *packages.Package) ([]string,
 	return versions, nil
 }
 
-func updateVersions(ctx context.Context, cfapi *cloudflare.API, pkg *packages.Package,
+func updateVersions(ctx context.Context, ns *kv.Namespaces, pkg *packages.Package,
 	version string, files []string) error {
-	_, err := kv.UpdateKVVersion(ctx, cfapi, *pkg.Name, version, files)
+	_, err := kv.UpdateKVVersion(ctx, ns.Versions, *pkg.Name, version, files)
 	if err != nil {
 		return errors.Wrap(err, "failed to update version in KV")
 	}
@@ -172,10 +242,10 @@ func updateVersions(ctx context.Context, cfapi *cloudflare.API, pkg *packages.Pa
 	return nil
 }
 
-func updatePackage(ctx context.Context, cfapi *cloudflare.API, pkg *packages.Package,
+func updatePackage(ctx context.Context, ns *kv.Namespaces, pkg *packages.Package,
 	currVersion string, files []string) error {
 	// update package version with latest
-	versions, err := getExistingVersions(cfapi, pkg)
+	versions, err := getExistingVersions(ctx, ns, pkg)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve existing versions: %s", err)
 	}
@@ -194,7 +264,7 @@ func updatePackage(ctx context.Context, cfapi *cloudflare.API, pkg *packages.Pac
 	}
 
 	// sync with KV first, then update legacy package.json
-	if err := kv.UpdateKVPackage(ctx, cfapi, pkg); err != nil {
+	if err := kv.UpdateKVPackage(ctx, ns.Packages, pkg); err != nil {
 		return errors.Wrap(err, "failed to write KV package metadata")
 	}
 	log.Println("updated package")
@@ -202,7 +272,7 @@ func updatePackage(ctx context.Context, cfapi *cloudflare.API, pkg *packages.Pac
 	return nil
 }
 
-func updateAggregatedMetadata(ctx context.Context, cfapi *cloudflare.API,
+func updateAggregatedMetadata(ctx context.Context, ns *kv.Namespaces,
 	pkg *packages.Package, version string, newFiles []string) error {
 	if len(newFiles) == 0 {
 		log.Println("updateAggregatedMetadata: update contains no files, ignoring")
@@ -213,7 +283,7 @@ func updateAggregatedMetadata(ctx context.Context, cfapi *cloudflare.API,
 		Version: version,
 		Files:   newFiles,
 	}
-	kvWrites, _, err := kv.UpdateAggregatedMetadata(cfapi, ctx, pkg, version, newAssets)
+	kvWrites, _, err := kv.UpdateAggregatedMetadata(ctx, ns.AggregatedMetadata, pkg, version, newAssets)
 	if err != nil {
 		return errors.Errorf("(%s) failed to update aggregated metadata: %s", *pkg.Name, err)
 	}
@@ -224,28 +294,53 @@ func updateAggregatedMetadata(ctx context.Context, cfapi *cloudflare.API,
 	return nil
 }
 
-func updateSRIs(ctx context.Context, cfapi *cloudflare.API, sris map[string]string) error {
-	pairs := make([]kv.WriteRequest, 0)
+// Writes the integrity of each file as metadata of the `<pkg>/<version>/<file>`
+// key, and the whole manifest as the value of the `<pkg>/<version>` key so that
+// all the SRIs of a version can be looked up at once.
+func updateSRIs(ctx context.Context, ns *kv.Namespaces, pkgName, version string, integrity *sri.Manifest) error {
+	manifest, err := json.Marshal(integrity)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal integrity manifest")
+	}
+	versionKey := fmt.Sprintf("%s/%s", pkgName, version)
+	pairs := []kv.WriteRequest{
+		&kv.ConsumableWriteRequest{
+			Key:   versionKey,
+			Name:  versionKey,
+			Value: manifest,
+		},
+	}
 
-	for name, sri := range sris {
+	for _, file := range integrity.Files() {
+		i, _ := integrity.Get(file)
+		name := fmt.Sprintf("%s/%s", versionKey, file)
 		pairs = append(pairs, &kv.MetaWriteRequest{
 			Key:  name,
 			Name: name,
 			Meta: &kv.FileMetadata{
-				SRI: sri,
+				SRI:       i.SHA512,
+				Integrity: i,
 			},
 		})
 	}
 
 	if len(pairs) > 0 {
-		_, err := kv.EncodeAndWriteKVBulk(ctx, cfapi, pairs, SRI_KV_NAMESPACE, false)
+		results, err := kv.EncodeAndWriteKVBulk(ctx, ns.SRIs, pairs, kv.BulkOptions{Progress: logProgress(pkgName)})
 		if err != nil {
-			return errors.Wrap(err, "could not write bulk KV")
+			return errors.Wrapf(err, "could not write bulk KV (%d/%d keys written)", len(kv.Written(results)), len(pairs))
 		}
 	}
 	return nil
 }
 
+// Logs the throughput of the writes to KV.
+func logProgress(pkgName string) func(kv.Progress) {
+	return func(p kv.Progress) {
+		log.Printf("%s: wrote %d keys in %d bulks (%.1f MiB, %.1f MiB/s)\n", pkgName,
+			p.Keys, p.Bulks, float64(p.Bytes)/(1<<20), float64(p.Bytes)/(1<<20)/p.Elapsed.Seconds())
+	}
+}
+
 func newMetadata(size int) *kv.FileMetadata {
 	lastModifiedTime := time.Now()
 	lastModifiedSeconds := lastModifiedTime.UnixNano() / int64(time.Second)
@@ -1,10 +1,8 @@
 package gcp
 
 import (
-	"archive/tar"
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"io"
 	"time"
@@ -73,39 +71,3 @@ type GCSEvent struct {
 	KMSKeyName    string `json:"kmsKeyName"`
 	ResourceState string `json:"resourceState"`
 }
-
-func Inflate(gzipStream io.Reader, onFile func(string, io.Reader) error) error {
-	uncompressedStream, err := gzip.NewReader(gzipStream)
-	if err != nil {
-		return errors.Wrap(err, "ExtractTarGz: NewReader failed")
-	}
-
-	tarReader := tar.NewReader(uncompressedStream)
-
-	for {
-		header, err := tarReader.Next()
-
-		if err == io.EOF {
-			break
-		}
-
-		if err != nil {
-			return errors.Wrap(err, "ExtractTarGz: Next() failed")
-		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			// do nothing
-		case tar.TypeReg:
-			if err := onFile(header.Name, tarReader); err != nil {
-				return errors.Wrap(err, "failed to handle file")
-			}
-		default:
-			return errors.Errorf(
-				"ExtractTarGz: uknown type: %x in %s",
-				header.Typeflag,
-				header.Name)
-		}
-	}
-	return nil
-}
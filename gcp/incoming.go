@@ -1,7 +1,6 @@
 package gcp
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -19,7 +18,7 @@ var (
 	GCS_BUCKET = os.Getenv("GCS_BUCKET")
 )
 
-func AddIncomingFile(fileName string, buff bytes.Buffer, pckg *packages.Package, v version.Version) error {
+func AddIncomingFile(fileName string, r io.Reader, pckg *packages.Package, v version.Version) error {
 	// Create GCS connection
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
@@ -34,7 +33,7 @@ func AddIncomingFile(fileName string, buff bytes.Buffer, pckg *packages.Package,
 		{Entity: storage.AllUsers, Role: storage.RoleReader},
 	}
 
-	if _, err := io.Copy(w, bytes.NewReader(buff.Bytes())); err != nil {
+	if _, err := io.Copy(w, r); err != nil {
 		return fmt.Errorf("Failed to copy to bucket: %v", err)
 	}
 	if err := w.Close(); err != nil {
@@ -50,6 +49,7 @@ func AddIncomingFile(fileName string, buff bytes.Buffer, pckg *packages.Package,
 	_, err = obj.Update(ctx, storage.ObjectAttrsToUpdate{
 		Metadata: map[string]string{
 			"version": v.Version,
+			"format":  string(v.Format),
 			"package": *pckg.Name,
 			"config":  string(configBytes),
 		},
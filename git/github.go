@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +26,16 @@ import (
 
 var (
 	GH_TOKEN = os.Getenv("GH_TOKEN")
+	// maximum number of tags, the most recent ones, listed by GetVersions
+	GH_TAGS_WINDOW = os.Getenv("GH_TAGS_WINDOW")
+)
+
+const (
+	// DefaultTagsWindow is the number of tags listed by GetVersions
+	// if GH_TAGS_WINDOW isn't set.
+	DefaultTagsWindow = 1000
+	// number of tags per page, the maximum allowed by GitHub
+	tagsPageSize = 100
 )
 
 // Stars holds the number of stars for a GitHub repository.
@@ -41,6 +52,10 @@ func getRepo(gitURL string) string {
 	return re.ReplaceAllString(gitURL, "$1")
 }
 
+func isGitHub(gitURL string) bool {
+	return regexp.MustCompile(`github\.com[:/]`).MatchString(gitURL)
+}
+
 // GetGitHubStars uses the GitHub API to get the star count for a
 // particular GitHub repository.
 func GetGitHubStars(gitURL string) Stars {
@@ -71,48 +86,116 @@ func GetClient() *githubapi.Client {
 
 type GetVersionsRes struct {
 	Data struct {
-		Repository struct {
+		RateLimit  RateLimit `json:"rateLimit"`
+		Repository *struct {
 			Refs struct {
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
 				Nodes []GitHubVersion `json:"nodes"`
 			} `json:"refs"`
 		} `json:"repository"`
 	} `json:"data"`
+	Errors []GraphQLError `json:"errors"`
+}
+
+// RateLimit is the state of the GraphQL rate limit after a query.
+type RateLimit struct {
+	Cost      int       `json:"cost"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+// RateLimitError is returned when the GitHub rate limit doesn't
+// allow more queries until ResetAt.
+type RateLimitError struct {
+	ResetAt time.Time
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("GitHub rate limit exceeded until %s", e.ResetAt.Format(time.RFC3339))
+}
+
+type GraphQLError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
+type GitHubCommit struct {
+	Oid           string `json:"oid"`
+	TarballUrl    string `json:"tarballUrl"`
+	CommittedDate string `json:"committedDate"`
+	AuthoredDate  string `json:"authoredDate"`
+}
+
+// GitHubVersion is a tag, its target is the tagged commit for a
+// lightweight tag, or the tag object for an annotated tag.
 type GitHubVersion struct {
 	Name   string `json:"name"`
 	Target struct {
-		TarballUrl    string `json:"tarballUrl"`
-		CommittedDate string `json:"committedDate"`
-		AuthoredDate  string `json:"authoredDate"`
-		Target        struct {
-			TarballUrl    string `json:"tarballUrl"`
-			CommittedDate string `json:"committedDate"`
-			AuthoredDate  string `json:"authoredDate"`
+		Typename string `json:"__typename"`
+		GitHubCommit
+		Target struct {
+			Typename string `json:"__typename"`
+			GitHubCommit
 		} `json:"target"`
 	} `json:"target"`
 }
 
+// Returns the commit of a tag, or nil if the tag doesn't point
+// to a commit, for instance a tag of a tree or of another tag.
+func (v GitHubVersion) commit() *GitHubCommit {
+	switch v.Target.Typename {
+	case "Commit":
+		return &v.Target.GitHubCommit
+	case "Tag":
+		if v.Target.Target.Typename == "Commit" {
+			return &v.Target.Target.GitHubCommit
+		}
+	}
+	return nil
+}
+
+// Returns the date of the commit, when it was committed or else authored.
+func (c GitHubCommit) date() (time.Time, error) {
+	date := c.CommittedDate
+	if date == "" {
+		date = c.AuthoredDate
+	}
+	if date == "" {
+		return time.Time{}, errors.New("missing date")
+	}
+	return time.Parse(time.RFC3339, date)
+}
+
 type GraphQLRequest struct {
-	Query string `json:"query"`
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
 }
 
-// GetVersions gets all of the versions associated with a git repo,
-// as well as the latest version.
-func GetVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, error) {
-	name := *config.Target
-	repo := getRepo(*config.Target)
-	parts := strings.Split(repo, "/")
-	query := GraphQLRequest{Query: fmt.Sprintf(`
-query {
-  repository(name: "%s", owner: "%s") {
-    refs(refPrefix: "refs/tags/", last: 10) {
+const tagsQuery = `
+query($owner: String!, $name: String!, $first: Int!, $after: String) {
+  rateLimit {
+    cost
+    remaining
+    resetAt
+  }
+  repository(owner: $owner, name: $name) {
+    refs(refPrefix: "refs/tags/", first: $first, after: $after, orderBy: {field: TAG_COMMIT_DATE, direction: DESC}) {
+      pageInfo {
+        hasNextPage
+        endCursor
+      }
       nodes {
         name
         target {
+          __typename
           ... on Tag {
             target {
+              __typename
               ... on Commit {
+                oid
                 tarballUrl
                 committedDate
                 authoredDate
@@ -120,25 +203,117 @@ query {
             }
           }
           ... on Commit {
+            oid
             tarballUrl
-            authoredDate
             committedDate
+            authoredDate
           }
         }
       }
     }
   }
 }
-	`, parts[1], parts[0])}
+`
 
-	var res GetVersionsRes
+// Returns the maximum number of tags listed, zero for all of them.
+func tagsWindow() int {
+	if GH_TAGS_WINDOW == "" {
+		return DefaultTagsWindow
+	}
+	window, err := strconv.Atoi(GH_TAGS_WINDOW)
+	if err != nil || window < 0 {
+		log.Printf("invalid GH_TAGS_WINDOW %s, using %d\n", GH_TAGS_WINDOW, DefaultTagsWindow)
+		return DefaultTagsWindow
+	}
+	return window
+}
+
+// GetGitHubVersions gets the versions associated with a GitHub repo, from its
+// most recent tags by commit date, up to GH_TAGS_WINDOW tags. A RateLimitError
+// is returned if the GitHub rate limit doesn't allow to list them.
+func GetGitHubVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, error) {
+	name := *config.Target
+	parts := strings.Split(getRepo(name), "/")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("%s is not a GitHub repository", name)
+	}
+
+	window := tagsWindow()
+	versions := make([]version.Version, 0)
+	var cursor *string
+
+	for seen := 0; window == 0 || seen < window; {
+		first := tagsPageSize
+		if window > 0 && window-seen < first {
+			first = window - seen
+		}
+		res, err := queryTags(ctx, parts[0], parts[1], first, cursor)
+		if err != nil {
+			return nil, err
+		}
+		refs := res.Data.Repository.Refs
+		seen += len(refs.Nodes)
+
+		for _, githubVersion := range refs.Nodes {
+			if version.IsVersionIgnored(config, githubVersion.Name) {
+				log.Printf("%s: version %s is ignored\n", name, githubVersion.Name)
+				continue
+			}
+
+			commit := githubVersion.commit()
+			if commit == nil {
+				log.Printf("%s: tag %s doesn't point to a commit, ignoring\n", name, githubVersion.Name)
+				continue
+			}
+			date, err := commit.date()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse date of tag %s", githubVersion.Name)
+			}
+
+			versionName := githubVersion.Name
+			if versionName[0:1] == "v" {
+				versionName = versionName[1:]
+			}
+
+			versions = append(versions, version.Version{
+				Version: versionName,
+				Tarball: commit.TarballUrl,
+				Date:    date,
+				Source:  "git",
+				Commit:  commit.Oid,
+			})
+		}
+
+		if !refs.PageInfo.HasNextPage {
+			break
+		}
+		if rl := res.Data.RateLimit; rl.Remaining < rl.Cost {
+			return nil, RateLimitError{ResetAt: rl.ResetAt}
+		}
+		cursor = &refs.PageInfo.EndCursor
+	}
+
+	return versions, nil
+}
+
+// Queries a page of tags of a repository.
+func queryTags(ctx context.Context, owner, repo string, first int, after *string) (*GetVersionsRes, error) {
+	query := GraphQLRequest{
+		Query: tagsQuery,
+		Variables: map[string]interface{}{
+			"owner": owner,
+			"name":  repo,
+			"first": first,
+			"after": after,
+		},
+	}
 
 	body, err := json.Marshal(query)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not construct query")
 	}
 
-	req, err := http.NewRequest("POST", "https://api.github.com/graphql", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", util.GetProtocol()+"://api.github.com/graphql", bytes.NewReader(body))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to retrieve tags")
 	}
@@ -150,8 +325,12 @@ query {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to send request")
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
+		if err := rateLimitFromHeaders(resp); err != nil {
+			return nil, err
+		}
 		bodyBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to decode response body")
@@ -159,51 +338,36 @@ query {
 		return nil, errors.Errorf("GitHub GraphQL returned %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
+	var res GetVersionsRes
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
 		return nil, errors.Wrap(err, "failed to decode response")
 	}
 
-	versions := make([]version.Version, 0)
-
-	for _, githubVersion := range res.Data.Repository.Refs.Nodes {
-		var err error
-		date := time.Time{}
-		if githubVersion.Target.Target.CommittedDate != "" {
-			date, err = time.Parse(time.RFC3339, githubVersion.Target.Target.CommittedDate)
-		} else if githubVersion.Target.Target.AuthoredDate != "" {
-			date, err = time.Parse(time.RFC3339, githubVersion.Target.Target.AuthoredDate)
-		} else if githubVersion.Target.CommittedDate != "" {
-			date, err = time.Parse(time.RFC3339, githubVersion.Target.CommittedDate)
-		} else if githubVersion.Target.AuthoredDate != "" {
-			date, err = time.Parse(time.RFC3339, githubVersion.Target.AuthoredDate)
-		}
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse tag date")
-		}
-
-		tarballUrl := ""
-		if githubVersion.Target.Target.TarballUrl != "" {
-			tarballUrl = githubVersion.Target.Target.TarballUrl
-		} else if githubVersion.Target.TarballUrl != "" {
-			tarballUrl = githubVersion.Target.TarballUrl
-		}
-
-		if !version.IsVersionIgnored(config, githubVersion.Name) {
-			versionName := githubVersion.Name
-			if versionName[0:1] == "v" {
-				versionName = versionName[1:]
+	if len(res.Errors) > 0 {
+		for _, e := range res.Errors {
+			if e.Type == "RATE_LIMITED" {
+				return nil, RateLimitError{ResetAt: res.Data.RateLimit.ResetAt}
 			}
-
-			versions = append(versions, version.Version{
-				Version: versionName,
-				Tarball: tarballUrl,
-				Date:    date,
-				Source:  "git",
-			})
-		} else {
-			log.Printf("%s: version %s is ignored\n", name, githubVersion.Name)
 		}
+		return nil, errors.Errorf("GitHub GraphQL failed: %s", res.Errors[0].Message)
+	}
+	if res.Data.Repository == nil {
+		return nil, errors.Errorf("repository %s/%s not found", owner, repo)
 	}
+	log.Printf("GitHub GraphQL rate limit: %d remaining\n", res.Data.RateLimit.Remaining)
 
-	return versions, nil
+	return &res, nil
+}
+
+// Returns a RateLimitError if a response was rejected because
+// of the rate limit.
+func rateLimitFromHeaders(resp *http.Response) error {
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return nil
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return RateLimitError{ResetAt: time.Now().Add(time.Hour)}
+	}
+	return RateLimitError{ResetAt: time.Unix(reset, 0)}
 }
@@ -0,0 +1,121 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/version"
+
+	"github.com/pkg/errors"
+)
+
+// format of the tags listed by for-each-ref: name, type of the object, type
+// of the tagged object, then the commit dates and hashes, of the tagged
+// commit for annotated tags or of the commit for lightweight tags
+const tagFormat = "%(refname:strip=2)\t%(objecttype)\t%(*objecttype)\t" +
+	"%(*committerdate:iso-strict)\t%(committerdate:iso-strict)\t%(*objectname)\t%(objectname)"
+
+// GetVersions gets the versions of a git repo, with the GitHub API for
+// GitHub repositories if GH_TOKEN is set, or else from the remote.
+func GetVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, error) {
+	if GH_TOKEN != "" && isGitHub(*config.Target) {
+		return GetGitHubVersions(ctx, config)
+	}
+	return GetRemoteVersions(ctx, config)
+}
+
+// GetRemoteVersions gets the versions of any git remote from its tags.
+// The tags are listed with ls-remote, then the ones which aren't ignored are
+// fetched into a temporary repository, with their commits but without their
+// files, to know the dates of the commits. The versions are downloaded from
+// the remote with a shallow fetch of their tag.
+func GetRemoteVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, error) {
+	remote := *config.Target
+
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create temporary repository")
+	}
+	defer os.RemoveAll(dir)
+
+	if err := version.RunGit(ctx, dir, nil, "init", "--bare", "--quiet"); err != nil {
+		return nil, err
+	}
+
+	var refs bytes.Buffer
+	if err := version.RunGit(ctx, dir, &refs, "ls-remote", "--tags", "--refs", "--", remote); err != nil {
+		return nil, errors.Wrap(err, "could not list tags")
+	}
+	refspecs := make([]string, 0)
+	scanner := bufio.NewScanner(&refs)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") {
+			return nil, errors.Errorf("unexpected ref %s", scanner.Text())
+		}
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		if version.IsVersionIgnored(config, tag) {
+			log.Printf("%s: version %s is ignored\n", remote, tag)
+			continue
+		}
+		refspecs = append(refspecs, "+"+fields[1]+":"+fields[1])
+	}
+	if len(refspecs) == 0 {
+		return []version.Version{}, nil
+	}
+
+	// the filter is ignored if the remote doesn't support it
+	args := append([]string{"fetch", "--quiet", "--depth=1", "--filter=tree:0", "--no-tags", "--", remote}, refspecs...)
+	if err := version.RunGit(ctx, dir, nil, args...); err != nil {
+		return nil, errors.Wrap(err, "could not fetch tags")
+	}
+
+	var out bytes.Buffer
+	if err := version.RunGit(ctx, dir, &out, "for-each-ref", "--format="+tagFormat, "refs/tags"); err != nil {
+		return nil, errors.Wrap(err, "could not list tags")
+	}
+
+	versions := make([]version.Version, 0)
+	scanner = bufio.NewScanner(&out)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 7 {
+			return nil, errors.Errorf("unexpected tag %s", scanner.Text())
+		}
+		tag, objectType, taggedType := fields[0], fields[1], fields[2]
+
+		date, commit := fields[4], fields[6]
+		switch {
+		case objectType == "commit":
+			// lightweight tag
+		case objectType == "tag" && taggedType == "commit":
+			date, commit = fields[3], fields[5]
+		default:
+			log.Printf("%s: tag %s doesn't point to a commit, ignoring\n", remote, tag)
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse date of tag %s", tag)
+		}
+
+		versions = append(versions, version.Version{
+			Version: strings.TrimPrefix(tag, "v"),
+			Remote:  remote,
+			Ref:     "refs/tags/" + tag,
+			Date:    t,
+			Source:  "git",
+			Commit:  commit,
+		})
+	}
+
+	return versions, nil
+}
@@ -8,24 +8,28 @@ require (
 	github.com/GoogleCloudPlatform/functions-framework-go v1.2.0 // indirect
 	github.com/agnivade/levenshtein v1.1.1
 	github.com/algolia/algoliasearch-client-go/v3 v3.4.0
+	github.com/andybalholm/brotli v1.0.2
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/cloudevents/sdk-go v0.10.0 // indirect
-	github.com/cloudflare/cloudflare-go v0.12.1
 	github.com/containerd/containerd v1.4.4 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/docker/distribution v2.7.1+incompatible // indirect
 	github.com/docker/docker v20.10.6+incompatible // indirect
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/docker/go-units v0.4.0 // indirect
+	github.com/evanw/esbuild v0.14.23
+	github.com/foobaz/go-zopfli v0.0.0-20140122214029-7432051485e2
 	github.com/getsentry/sentry-go v0.6.1
 	github.com/go-git/go-git/v5 v5.3.0
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/karrick/godirwalk v1.15.6
+	github.com/klauspost/compress v1.13.6
 	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/opencontainers/image-spec v1.0.1 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.6.1
+	github.com/ulikunitz/xz v0.5.15
 	github.com/xeipuuv/gojsonschema v1.2.0
 	golang.org/x/lint v0.0.0-20201208152925-83fdc39ff7b5 // indirect
 	golang.org/x/net v0.0.0-20210326060303-6b1517762897
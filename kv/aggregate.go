@@ -9,15 +9,13 @@ import (
 	"github.com/cdnjs/tools/compress"
 	"github.com/cdnjs/tools/packages"
 	"github.com/cdnjs/tools/util"
-
-	cloudflare "github.com/cloudflare/cloudflare-go"
 )
 
 // UpdateAggregatedMetadata updates a package's KV entry for aggregated metadata.
 // Returns the keys written to KV, whether the existing entry was found, and if there were any errors.
-func UpdateAggregatedMetadata(api *cloudflare.API, ctx context.Context,
+func UpdateAggregatedMetadata(ctx context.Context, store Store,
 	pkg *packages.Package, newVersion string, newAssets packages.Asset) ([]string, bool, error) {
-	aggPkg, err := getAggregatedMetadata(api, *pkg.Name)
+	aggPkg, err := getAggregatedMetadata(ctx, store, *pkg.Name)
 
 	if aggPkg == nil {
 		// pkg has never been aggregated
@@ -51,14 +49,14 @@ func UpdateAggregatedMetadata(api *cloudflare.API, ctx context.Context,
 	}
 	aggPkg.Version = &newVersion
 
-	successfulWrites, err := writeAggregatedMetadata(ctx, api, aggPkg)
+	successfulWrites, err := writeAggregatedMetadata(ctx, store, aggPkg)
 	return successfulWrites, found, err
 }
 
 // Reads an aggregated metadata entry in KV, ungzipping it and
 // unmarshalling it into a *packages.Package.
-func getAggregatedMetadata(api *cloudflare.API, key string) (*packages.Package, error) {
-	gzipBytes, err := read(api, key, aggregatedMetadataNamespaceID)
+func getAggregatedMetadata(ctx context.Context, store Store, key string) (*packages.Package, error) {
+	gzipBytes, err := store.Read(ctx, key)
 
 	if err != nil {
 		return nil, err
@@ -72,7 +70,7 @@ func getAggregatedMetadata(api *cloudflare.API, key string) (*packages.Package,
 }
 
 // Writes an aggregated metadata entry to KV, gzipping the bytes.
-func writeAggregatedMetadata(ctx context.Context, api *cloudflare.API, p *packages.Package) ([]string, error) {
+func writeAggregatedMetadata(ctx context.Context, store Store, p *packages.Package) ([]string, error) {
 	// marshal package into JSON
 	v, err := p.Marshal()
 	if err != nil {
@@ -87,5 +85,6 @@ func writeAggregatedMetadata(ctx context.Context, api *cloudflare.API, p *packag
 	}
 
 	// write aggregated to KV
-	return EncodeAndWriteKVBulk(ctx, api, []WriteRequest{req}, aggregatedMetadataNamespaceID, true)
+	results, err := EncodeAndWriteKVBulk(ctx, store, []WriteRequest{req}, BulkOptions{PanicOversized: true})
+	return Written(results), err
 }
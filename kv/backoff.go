@@ -0,0 +1,67 @@
+package kv
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/cdnjs/tools/util"
+
+	"github.com/pkg/errors"
+)
+
+// Backoff retries the requests failing temporarily, after an exponential
+// delay with jitter, or after the delay requested by the API.
+type Backoff struct {
+	Attempts int           // maximum number of attempts
+	Base     time.Duration // delay before the first retry
+	Max      time.Duration // maximum delay, the API asking for more is an error
+}
+
+// DefaultBackoff is the Backoff of the clients.
+var DefaultBackoff = Backoff{
+	Attempts: util.MaxKVAttempts,
+	Base:     500 * time.Millisecond,
+	Max:      time.Minute,
+}
+
+// Retry calls fn until it succeeds or fails with an error which isn't a
+// temporary Error, the attempts are exhausted or the context is done.
+func (b Backoff) Retry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		kvErr, ok := err.(Error)
+		if !ok || !kvErr.Temporary() || attempt >= b.Attempts {
+			return err
+		}
+
+		delay := kvErr.RetryAfter
+		if delay > b.Max {
+			return err
+		}
+		if delay <= 0 {
+			delay = b.delay(attempt)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "retry of %s", err)
+		case <-timer.C:
+		}
+	}
+}
+
+// Returns the delay before a retry: the exponential delay of the attempt,
+// of which the second half is random so that clients spread their retries.
+func (b Backoff) delay(attempt int) time.Duration {
+	delay := b.Max
+	if shift := uint(attempt - 1); shift < 32 && b.Base<<shift < b.Max {
+		delay = b.Base << shift
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)))
+}
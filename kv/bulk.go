@@ -1,11 +1,16 @@
 package kv
 
+import (
+	"github.com/cdnjs/tools/sri"
+)
+
 // FileMetadata represents metadata for a
 // particular KV.
 type FileMetadata struct {
-	ETag         string `json:"etag,omitempty"`
-	LastModified string `json:"last_modified,omitempty"`
-	SRI          string `json:"sri,omitempty"`
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"last_modified,omitempty"`
+	SRI          string         `json:"sri,omitempty"`
+	Integrity    *sri.Integrity `json:"integrity,omitempty"`
 }
 
 // Represents a KV write request, consisting of
@@ -29,16 +34,18 @@ type ConsumableWriteRequest struct {
 	Meta  *FileMetadata
 }
 
-func (r ConsumableWriteRequest) GetKey() string  { return r.Key }
-func (r ConsumableWriteRequest) GetName() string { return r.Name }
-func (r ConsumableWriteRequest) GetValue() []byte {
+func (r *ConsumableWriteRequest) GetKey() string  { return r.Key }
+func (r *ConsumableWriteRequest) GetName() string { return r.Name }
+func (r *ConsumableWriteRequest) GetValue() []byte {
 	if r.Value == nil {
 		panic(r.GetName() + ": write request has already been consumed")
 	}
 	return r.Value
 }
-func (r ConsumableWriteRequest) GetMeta() *FileMetadata { return r.Meta }
-func (r ConsumableWriteRequest) Consumed() {
+func (r *ConsumableWriteRequest) GetMeta() *FileMetadata { return r.Meta }
+
+// Consumed releases the value, once it is written.
+func (r *ConsumableWriteRequest) Consumed() {
 	r.Value = nil
 }
 
@@ -0,0 +1,250 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/cdnjs/tools/util"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBaseURL is the base URL of the Cloudflare API.
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// Client is a client of the Workers KV API of a Cloudflare account. The
+// requests failing temporarily are retried with its Backoff.
+type Client struct {
+	AccountID string
+	Token     string
+	BaseURL   string
+	HTTP      *http.Client
+	Backoff   Backoff
+}
+
+// NewClient creates a client of an account, authenticated with an API token.
+func NewClient(accountID, token string) *Client {
+	return &Client{
+		AccountID: accountID,
+		Token:     token,
+		BaseURL:   DefaultBaseURL,
+		HTTP:      http.DefaultClient,
+		Backoff:   DefaultBackoff,
+	}
+}
+
+// CloudflareStore is a Workers KV namespace.
+type CloudflareStore struct {
+	client      *Client
+	namespaceID string
+}
+
+// NewCloudflareStore creates the Store of a Workers KV namespace.
+func NewCloudflareStore(client *Client, namespaceID string) *CloudflareStore {
+	return &CloudflareStore{client: client, namespaceID: namespaceID}
+}
+
+// Response of the API, except for the values which are returned as is.
+type apiResponse struct {
+	Success    bool       `json:"success"`
+	Errors     []APIError `json:"errors"`
+	Result     []apiKey   `json:"result"`
+	ResultInfo struct {
+		Cursor string `json:"cursor"`
+	} `json:"result_info"`
+}
+
+type apiKey struct {
+	Name     string        `json:"name"`
+	Metadata *FileMetadata `json:"metadata"`
+}
+
+// Pair of a bulk write, without its value.
+type apiPair struct {
+	Key      string        `json:"key"`
+	Base64   bool          `json:"base64"`
+	Metadata *FileMetadata `json:"metadata,omitempty"`
+}
+
+// Returns the JSON body of a request.
+func jsonBody(payload interface{}) (func() io.Reader, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal request")
+	}
+	return func() io.Reader { return bytes.NewReader(data) }, nil
+}
+
+// Sends a request to the namespace, retrying it while it fails temporarily.
+// Its body, if any, is created for each attempt. The body of the response is
+// returned if the request succeeds.
+func (s *CloudflareStore) do(ctx context.Context, method, endpoint string, query url.Values, body func() io.Reader, key string) ([]byte, error) {
+	if s.namespaceID == "" {
+		return nil, errors.New("kv namespace ID not configured")
+	}
+	target := fmt.Sprintf("%s/accounts/%s/storage/kv/namespaces/%s/%s",
+		s.client.BaseURL, s.client.AccountID, s.namespaceID, endpoint)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var respBody []byte
+	err := s.client.Backoff.Retry(ctx, func() error {
+		var r io.Reader
+		if body != nil {
+			r = body()
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, r)
+		if err != nil {
+			if c, ok := r.(io.Closer); ok {
+				c.Close()
+			}
+			return errors.Wrap(err, "could not create request")
+		}
+		req.Header.Set("Authorization", "Bearer "+s.client.Token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := s.client.HTTP.Do(req)
+		if err != nil {
+			// network errors are temporary
+			return Error{Err: err}
+		}
+		defer resp.Body.Close()
+
+		respBody, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return Error{Err: errors.Wrap(err, "could not read response")}
+		}
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		return classify(resp, respBody, key)
+	})
+	return respBody, err
+}
+
+// Read reads an entry from Workers KV.
+func (s *CloudflareStore) Read(ctx context.Context, key string) ([]byte, error) {
+	return s.do(ctx, "GET", "values/"+url.PathEscape(key), nil, nil, key)
+}
+
+// WriteBulk writes key-value pairs in a single bulk request, encoded to
+// base64. The pairs must fit in the limits of a request.
+func (s *CloudflareStore) WriteBulk(ctx context.Context, pairs []*Pair) error {
+	body := func() io.Reader {
+		// the request is encoded as it is sent, rather than in memory
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(encodeBulk(w, pairs))
+		}()
+		return r
+	}
+	return s.checkSuccess(s.do(ctx, "PUT", "bulk", nil, body, ""))
+}
+
+// Encodes the JSON array of a bulk write, with the values in base64.
+func encodeBulk(w io.Writer, pairs []*Pair) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, p := range pairs {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		// the value is written in place of the empty one
+		head, err := json.Marshal(apiPair{Key: p.Key, Base64: true, Metadata: p.Meta})
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, `%s,"value":"`, head[:len(head)-1]); err != nil {
+			return err
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := enc.Write(p.Value); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, `"}`); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// ListByPrefix returns all keys that start with a prefix.
+func (s *CloudflareStore) ListByPrefix(ctx context.Context, prefix string) ([]Key, error) {
+	var cursor string
+	var results []Key
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		body, err := s.do(ctx, "GET", "keys", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var resp apiResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, errors.Wrap(err, "could not parse keys")
+		}
+
+		for _, k := range resp.Result {
+			results = append(results, Key{Name: k.Name, Meta: k.Metadata})
+		}
+
+		if resp.ResultInfo.Cursor == "" {
+			return results, nil
+		}
+
+		cursor = resp.ResultInfo.Cursor
+	}
+}
+
+// Delete deletes keys, in bulk requests.
+func (s *CloudflareStore) Delete(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > int(util.MaxBulkKeys) {
+			n = int(util.MaxBulkKeys)
+		}
+		body, err := jsonBody(keys[:n])
+		if err != nil {
+			return err
+		}
+		if err := s.checkSuccess(s.do(ctx, "DELETE", "bulk", nil, body, "")); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// Ensure a response is successful and the error is nil.
+func (s *CloudflareStore) checkSuccess(body []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	var resp apiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return errors.Wrap(err, "could not parse response")
+	}
+	if !resp.Success {
+		return Error{Status: http.StatusOK, Errors: resp.Errors, Err: errors.New("request not successful")}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cdnjs/tools/packages"
+
+	"github.com/pkg/errors"
+)
+
+// Deletion lists the changes made by the deletion of a version.
+type Deletion struct {
+	Files   []string // keys deleted from the files namespace
+	SRIs    []string // keys deleted from the SRIs namespace
+	Version string   // key deleted from the versions namespace, empty if missing
+
+	// Tombstone is the key written to the packages namespace so that the
+	// autoupdater doesn't publish the version again.
+	Tombstone string
+
+	// The package metadata and aggregated metadata, updated without the
+	// version, or nil if they aren't in KV.
+	Package            *packages.Package
+	AggregatedMetadata *packages.Package
+}
+
+// Keys returns the keys deleted from all namespaces.
+func (d *Deletion) Keys() []string {
+	keys := append([]string{}, d.Files...)
+	keys = append(keys, d.SRIs...)
+	if d.Version != "" {
+		keys = append(keys, d.Version)
+	}
+	return keys
+}
+
+// CheckVersion checks that a version can be part of a key. An empty version,
+// ".", ".." or a version with a slash would make the key of the version
+// refer to another version or to the whole package.
+func CheckVersion(version string) error {
+	if version == "" || version == "." || version == ".." || strings.Contains(version, "/") {
+		return errors.Errorf("invalid version %q", version)
+	}
+	return nil
+}
+
+// Returns the key of the tombstone of a yanked version, in the packages
+// namespace. The package names have no slash, so it isn't the key of the
+// metadata of a package, and the `yanked/` prefix keeps the tombstones
+// apart from the keys under the name of a package.
+func tombstoneKey(pkgName, version string) string {
+	return fmt.Sprintf("yanked/%s/%s", pkgName, version)
+}
+
+// GetYankedVersions lists the versions of a package which were yanked, and
+// must not be published again.
+func GetYankedVersions(ctx context.Context, ns *Namespaces, pkgName string) ([]string, error) {
+	prefix := tombstoneKey(pkgName, "")
+	list, err := listByPrefixNamesOnly(ctx, ns.Packages, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list yanked versions")
+	}
+	versions := make([]string, len(list))
+	for i, key := range list {
+		versions[i] = strings.TrimPrefix(key, prefix)
+	}
+	return versions, nil
+}
+
+// DeleteVersion deletes a version of a package from KV: its files, its SRIs
+// and its version entry. The version is dropped from the aggregated metadata,
+// and the latest version and the filename of the package are recomputed from
+// the remaining versions. The metadata is updated first so that it never
+// refers to deleted keys, after a tombstone which keeps the autoupdater from
+// publishing the version again.
+// If dryRun is set, the changes are returned without being made.
+func DeleteVersion(ctx context.Context, ns *Namespaces, pkgName, version string, dryRun bool) (*Deletion, error) {
+	if err := CheckVersion(version); err != nil {
+		return nil, err
+	}
+	versionKey := fmt.Sprintf("%s/%s", pkgName, version)
+	d := &Deletion{}
+
+	// the trailing slash excludes the versions with the same prefix
+	var err error
+	if d.Files, err = listByPrefixNamesOnly(ctx, ns.Files, versionKey+"/"); err != nil {
+		return nil, errors.Wrap(err, "failed to list files")
+	}
+	if d.SRIs, err = listByPrefixNamesOnly(ctx, ns.SRIs, versionKey+"/"); err != nil {
+		return nil, errors.Wrap(err, "failed to list SRIs")
+	}
+	if found, err := exists(ctx, ns.SRIs, versionKey); err != nil {
+		return nil, errors.Wrap(err, "failed to read SRIs")
+	} else if found {
+		d.SRIs = append(d.SRIs, versionKey)
+	}
+	if found, err := exists(ctx, ns.Versions, versionKey); err != nil {
+		return nil, errors.Wrap(err, "failed to read version")
+	} else if found {
+		d.Version = versionKey
+	}
+
+	// latest of the remaining versions
+	versions, err := GetVersions(ctx, ns.Versions, pkgName)
+	if err != nil {
+		return nil, err
+	}
+	remaining := make([]string, 0, len(versions))
+	for _, v := range versions {
+		if v != version {
+			remaining = append(remaining, v)
+		}
+	}
+	latest := packages.GetLatestStableVersion(remaining)
+
+	if d.AggregatedMetadata, err = getAggregatedMetadata(ctx, ns.AggregatedMetadata, pkgName); err != nil {
+		if _, ok := err.(KeyNotFoundError); !ok {
+			return nil, errors.Wrap(err, "failed to read aggregated metadata")
+		}
+	} else {
+		d.AggregatedMetadata.RemoveVersion(version)
+		d.AggregatedMetadata.Version = latest
+	}
+
+	if d.Package, err = GetPackage(ctx, ns.Packages, pkgName); err != nil {
+		if _, ok := err.(KeyNotFoundError); !ok {
+			return nil, errors.Wrap(err, "failed to read package")
+		}
+	} else {
+		d.Package.Version = latest
+		if latest != nil {
+			files, err := GetVersion(ctx, ns.Versions, path.Join(pkgName, *latest))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read version %s", *latest)
+			}
+			if err := packages.UpdateFilenameIfMissing(ctx, d.Package, files); err != nil {
+				return nil, errors.Wrap(err, "failed to fix missing filename")
+			}
+		}
+	}
+
+	if len(d.Keys()) == 0 {
+		return nil, errors.Errorf("version %s of %s not found in KV", version, pkgName)
+	}
+	d.Tombstone = tombstoneKey(pkgName, version)
+	if dryRun {
+		return d, nil
+	}
+
+	tombstone := &Pair{Key: d.Tombstone, Value: []byte(time.Now().UTC().Format(time.RFC3339))}
+	if err := ns.Packages.WriteBulk(ctx, []*Pair{tombstone}); err != nil {
+		return nil, errors.Wrap(err, "failed to write tombstone")
+	}
+	if d.AggregatedMetadata != nil {
+		if _, err := writeAggregatedMetadata(ctx, ns.AggregatedMetadata, d.AggregatedMetadata); err != nil {
+			return nil, errors.Wrap(err, "failed to write aggregated metadata")
+		}
+	}
+	if d.Package != nil {
+		if err := UpdateKVPackage(ctx, ns.Packages, d.Package); err != nil {
+			return nil, errors.Wrap(err, "failed to write package")
+		}
+	}
+	if d.Version != "" {
+		if err := ns.Versions.Delete(ctx, []string{d.Version}); err != nil {
+			return nil, errors.Wrap(err, "failed to delete version")
+		}
+	}
+	if len(d.SRIs) > 0 {
+		if err := ns.SRIs.Delete(ctx, d.SRIs); err != nil {
+			return nil, errors.Wrap(err, "failed to delete SRIs")
+		}
+	}
+	if len(d.Files) > 0 {
+		if err := ns.Files.Delete(ctx, d.Files); err != nil {
+			return nil, errors.Wrap(err, "failed to delete files")
+		}
+	}
+	log.Printf("%s: deleted version %s (%d keys)\n", pkgName, version, len(d.Keys()))
+	return d, nil
+}
+
+// Checks whether a key exists.
+func exists(ctx context.Context, store Store, key string) (bool, error) {
+	_, err := store.Read(ctx, key)
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case KeyNotFoundError:
+		return false, nil
+	}
+	return false, err
+}
+
+// String describes the deletion.
+func (d *Deletion) String() string {
+	s := fmt.Sprintf("version: %s\n", d.Version)
+	s += fmt.Sprintf("tombstone: %s\n", d.Tombstone)
+	s += fmt.Sprintf("files (%d):\n", len(d.Files))
+	for _, key := range d.Files {
+		s += fmt.Sprintf("- %s\n", key)
+	}
+	s += fmt.Sprintf("SRIs (%d):\n", len(d.SRIs))
+	for _, key := range d.SRIs {
+		s += fmt.Sprintf("- %s\n", key)
+	}
+	if d.Package != nil {
+		s += fmt.Sprintf("package version: %s\n", stringOrNil(d.Package.Version))
+		s += fmt.Sprintf("package filename: %s\n", stringOrNil(d.Package.Filename))
+	}
+	if d.AggregatedMetadata != nil {
+		s += fmt.Sprintf("aggregated metadata: %d versions\n", len(d.AggregatedMetadata.Assets))
+	}
+	return s
+}
+
+func stringOrNil(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
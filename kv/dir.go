@@ -0,0 +1,115 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DirStore is a Store in a directory, to run locally. The values and the
+// metadata are in the `values` and `metadata` subdirectories, in files
+// named after the escaped keys, since a key can be the prefix of another.
+type DirStore struct {
+	Dir string
+}
+
+// NewDirStore creates a DirStore, and its directory if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	s := &DirStore{Dir: dir}
+	for _, sub := range []string{"values", "metadata"} {
+		if err := os.MkdirAll(path.Join(dir, sub), 0755); err != nil {
+			return nil, errors.Wrap(err, "could not create kv directory")
+		}
+	}
+	return s, nil
+}
+
+func (s *DirStore) valuePath(key string) string {
+	return path.Join(s.Dir, "values", url.PathEscape(key))
+}
+
+func (s *DirStore) metaPath(key string) string {
+	return path.Join(s.Dir, "metadata", url.PathEscape(key))
+}
+
+// Read reads the value of a key.
+func (s *DirStore) Read(ctx context.Context, key string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(s.valuePath(key))
+	if os.IsNotExist(err) {
+		return nil, KeyNotFoundError{key, err.Error()}
+	}
+	return bytes, err
+}
+
+// WriteBulk writes key-value pairs.
+func (s *DirStore) WriteBulk(ctx context.Context, pairs []*Pair) error {
+	for _, p := range pairs {
+		if err := ioutil.WriteFile(s.valuePath(p.Key), p.Value, 0644); err != nil {
+			return errors.Wrapf(err, "could not write %s", p.Key)
+		}
+		if p.Meta == nil {
+			if err := os.Remove(s.metaPath(p.Key)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "could not remove metadata of %s", p.Key)
+			}
+			continue
+		}
+		bytes, err := json.Marshal(p.Meta)
+		if err != nil {
+			return errors.Wrapf(err, "could not marshal metadata of %s", p.Key)
+		}
+		if err := ioutil.WriteFile(s.metaPath(p.Key), bytes, 0644); err != nil {
+			return errors.Wrapf(err, "could not write metadata of %s", p.Key)
+		}
+	}
+	return nil
+}
+
+// ListByPrefix lists the keys starting with a prefix.
+func (s *DirStore) ListByPrefix(ctx context.Context, prefix string) ([]Key, error) {
+	files, err := ioutil.ReadDir(path.Join(s.Dir, "values"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list kv directory")
+	}
+
+	var keys []Key
+	for _, f := range files {
+		name, err := url.PathUnescape(f.Name())
+		if err != nil || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		key := Key{Name: name}
+		bytes, err := ioutil.ReadFile(s.metaPath(name))
+		switch {
+		case err == nil:
+			key.Meta = new(FileMetadata)
+			if err := json.Unmarshal(bytes, key.Meta); err != nil {
+				return nil, errors.Wrapf(err, "invalid metadata of %s", name)
+			}
+		case !os.IsNotExist(err):
+			return nil, errors.Wrapf(err, "could not read metadata of %s", name)
+		}
+		keys = append(keys, key)
+	}
+	// the files are sorted by escaped name
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// Delete deletes keys.
+func (s *DirStore) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		for _, file := range []string{s.valuePath(key), s.metaPath(key)} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "could not delete %s", key)
+			}
+		}
+	}
+	return nil
+}
@@ -6,27 +6,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	// "sort"
-	"strings"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/cdnjs/tools/sentry"
 	"github.com/cdnjs/tools/util"
-	cloudflare "github.com/cloudflare/cloudflare-go"
+
+	"github.com/pkg/errors"
 )
 
 const (
-	// workaround for now since cloudflare's API does not currently
-	// return a cloudflare.Response object for api.ReadWorkersKV
-	keyNotFound    = "key not found"
-	authError      = "Authentication error"
-	serviceFailure = "service failure"
-)
+	keyNotFound = "key not found"
+	authError   = "Authentication error"
 
-var (
-	versionsNamespaceID           = os.Getenv("WORKERS_KV_VERSIONS_NAMESPACE_ID")
-	packagesNamespaceID           = os.Getenv("WORKERS_KV_PACKAGES_NAMESPACE_ID")
-	aggregatedMetadataNamespaceID = os.Getenv("WORKERS_KV_AGGREGATED_METADATA_NAMESPACE_ID")
+	// codes of the errors of the Cloudflare API
+	codeAuthError   = 10000
+	codeKeyNotFound = 10009
 )
 
 // KeyNotFoundError represents a KV key not found.
@@ -50,67 +46,183 @@ func (a AuthError) Error() string {
 	return fmt.Sprintf("%s: %s", authError, a.err)
 }
 
-// Ensure a response is successful and the error is nil.
-func checkSuccess(r cloudflare.Response, err error) error {
-	if err != nil {
-		return err
+// APIError is an error reported by the Cloudflare API.
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is a failed request to Workers KV, other than a KeyNotFoundError
+// or an AuthError.
+type Error struct {
+	Status     int // HTTP status, zero if there was no response
+	Errors     []APIError
+	RetryAfter time.Duration // delay requested by the API, if any
+	Err        error
+}
+
+// Error is used to satisfy the error interface.
+func (e Error) Error() string {
+	msg := "kv request failed"
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" with status %d", e.Status)
 	}
-	if !r.Success {
-		return fmt.Errorf("kv fail: %v", r)
+	for _, apiErr := range e.Errors {
+		msg += fmt.Sprintf(": %s (%d)", apiErr.Message, apiErr.Code)
 	}
-	return nil
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
 }
 
-// read reads an entry from Workers KV.
-func read(api *cloudflare.API, key string, namespaceID string) ([]byte, error) {
-	var bytes []byte
-	var err error
-	for i := 0; i < util.MaxKVAttempts; i++ {
-		bytes, err = api.ReadWorkersKV(context.Background(), namespaceID, key)
-		if err != nil {
-			errString := err.Error()
+// Temporary reports whether the request may succeed if it is retried,
+// after a network error, a rate limit or a server error.
+func (e Error) Temporary() bool {
+	return e.Status == 0 || e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
 
-			// check for service failure and retry
-			if strings.Contains(errString, serviceFailure) {
-				continue
-			}
+// Classifies a failed response from its status and its error codes. The
+// key is the one read, if any.
+func classify(resp *http.Response, body []byte, key string) error {
+	var parsed apiResponse
+	json.Unmarshal(body, &parsed) // the errors are optional
+	msg := fmt.Sprintf("status %d", resp.StatusCode)
+	codes := make(map[int]bool)
+	for _, apiErr := range parsed.Errors {
+		codes[apiErr.Code] = true
+		msg += fmt.Sprintf(": %s (%d)", apiErr.Message, apiErr.Code)
+	}
 
-			// check for key not found
-			if strings.Contains(errString, keyNotFound) {
-				return nil, KeyNotFoundError{key, errString}
-			}
+	switch {
+	case key != "" && (codes[codeKeyNotFound] || resp.StatusCode == http.StatusNotFound):
+		return KeyNotFoundError{key, msg}
+	case codes[codeAuthError] || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return AuthError{msg}
+	}
+	return Error{
+		Status:     resp.StatusCode,
+		Errors:     parsed.Errors,
+		RetryAfter: util.RetryAfter(resp),
+	}
+}
 
-			// check for authentication error
-			if strings.Contains(errString, authError) {
-				return nil, AuthError{errString}
-			}
-		}
+// DefaultConcurrency is the default number of concurrent bulk requests.
+const DefaultConcurrency = 4
 
-		break
-	}
+// BulkOptions are the options of a bulk write.
+type BulkOptions struct {
+	PanicOversized bool           // panic on oversized values, instead of ignoring them
+	Concurrency    int            // maximum number of concurrent bulk requests, defaults to DefaultConcurrency
+	Progress       func(Progress) // called after each successful bulk request
+}
 
-	return bytes, err
+// Progress is the progress of a bulk write.
+type Progress struct {
+	Bulks   int   // number of bulk requests written
+	Keys    int   // number of keys written
+	Bytes   int64 // size of the values and metadata written, encoded
+	Elapsed time.Duration
 }
 
-// Encodes a byte array to a base64 string.
-func encodeToBase64(bytes []byte) string {
-	return base64.StdEncoding.EncodeToString(bytes)
+// ErrSkipped is the error of the bulk requests skipped after a failed one.
+var ErrSkipped = errors.New("skipped after a failed bulk write")
+
+// BulkResult is the result of a bulk write request.
+type BulkResult struct {
+	Keys  []string
+	Names []string // human-readable names of the write requests
+	Err   error    // nil if all the keys were written
 }
 
-// Encodes key-value pairs to base64 and writes them to KV in multiple bulk requests.
-// Returns the list of human-readable names of successful writes.
-func EncodeAndWriteKVBulk(ctx context.Context, cfapi *cloudflare.API,
-	kvs []WriteRequest, namespaceID string, panicOversized bool) ([]string, error) {
-	var bulkWrites []cloudflare.WorkersKVBulkWriteRequest
-	var bulkWrite []*cloudflare.WorkersKVPair
-	var successfulWrites []string
+// Encodes key-value pairs and writes them to a store in multiple bulk requests,
+// which fit in the limits of Workers KV. The requests are sent as soon as they
+// are built, with bounded concurrency, and the values of the write requests are
+// released once their bulk request succeeds.
+// Returns the result of each bulk request. If a request fails, the next ones
+// are skipped and an error is returned.
+func EncodeAndWriteKVBulk(ctx context.Context, store Store,
+	kvs []WriteRequest, opts BulkOptions) ([]BulkResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // guards the following
+		results  []BulkResult
+		firstErr error
+		progress Progress
+	)
+	slots := make(chan struct{}, concurrency)
+	start := time.Now()
+
+	write := func(i int, reqs []WriteRequest, bulkWrite []*Pair, size int64) {
+		defer func() { <-slots }()
+		defer wg.Done()
+
+		log.Printf("writing bulk %d (keys=%d)...\n", i+1, len(bulkWrite))
+		err := store.WriteBulk(ctx, bulkWrite)
+
+		mu.Lock()
+		defer mu.Unlock()
+		results[i].Err = err
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "failed to write bulk %d", i+1)
+			}
+			return
+		}
+		for _, req := range reqs {
+			req.Consumed()
+		}
+		progress.Bulks++
+		progress.Keys += len(reqs)
+		progress.Bytes += size
+		progress.Elapsed = time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	// sends a bulk request, once a slot is available
+	send := func(reqs []WriteRequest, bulkWrite []*Pair, size int64) {
+		if len(reqs) == 0 {
+			return
+		}
+		result := BulkResult{}
+		for _, req := range reqs {
+			result.Keys = append(result.Keys, req.GetKey())
+			result.Names = append(result.Names, req.GetName())
+		}
+		slots <- struct{}{}
+
+		mu.Lock()
+		failed := firstErr != nil
+		if failed {
+			result.Err = ErrSkipped
+		}
+		results = append(results, result)
+		i := len(results) - 1
+		mu.Unlock()
+
+		if failed {
+			<-slots
+			return
+		}
+		wg.Add(1)
+		go write(i, reqs, bulkWrite, size)
+	}
+
+	var reqs []WriteRequest
+	var bulkWrite []*Pair
 	var totalSize, totalKeys int64
 
 	for _, kv := range kvs {
 		if unencodedSize := int64(len(kv.GetValue())); unencodedSize > util.MaxFileSize {
 			log.Printf("ignoring oversized file: %s (%d)\n", kv.GetKey(), unencodedSize)
 			sentry.NotifyError(fmt.Errorf("ignoring oversized file: %s (%d)", kv.GetKey(), unencodedSize))
-			if panicOversized {
+			if opts.PanicOversized {
 				panic(fmt.Sprintf("oversized file: %s (%d)", kv.GetKey(), unencodedSize))
 			}
 			continue
@@ -118,99 +230,63 @@ func EncodeAndWriteKVBulk(ctx context.Context, cfapi *cloudflare.API,
 		// Note that after encoding in base64 the size may get larger, but after decoding
 		// it will be reduced, so it is okay if the size is larger than util.MaxFileSize after encoding base64.
 		// However, we still need to check for the KV bulk request limit of 100MiB.
-		encodedValue := encodeToBase64(kv.GetValue())
-		size := int64(len(encodedValue))
-		writePair := &cloudflare.WorkersKVPair{
-			Key:    kv.GetKey(),
-			Value:  encodedValue,
-			Base64: true,
+		size := int64(base64.StdEncoding.EncodedLen(len(kv.GetValue())))
+		writePair := &Pair{
+			Key:   kv.GetKey(),
+			Value: kv.GetValue(),
 		}
 		if kv.GetMeta() != nil {
 			// Marshal metadata into JSON bytes.
 			bytes, err := json.Marshal(kv.GetMeta())
 			if err != nil {
-				return nil, err
+				wg.Wait()
+				return results, err
 			}
 			metasize := int64(len(bytes))
 			if metasize > util.MaxMetadataSize {
 				log.Printf("ignoring oversized metadata: %s (%d)\n", kv.GetKey(), metasize)
 				sentry.NotifyError(fmt.Errorf("oversized metadata: %s (%d) - %s", kv.GetKey(), metasize, bytes))
-				if panicOversized {
+				if opts.PanicOversized {
 					panic(fmt.Sprintf("oversized metadata: %s (%d)", kv.GetKey(), metasize))
 				}
 				continue
 			}
-			writePair.Metadata = kv.GetMeta()
+			writePair.Meta = kv.GetMeta()
 			size += metasize
 		}
 		if totalSize+size > util.MaxBulkWritePayload || totalKeys == util.MaxBulkKeys {
-			// Create a new bulk since we are over a limit.
-			bulkWrites = append(bulkWrites, bulkWrite)
-			bulkWrite = []*cloudflare.WorkersKVPair{}
+			// Send the bulk since we are over a limit.
+			send(reqs, bulkWrite, totalSize)
+			reqs = nil
+			bulkWrite = nil
 			totalSize = 0
 			totalKeys = 0
 		}
+		reqs = append(reqs, kv)
 		bulkWrite = append(bulkWrite, writePair)
-		successfulWrites = append(successfulWrites, kv.GetName())
 		totalSize += size
 		totalKeys++
-
-		kv.Consumed()
 	}
-	bulkWrites = append(bulkWrites, bulkWrite)
-
-	for i, b := range bulkWrites {
-		log.Printf("writing bulk %d/%d (keys=%d)...\n", i+1, len(bulkWrites), len(b))
-		for j := 0; j < util.MaxKVAttempts; j++ {
-			r, err := cfapi.WriteWorkersKVBulk(ctx, namespaceID, b)
-
-			// check for service failure and retry
-			if err != nil && strings.Contains(err.Error(), serviceFailure) {
-				if j == util.MaxKVAttempts-1 {
-					return nil, err // no more attempts
-				}
-				continue // retry
-			}
+	send(reqs, bulkWrite, totalSize)
+	wg.Wait()
 
-			if err = checkSuccess(r, err); err != nil {
-				return nil, err
-			}
-
-			break
-		}
-	}
-
-	return successfulWrites, nil
+	return results, firstErr
 }
 
-// Returns all KVs that start with a prefix.
-func listByPrefix(api *cloudflare.API, prefix, namespaceID string) ([]cloudflare.StorageKey, error) {
-	var cursor *string
-	var results []cloudflare.StorageKey
-	for {
-		o := cloudflare.ListWorkersKVsOptions{
-			Prefix: &prefix,
-			Cursor: cursor,
-		}
-
-		resp, err := api.ListWorkersKVsWithOptions(context.Background(), namespaceID, o)
-		if err != nil {
-			return nil, err
-		}
-
-		results = append(results, resp.Result...)
-
-		if resp.Cursor == "" {
-			return results, nil
+// Written returns the human-readable names of the successful writes.
+func Written(results []BulkResult) []string {
+	var names []string
+	for _, r := range results {
+		if r.Err == nil {
+			names = append(names, r.Names...)
 		}
-
-		cursor = &resp.Cursor
 	}
+	return names
 }
 
 // Lists by prefix and then returns only the names of the results.
-func listByPrefixNamesOnly(api *cloudflare.API, prefix string, namespaceID string) ([]string, error) {
-	results, err := listByPrefix(api, prefix, namespaceID)
+func listByPrefixNamesOnly(ctx context.Context, store Store, prefix string) ([]string, error) {
+	results, err := store.ListByPrefix(ctx, prefix)
 	if err != nil {
 		return nil, err
 	}
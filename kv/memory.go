@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store, for tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Pair
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Pair)}
+}
+
+// Read reads the value of a key.
+func (s *MemoryStore) Read(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.entries[key]
+	if !ok {
+		return nil, KeyNotFoundError{key, "not in memory"}
+	}
+	return append([]byte{}, p.Value...), nil
+}
+
+// WriteBulk writes key-value pairs.
+func (s *MemoryStore) WriteBulk(ctx context.Context, pairs []*Pair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range pairs {
+		s.entries[p.Key] = Pair{Key: p.Key, Value: append([]byte{}, p.Value...), Meta: copyMeta(p.Meta)}
+	}
+	return nil
+}
+
+// ListByPrefix lists the keys starting with a prefix.
+func (s *MemoryStore) ListByPrefix(ctx context.Context, prefix string) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []Key
+	for name, p := range s.entries {
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, Key{Name: name, Meta: copyMeta(p.Meta)})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// Delete deletes keys.
+func (s *MemoryStore) Delete(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func copyMeta(meta *FileMetadata) *FileMetadata {
+	if meta == nil {
+		return nil
+	}
+	copied := *meta
+	return &copied
+}
@@ -5,16 +5,14 @@ import (
 	"fmt"
 
 	"github.com/cdnjs/tools/packages"
-
-	cloudflare "github.com/cloudflare/cloudflare-go"
 )
 
 // GetPackage gets the package metadata from KV.
 // It will validate against the non-human-readable schema, returning
 // a packages.InvalidSchemaError if the schema is invalid, a KeyNotFoundError
 // if the KV key is not found, and an AuthError if there is an authentication error.
-func GetPackage(ctx context.Context, api *cloudflare.API, key string) (*packages.Package, error) {
-	bytes, err := read(api, key, packagesNamespaceID)
+func GetPackage(ctx context.Context, store Store, key string) (*packages.Package, error) {
+	bytes, err := store.Read(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -25,7 +23,7 @@ func GetPackage(ctx context.Context, api *cloudflare.API, key string) (*packages
 
 // UpdateKVPackage gets the request to update a package metadata entry in KV with a new version.
 // Must have the `version` field by now.
-func UpdateKVPackage(ctx context.Context, api *cloudflare.API, p *packages.Package) error {
+func UpdateKVPackage(ctx context.Context, store Store, p *packages.Package) error {
 	// marshal package into JSON
 	v, err := p.Marshal()
 	if err != nil {
@@ -36,6 +34,6 @@ func UpdateKVPackage(ctx context.Context, api *cloudflare.API, p *packages.Packa
 		Key:   *p.Name,
 		Value: v,
 	}
-	_, err = EncodeAndWriteKVBulk(ctx, api, []WriteRequest{req}, packagesNamespaceID, true)
+	_, err = EncodeAndWriteKVBulk(ctx, store, []WriteRequest{req}, BulkOptions{PanicOversized: true})
 	return err
 }
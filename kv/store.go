@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"context"
+	"os"
+	"path"
+)
+
+// Store is a namespace of a key-value storage, such as a Workers KV namespace.
+type Store interface {
+	// Read reads the value of a key, or returns a KeyNotFoundError.
+	Read(ctx context.Context, key string) ([]byte, error)
+
+	// WriteBulk writes key-value pairs, replacing their previous value
+	// and metadata.
+	WriteBulk(ctx context.Context, pairs []*Pair) error
+
+	// ListByPrefix lists the keys starting with a prefix, with their
+	// metadata, in lexicographic order.
+	ListByPrefix(ctx context.Context, prefix string) ([]Key, error)
+
+	// Delete deletes keys. Keys which don't exist are ignored.
+	Delete(ctx context.Context, keys []string) error
+}
+
+// Pair is a key-value pair written to a Store.
+type Pair struct {
+	Key   string
+	Value []byte
+	Meta  *FileMetadata // optional
+}
+
+// Key is a key listed in a Store.
+type Key struct {
+	Name string
+	Meta *FileMetadata // nil if the key has no metadata
+}
+
+// Namespaces are the stores used by cdnjs.
+type Namespaces struct {
+	Files              Store // files of the versions, by `<pkg>/<version>/<file>`
+	SRIs               Store // SRIs of the files, by `<pkg>/<version>/<file>`
+	Versions           Store // files of each version, by `<pkg>/<version>`
+	Packages           Store // metadata of the packages
+	AggregatedMetadata Store // metadata of the packages with all their versions
+}
+
+// NewNamespaces opens the namespaces configured in the environment. If
+// KV_DIR is set, they are subdirectories of it, to run locally. Otherwise
+// they are the Workers KV namespaces of the WORKERS_KV_*_NAMESPACE_ID
+// variables, accessed with an API token of the account.
+func NewNamespaces(token, accountID string) (*Namespaces, error) {
+	if dir := os.Getenv("KV_DIR"); dir != "" {
+		return newDirNamespaces(dir)
+	}
+
+	client := NewClient(accountID, token)
+	filesNamespaceID := os.Getenv("WORKERS_KV_FILES_NAMESPACE_ID")
+	if filesNamespaceID == "" {
+		// former name, used by kv-pump
+		filesNamespaceID = os.Getenv("FILES_KV_NAMESPACE_ID")
+	}
+	return &Namespaces{
+		Files:              NewCloudflareStore(client, filesNamespaceID),
+		SRIs:               NewCloudflareStore(client, os.Getenv("WORKERS_KV_SRIS_NAMESPACE_ID")),
+		Versions:           NewCloudflareStore(client, os.Getenv("WORKERS_KV_VERSIONS_NAMESPACE_ID")),
+		Packages:           NewCloudflareStore(client, os.Getenv("WORKERS_KV_PACKAGES_NAMESPACE_ID")),
+		AggregatedMetadata: NewCloudflareStore(client, os.Getenv("WORKERS_KV_AGGREGATED_METADATA_NAMESPACE_ID")),
+	}, nil
+}
+
+// Opens a directory store for each namespace.
+func newDirNamespaces(dir string) (*Namespaces, error) {
+	var ns Namespaces
+	for name, store := range map[string]*Store{
+		"files":               &ns.Files,
+		"sris":                &ns.SRIs,
+		"versions":            &ns.Versions,
+		"packages":            &ns.Packages,
+		"aggregated-metadata": &ns.AggregatedMetadata,
+	} {
+		s, err := NewDirStore(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		*store = s
+	}
+	return &ns, nil
+}
+
+// NewMemoryNamespaces creates empty in-memory namespaces.
+func NewMemoryNamespaces() *Namespaces {
+	return &Namespaces{
+		Files:              NewMemoryStore(),
+		SRIs:               NewMemoryStore(),
+		Versions:           NewMemoryStore(),
+		Packages:           NewMemoryStore(),
+		AggregatedMetadata: NewMemoryStore(),
+	}
+}
@@ -0,0 +1,453 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cdnjs/tools/compress"
+	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/pkg/errors"
+)
+
+// Types of inconsistencies between the namespaces of a package.
+const (
+	// The version entry exists, but no file of the version is in KV.
+	VersionWithoutFiles = "version-without-files"
+	// Files of the version are in KV, but there is no version entry. The
+	// integrity manifest of the version, which kv-pump writes last, lists
+	// them, so the version entry can be rebuilt.
+	FilesWithoutVersion = "files-without-version"
+	// A file of a version without version entry, nor an integrity manifest
+	// listing the same files. Either kv-pump is still publishing the version
+	// or the archive had no integrity manifest, so it isn't repaired.
+	OrphanFile = "orphan-file"
+	// The version entry lists a file which isn't in KV.
+	MissingFile = "missing-file"
+	// A file of the version is in KV, but isn't listed by the version entry.
+	UnlistedFile = "unlisted-file"
+	// An SRI is in KV for a file which isn't.
+	SRIForMissingFile = "sri-for-missing-file"
+	// The aggregated metadata doesn't list a version which is in KV.
+	AggregateMissingVersion = "aggregate-missing-version"
+	// The aggregated metadata lists a version which isn't in KV.
+	AggregateExtraVersion = "aggregate-extra-version"
+	// The aggregated metadata lists other files than the version.
+	AggregateFilesMismatch = "aggregate-files-mismatch"
+	// The latest version of the package isn't the latest stable version in
+	// KV. The one of the aggregated metadata is the last version pumped, so
+	// it isn't checked.
+	StaleLatestVersion = "stale-latest-version"
+	// The package or its aggregated metadata isn't in KV. The package
+	// metadata comes from its configuration, so it can't be repaired.
+	MissingPackage   = "missing-package"
+	MissingAggregate = "missing-aggregate"
+)
+
+// Inconsistency is a disagreement between the namespaces of a package.
+type Inconsistency struct {
+	Type     string // one of the types of inconsistencies
+	Key      string // key of the offending entry
+	Detail   string
+	Repaired bool
+}
+
+func (i Inconsistency) String() string {
+	s := fmt.Sprintf("%s: %s", i.Type, i.Key)
+	if i.Detail != "" {
+		s += " (" + i.Detail + ")"
+	}
+	if i.Repaired {
+		s += " [repaired]"
+	}
+	return s
+}
+
+// State of the namespaces of a package.
+type verification struct {
+	pkgName string
+
+	// original file names served for each version, which the files
+	// namespace is authoritative for, and their keys
+	served   map[string][]string
+	fileKeys map[string][]string
+	// versions whose files are in KV, but which weren't completely published
+	pending map[string]bool
+	// files listed by the version entries
+	listed map[string][]string
+	sris   []string
+
+	pkg        *packages.Package
+	aggregated *packages.Package
+
+	inconsistencies []Inconsistency
+}
+
+// VerifyPackage cross-checks the files, SRIs, versions, package and
+// aggregated metadata namespaces for a package, and returns the
+// inconsistencies found. If repair is set, they are repaired from the
+// files namespace, which is written first by kv-pump and is what is served:
+// the version entries, the aggregated metadata and the latest version of the
+// package are rewritten from the files, and the SRIs of missing files are
+// deleted. The entry of a version is only rebuilt if its integrity manifest,
+// written last by kv-pump, lists its files, or else they are reported as
+// orphans and left untouched. Missing package metadata can't be repaired.
+func VerifyPackage(ctx context.Context, ns *Namespaces, pkgName string, repair bool) ([]Inconsistency, error) {
+	v := &verification{
+		pkgName:  pkgName,
+		served:   make(map[string][]string),
+		fileKeys: make(map[string][]string),
+		listed:   make(map[string][]string),
+		pending:  make(map[string]bool),
+	}
+	if err := v.read(ctx, ns); err != nil {
+		return nil, err
+	}
+
+	// versions must be repaired before the metadata listing them
+	checks := []func(context.Context, *Namespaces, bool) error{
+		v.checkVersions,
+		v.checkAggregate,
+		v.checkPackage,
+		v.checkSRIs,
+	}
+	for _, check := range checks {
+		if err := check(ctx, ns, repair); err != nil {
+			return v.inconsistencies, err
+		}
+	}
+	if repair && len(v.inconsistencies) > 0 {
+		log.Printf("%s: repaired %d inconsistencies\n", pkgName, repaired(v.inconsistencies))
+	}
+	return v.inconsistencies, nil
+}
+
+// Reads the entries of the package in all namespaces.
+func (v *verification) read(ctx context.Context, ns *Namespaces) error {
+	prefix := v.pkgName + "/" // excludes the packages with the same prefix
+
+	files, err := listByPrefixNamesOnly(ctx, ns.Files, prefix)
+	if err != nil {
+		return errors.Wrap(err, "failed to list files")
+	}
+	for _, key := range files {
+		if version, name, ok := splitFileKey(key); ok {
+			// compressed variants are served under the original name
+			name, _ = compress.SplitEncodingExt(name)
+			v.served[version] = appendUnique(v.served[version], name)
+			v.fileKeys[version] = append(v.fileKeys[version], key)
+		}
+	}
+
+	versions, err := GetVersions(ctx, ns.Versions, v.pkgName)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		files, err := GetVersion(ctx, ns.Versions, path.Join(v.pkgName, version))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read version %s", version)
+		}
+		v.listed[version] = files
+	}
+
+	if v.sris, err = listByPrefixNamesOnly(ctx, ns.SRIs, prefix); err != nil {
+		return errors.Wrap(err, "failed to list SRIs")
+	}
+
+	if v.pkg, err = GetPackage(ctx, ns.Packages, v.pkgName); err != nil {
+		if _, ok := err.(KeyNotFoundError); !ok {
+			return errors.Wrap(err, "failed to read package")
+		}
+	}
+	if v.aggregated, err = getAggregatedMetadata(ctx, ns.AggregatedMetadata, v.pkgName); err != nil {
+		if _, ok := err.(KeyNotFoundError); !ok {
+			return errors.Wrap(err, "failed to read aggregated metadata")
+		}
+	}
+	return nil
+}
+
+// Checks the version entries against the files.
+func (v *verification) checkVersions(ctx context.Context, ns *Namespaces, repair bool) error {
+	var stale []string
+	var rewrite []string
+	for _, version := range v.versions() {
+		key := path.Join(v.pkgName, version)
+		served, hasFiles := v.served[version]
+		listed, hasEntry := v.listed[version]
+
+		switch {
+		case hasEntry && !hasFiles:
+			v.report(VersionWithoutFiles, key, "", repair)
+			stale = append(stale, key)
+		case !hasEntry && hasFiles:
+			published, err := v.isPublished(ctx, ns, version)
+			if err != nil {
+				return err
+			}
+			if published {
+				v.report(FilesWithoutVersion, key, fmt.Sprintf("%d files", len(served)), repair)
+				rewrite = append(rewrite, version)
+				continue
+			}
+			// not served anymore for the other checks
+			for _, fileKey := range v.fileKeys[version] {
+				v.report(OrphanFile, fileKey, "", false)
+			}
+			v.pending[version] = true
+			delete(v.served, version)
+		default:
+			missing, unlisted := diff(listed, served)
+			for _, name := range missing {
+				v.report(MissingFile, path.Join(key, name), "", repair)
+			}
+			for _, name := range unlisted {
+				v.report(UnlistedFile, path.Join(key, name), "", repair)
+			}
+			if len(missing)+len(unlisted) > 0 {
+				rewrite = append(rewrite, version)
+			}
+		}
+	}
+
+	if !repair {
+		return nil
+	}
+	for _, version := range rewrite {
+		if _, err := UpdateKVVersion(ctx, ns.Versions, v.pkgName, version, v.served[version]); err != nil {
+			return errors.Wrapf(err, "failed to write version %s", version)
+		}
+	}
+	if len(stale) > 0 {
+		if err := ns.Versions.Delete(ctx, stale); err != nil {
+			return errors.Wrap(err, "failed to delete versions")
+		}
+	}
+	return nil
+}
+
+// Reports whether the integrity manifest of a version lists exactly the
+// files served. kv-pump writes it last, once the version is published.
+func (v *verification) isPublished(ctx context.Context, ns *Namespaces, version string) (bool, error) {
+	data, err := ns.SRIs.Read(ctx, fmt.Sprintf("%s/%s", v.pkgName, version))
+	if err != nil {
+		if _, ok := err.(KeyNotFoundError); ok {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to read integrity manifest of %s", version)
+	}
+	integrity, err := sri.ParseManifest(data)
+	if err != nil {
+		log.Printf("%s: invalid integrity manifest of %s: %s\n", v.pkgName, version, err)
+		return false, nil
+	}
+	missing, unlisted := diff(integrity.Files(), v.served[version])
+	return len(missing)+len(unlisted) == 0, nil
+}
+
+// Checks the aggregated metadata against the files.
+func (v *verification) checkAggregate(ctx context.Context, ns *Namespaces, repair bool) error {
+	found := len(v.inconsistencies)
+	if v.aggregated == nil {
+		// rebuilt from the package if there is anything to aggregate
+		fixable := v.pkg != nil && len(v.served) > 0
+		v.report(MissingAggregate, v.pkgName, "", repair && fixable)
+		if !repair || !fixable {
+			return nil
+		}
+		aggregated := *v.pkg
+		v.aggregated = &aggregated
+	} else {
+		for _, asset := range v.aggregated.Assets {
+			key := path.Join(v.pkgName, asset.Version)
+			served, ok := v.served[asset.Version]
+			if !ok {
+				v.report(AggregateExtraVersion, key, "", repair)
+				continue
+			}
+			if missing, unlisted := diff(asset.Files, served); len(missing)+len(unlisted) > 0 {
+				v.report(AggregateFilesMismatch, key,
+					fmt.Sprintf("%d missing, %d unlisted", len(missing), len(unlisted)), repair)
+			}
+		}
+		for _, version := range v.versions() {
+			if _, ok := v.served[version]; ok && !v.aggregated.HasVersion(version) {
+				v.report(AggregateMissingVersion, path.Join(v.pkgName, version), "", repair)
+			}
+		}
+	}
+
+	if !repair || len(v.inconsistencies) == found {
+		return nil
+	}
+	v.aggregated.Assets = v.assets()
+	v.aggregated.Version = v.latest()
+	if _, err := writeAggregatedMetadata(ctx, ns.AggregatedMetadata, v.aggregated); err != nil {
+		return errors.Wrap(err, "failed to write aggregated metadata")
+	}
+	return nil
+}
+
+// Checks the latest version of the package against the files.
+func (v *verification) checkPackage(ctx context.Context, ns *Namespaces, repair bool) error {
+	if v.pkg == nil {
+		v.report(MissingPackage, v.pkgName, "", false)
+		return nil
+	}
+	if !v.checkLatest(repair) || !repair {
+		return nil
+	}
+
+	v.pkg.Version = v.latest()
+	if v.pkg.Version != nil {
+		if err := packages.UpdateFilenameIfMissing(ctx, v.pkg, v.served[*v.pkg.Version]); err != nil {
+			return errors.Wrap(err, "failed to fix missing filename")
+		}
+	}
+	if err := UpdateKVPackage(ctx, ns.Packages, v.pkg); err != nil {
+		return errors.Wrap(err, "failed to write package")
+	}
+	return nil
+}
+
+// Checks the SRIs against the files. The SRIs are deleted last so that the
+// metadata never refers to deleted keys. The SRIs of the versions being
+// published are left to kv-pump.
+func (v *verification) checkSRIs(ctx context.Context, ns *Namespaces, repair bool) error {
+	var orphans []string
+	for _, key := range v.sris {
+		var found bool
+		if version, name, ok := splitFileKey(key); ok {
+			name, _ = compress.SplitEncodingExt(name)
+			found = v.pending[version] || contains(v.served[version], name)
+		} else {
+			// SRIs of a whole version
+			version := strings.TrimPrefix(key, v.pkgName+"/")
+			_, found = v.served[version]
+			found = found || v.pending[version]
+		}
+		if !found {
+			v.report(SRIForMissingFile, key, "", repair)
+			orphans = append(orphans, key)
+		}
+	}
+
+	if !repair || len(orphans) == 0 {
+		return nil
+	}
+	if err := ns.SRIs.Delete(ctx, orphans); err != nil {
+		return errors.Wrap(err, "failed to delete SRIs")
+	}
+	return nil
+}
+
+// Reports whether the latest version of the package is stale.
+func (v *verification) checkLatest(repair bool) bool {
+	latest := v.latest()
+	if stringOrNil(v.pkg.Version) == stringOrNil(latest) {
+		return false
+	}
+	v.report(StaleLatestVersion, v.pkgName,
+		fmt.Sprintf("%s, expected %s", stringOrNil(v.pkg.Version), stringOrNil(latest)), repair)
+	return true
+}
+
+// Latest stable version served.
+func (v *verification) latest() *string {
+	versions := make([]string, 0, len(v.served))
+	for version := range v.served {
+		versions = append(versions, version)
+	}
+	return packages.GetLatestStableVersion(versions)
+}
+
+// Assets of the versions served, from the latest.
+func (v *verification) assets() []packages.Asset {
+	assets := make([]packages.Asset, 0, len(v.served))
+	for version, files := range v.served {
+		assets = append(assets, packages.Asset{Version: version, Files: files})
+	}
+	sort.Sort(packages.ByVersionAsset(assets))
+	return assets
+}
+
+// Versions found in the files or versions namespaces, sorted.
+func (v *verification) versions() []string {
+	var versions []string
+	for version := range v.served {
+		versions = append(versions, version)
+	}
+	for version := range v.listed {
+		if _, ok := v.served[version]; !ok {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+func (v *verification) report(kind, key, detail string, repaired bool) {
+	v.inconsistencies = append(v.inconsistencies, Inconsistency{
+		Type:     kind,
+		Key:      key,
+		Detail:   detail,
+		Repaired: repaired,
+	})
+}
+
+// Splits a `pkg/version/file` key into its version and file name.
+func splitFileKey(key string) (string, string, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// Returns the names in a missing from b, and the names in b missing from a.
+func diff(a, b []string) ([]string, []string) {
+	var missing, unlisted []string
+	for _, name := range a {
+		if !contains(b, name) {
+			missing = append(missing, name)
+		}
+	}
+	for _, name := range b {
+		if !contains(a, name) {
+			unlisted = append(unlisted, name)
+		}
+	}
+	return missing, unlisted
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(names []string, name string) []string {
+	if contains(names, name) {
+		return names
+	}
+	return append(names, name)
+}
+
+func repaired(inconsistencies []Inconsistency) int {
+	var n int
+	for _, i := range inconsistencies {
+		if i.Repaired {
+			n++
+		}
+	}
+	return n
+}
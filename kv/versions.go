@@ -8,13 +8,12 @@ import (
 
 	"github.com/cdnjs/tools/util"
 
-	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/pkg/errors"
 )
 
 // GetVersions gets the list of KV version keys for a particular package.
-func GetVersions(api *cloudflare.API, pckgname string) ([]string, error) {
-	list, err := listByPrefixNamesOnly(api, pckgname+"/", versionsNamespaceID)
+func GetVersions(ctx context.Context, store Store, pckgname string) ([]string, error) {
+	list, err := listByPrefixNamesOnly(ctx, store, pckgname+"/")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list versions")
 	}
@@ -29,8 +28,8 @@ func GetVersions(api *cloudflare.API, pckgname string) ([]string, error) {
 }
 
 // // GetVersion gets metadata for a particular version.
-func GetVersion(ctx context.Context, api *cloudflare.API, key string) ([]string, error) {
-	bytes, err := read(api, key, versionsNamespaceID)
+func GetVersion(ctx context.Context, store Store, key string) ([]string, error) {
+	bytes, err := store.Read(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -56,8 +55,9 @@ func updateVersionRequest(pkg, version string, files []string) WriteRequest {
 
 // // Updates KV with new version's metadata.
 // // The []string of `files` will already contain the optimized/minified files by now.
-func UpdateKVVersion(ctx context.Context, api *cloudflare.API, pkg, version string, files []string) ([]byte, error) {
+func UpdateKVVersion(ctx context.Context, store Store, pkg, version string, files []string) ([]byte, error) {
 	req := updateVersionRequest(pkg, version, files)
-	_, err := EncodeAndWriteKVBulk(ctx, api, []WriteRequest{req}, versionsNamespaceID, true)
-	return req.GetValue(), err
+	value := req.GetValue() // released once written
+	_, err := EncodeAndWriteKVBulk(ctx, store, []WriteRequest{req}, BulkOptions{PanicOversized: true})
+	return value, err
 }
@@ -0,0 +1,286 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/pkg/errors"
+)
+
+// Name is the name of the manifest in the output of process-version.
+const Name = "manifest.json"
+
+// Reasons for which a file is not published.
+const (
+	// SkipOversized means the file exceeds util.MaxFileSize.
+	SkipOversized = "oversized"
+	// SkipGlobMiss means a fileMap pattern didn't match any file.
+	SkipGlobMiss = "glob-miss"
+	// SkipInvalidGlob means a fileMap pattern could not be compiled.
+	SkipInvalidGlob = "invalid-glob"
+	// SkipMinifierFailure means no minifier could minify the file.
+	SkipMinifierFailure = "minifier-failure"
+)
+
+// Manifest describes the output of processing a version.
+// Apart from the timings, it only depends on the version and its
+// configuration, see WithoutTimings.
+type Manifest struct {
+	Files      []File    `json:"files"`
+	Skipped    []Skipped `json:"skipped,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+
+	// Rejected is set when the tarball of the version is rejected,
+	// nothing is published then.
+	Rejected *Rejection `json:"rejected,omitempty"`
+}
+
+// Rejection is why the tarball of a version is rejected.
+type Rejection struct {
+	Reason string `json:"reason"`
+	Entry  string `json:"entry,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// File is a published file.
+type File struct {
+	// Name is the path of the file in the version.
+	Name string `json:"name"`
+	// Size is the size of the uncompressed file, in bytes.
+	Size int64 `json:"size"`
+	// Encoded lists the precompressed forms in which the file is
+	// published. It is empty if the file is published as is.
+	Encoded   []Encoded      `json:"encoded,omitempty"`
+	Integrity *sri.Integrity `json:"integrity"`
+
+	// MinifiedFrom is the name of the file that was minified,
+	// for minified files and their source maps.
+	MinifiedFrom string `json:"minifiedFrom,omitempty"`
+	// Minifier is the minifier that produced the file.
+	Minifier string `json:"minifier,omitempty"`
+	// DerivedFrom is the name of the image that was converted,
+	// for image derivatives.
+	DerivedFrom string `json:"derivedFrom,omitempty"`
+
+	// DurationMs is the time spent processing the file.
+	DurationMs int64 `json:"durationMs,omitempty"`
+}
+
+// Encoded is a precompressed form of a published file.
+type Encoded struct {
+	// Encoding is the name of the encoding, for instance `br`.
+	Encoding string `json:"encoding"`
+	// Name is the path of the precompressed file in the output.
+	Name string `json:"name"`
+	// Size is the size of the precompressed file, in bytes.
+	Size int64 `json:"size"`
+}
+
+// Entries lists the paths in the output of the published file.
+func (f *File) Entries() []string {
+	if len(f.Encoded) == 0 {
+		return []string{f.Name}
+	}
+	entries := make([]string, len(f.Encoded))
+	for i, e := range f.Encoded {
+		entries[i] = e.Name
+	}
+	return entries
+}
+
+// Entry is a path in the output of process-version.
+type Entry struct {
+	File *File
+	// Encoding is the name of the encoding of the entry,
+	// empty if the file is published as is.
+	Encoding string
+}
+
+// Skipped is a file, or a fileMap pattern, that is not published.
+type Skipped struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Read reads and parses a manifest.
+func Read(file string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read manifest")
+	}
+	return Parse(data)
+}
+
+// Parse parses a manifest in its JSON form.
+func Parse(data []byte) (*Manifest, error) {
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "could not parse manifest")
+	}
+	return m, nil
+}
+
+// Marshal marshals the manifest into its JSON form.
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal manifest")
+	}
+	return data, nil
+}
+
+// Write writes the manifest to disk.
+func (m *Manifest) Write(file string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// WithoutTimings returns a copy of the manifest without the timings, which
+// only depends on the version and its configuration, for instance to be
+// archived with the files.
+func (m *Manifest) WithoutTimings() *Manifest {
+	stripped := *m
+	stripped.DurationMs = 0
+	stripped.Files = make([]File, len(m.Files))
+	for i, f := range m.Files {
+		f.DurationMs = 0
+		stripped.Files[i] = f
+	}
+	return &stripped
+}
+
+// Names lists the names of the published files.
+func (m *Manifest) Names() []string {
+	names := make([]string, len(m.Files))
+	for i, f := range m.Files {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Get gets a published file by name.
+func (m *Manifest) Get(name string) (*File, bool) {
+	i := sort.Search(len(m.Files), func(i int) bool {
+		return m.Files[i].Name >= name
+	})
+	if i < len(m.Files) && m.Files[i].Name == name {
+		return &m.Files[i], true
+	}
+	return nil, false
+}
+
+// Entries maps the paths in the output to the published files.
+func (m *Manifest) Entries() map[string]Entry {
+	entries := make(map[string]Entry)
+	for i := range m.Files {
+		f := &m.Files[i]
+		if len(f.Encoded) == 0 {
+			entries[f.Name] = Entry{File: f}
+		}
+		for _, e := range f.Encoded {
+			entries[e.Name] = Entry{File: f, Encoding: e.Encoding}
+		}
+	}
+	return entries
+}
+
+// Summary describes the manifest for humans, for instance in audit logs.
+func (m *Manifest) Summary() string {
+	var b strings.Builder
+	if r := m.Rejected; r != nil {
+		fmt.Fprintf(&b, "tarball rejected: %s", r.Reason)
+		if r.Entry != "" {
+			fmt.Fprintf(&b, " (%s)", r.Entry)
+		}
+		if r.Detail != "" {
+			fmt.Fprintf(&b, ": %s", r.Detail)
+		}
+		fmt.Fprint(&b, "\n")
+	}
+	fmt.Fprintf(&b, "%d file(s) published in %dms\n", len(m.Files), m.DurationMs)
+	for _, f := range m.Files {
+		fmt.Fprintf(&b, "- %s (%d bytes in %dms", f.Name, f.Size, f.DurationMs)
+		if f.MinifiedFrom != "" {
+			fmt.Fprintf(&b, ", minified from %s with %s", f.MinifiedFrom, f.Minifier)
+		}
+		if f.DerivedFrom != "" {
+			fmt.Fprintf(&b, ", derived from %s", f.DerivedFrom)
+		}
+		fmt.Fprint(&b, ")\n")
+	}
+	if len(m.Skipped) > 0 {
+		fmt.Fprintf(&b, "%d skipped:\n", len(m.Skipped))
+		for _, s := range m.Skipped {
+			fmt.Fprintf(&b, "- %s: %s", s.Name, s.Reason)
+			if s.Detail != "" {
+				fmt.Fprintf(&b, " (%s)", s.Detail)
+			}
+			fmt.Fprint(&b, "\n")
+		}
+	}
+	return b.String()
+}
+
+// Recorder builds a manifest while a version is processed.
+// It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	started time.Time
+	m       Manifest
+}
+
+// NewRecorder creates a recorder, the processing starts now.
+func NewRecorder() *Recorder {
+	return &Recorder{started: time.Now()}
+}
+
+// AddFile records a published file.
+func (r *Recorder) AddFile(f File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m.Files = append(r.m.Files, f)
+}
+
+// Skip records a file that is not published.
+func (r *Recorder) Skip(s Skipped) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m.Skipped = append(r.m.Skipped, s)
+}
+
+// Finish returns the manifest, with its entries sorted by name.
+func (r *Recorder) Finish() *Manifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.m
+	m.Files = append([]File(nil), r.m.Files...)
+	m.Skipped = append([]Skipped(nil), r.m.Skipped...)
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Name < m.Files[j].Name
+	})
+	sort.SliceStable(m.Skipped, func(i, j int) bool {
+		return m.Skipped[i].Name < m.Skipped[j].Name
+	})
+	if m.Files == nil {
+		m.Files = []File{}
+	}
+	m.DurationMs = Since(r.started)
+	return &m
+}
+
+// Since returns the milliseconds elapsed since t.
+func Since(t time.Time) int64 {
+	return int64(time.Since(t) / time.Millisecond)
+}
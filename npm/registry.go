@@ -1,24 +1,100 @@
 package npm
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/sri"
 	"github.com/cdnjs/tools/util"
 	"github.com/cdnjs/tools/version"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// NPM_REGISTRY is the base URL of the registry, defaults to registry.npmjs.org.
+	NPM_REGISTRY = os.Getenv("NPM_REGISTRY")
+)
+
+const (
+	// DefaultTimeout is the default timeout of the requests to the registry.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultCacheSize is the default size of the cached metadata, in bytes.
+	DefaultCacheSize = 64 << 20
+
+	// media types of the full and abbreviated metadata of packages
+	fullMetadata        = "application/json"
+	abbreviatedMetadata = "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8, */*"
 )
 
-// Registry contains metadata about a particular npm package.
-type Registry struct {
-	Versions   map[string]interface{} `json:"versions"`  // Versions contains metadata about each npm version.
-	TimeStamps map[string]interface{} `json:"time"`      // TimeStamps contains times for each versions as well as the created/modified time.
-	DistTags   map[string]string      `json:"dist-tags"` // DistTags map dist tags to string versions
+// DefaultClient is the client used by the functions of the package.
+var DefaultClient = NewClient()
+
+// Packument contains the metadata of an npm package.
+type Packument struct {
+	Name     string                     `json:"name"`
+	DistTags map[string]string          `json:"dist-tags"` // DistTags map dist tags to string versions
+	Versions map[string]VersionMetadata `json:"versions"`  // Versions contains metadata about each npm version.
+	Time     Times                      `json:"time"`      // Time is missing from the abbreviated metadata.
+}
+
+// VersionMetadata contains the metadata of a version of an npm package.
+type VersionMetadata struct {
+	Version string `json:"version"`
+	Dist    Dist   `json:"dist"`
+}
+
+// Dist describes the tarball of a version.
+type Dist struct {
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"` // subresource integrity, missing for old versions
+	Shasum    string `json:"shasum"`    // hex SHA-1
+}
+
+// SRI returns the Subresource Integrity of the tarball, computed from the
+// SHA-1 shasum for the old versions without integrity.
+func (d Dist) SRI() (string, error) {
+	if d.Integrity != "" || d.Shasum == "" {
+		return d.Integrity, nil
+	}
+	return sri.FromHex("sha1", d.Shasum)
+}
+
+// Times contains times for each versions as well as the created/modified time.
+type Times map[string]time.Time
+
+// UnmarshalJSON ignores the entries which aren't times, such as the
+// description of unpublished versions.
+func (t *Times) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*t = make(Times)
+	for key, value := range raw {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return errors.Wrapf(err, "invalid time of %s", key)
+		}
+		(*t)[key] = parsed
+	}
+	return nil
 }
 
 // MonthlyDownload holds the number of monthly downloads
@@ -27,74 +103,239 @@ type MonthlyDownload struct {
 	Downloads uint `json:"downloads"`
 }
 
-// Exists determines if an npm package exists.
-func Exists(name string) bool {
-	resp, err := http.Get(util.GetProtocol() + "://registry.npmjs.org/" + name)
-	util.Check(err)
-	return resp.StatusCode == http.StatusOK
+// NotFoundError is returned when a package isn't in the registry.
+type NotFoundError struct {
+	Package string
 }
 
-// GetMonthlyDownload uses the npm API to get the MonthlyDownload
-// for a particular npm package.
-func GetMonthlyDownload(name string) MonthlyDownload {
-	resp, err := http.Get(util.GetProtocol() + "://api.npmjs.org/downloads/point/last-month/" + name)
-	util.Check(err)
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("npm package %s not found", e.Package)
+}
+
+// RateLimitError is returned when the registry rate limits the requests.
+type RateLimitError struct {
+	RetryAfter time.Duration // zero if unknown
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("npm registry rate limited, retry after %s", e.RetryAfter)
+}
+
+// MalformedError is returned when the metadata of a package can't be parsed.
+type MalformedError struct {
+	Package string
+	Err     error
+}
+
+func (e MalformedError) Error() string {
+	return fmt.Sprintf("malformed metadata for npm package %s: %s", e.Package, e.Err)
+}
+
+// Client is a client of the npm registry, which caches the metadata of
+// the packages and revalidates it with their ETag. The least recently used
+// metadata is evicted when the cache is full.
+type Client struct {
+	Registry  string // base URL of the registry
+	HTTP      *http.Client
+	CacheSize int // maximum size of the cached bodies, in bytes
+
+	mu        sync.Mutex
+	cache     map[string]*list.Element // by URL and media type
+	lru       *list.List               // of *cached, most recently used first
+	cacheUsed int
+}
 
+type cached struct {
+	key  string
+	etag string
+	body []byte
+}
+
+// NewClient creates a client of the NPM_REGISTRY registry.
+func NewClient() *Client {
+	registry := NPM_REGISTRY
+	if registry == "" {
+		registry = util.GetProtocol() + "://registry.npmjs.org"
+	}
+	return &Client{
+		Registry:  strings.TrimSuffix(registry, "/"),
+		HTTP:      &http.Client{Timeout: DefaultTimeout},
+		CacheSize: DefaultCacheSize,
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// Gets a cached document, and marks it as recently used.
+func (c *Client) cached(key string) (cached, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.cache[key]
+	if !ok {
+		return cached{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return *elem.Value.(*cached), true
+}
+
+// Caches a document, evicting the least recently used ones to make room.
+// The documents larger than the cache aren't cached.
+func (c *Client) store(entry *cached) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.cache[entry.key]; ok {
+		c.cacheUsed -= len(elem.Value.(*cached).body)
+		c.lru.Remove(elem)
+		delete(c.cache, entry.key)
+	}
+	if len(entry.body) > c.CacheSize {
+		return
+	}
+	for c.cacheUsed+len(entry.body) > c.CacheSize {
+		oldest := c.lru.Remove(c.lru.Back()).(*cached)
+		c.cacheUsed -= len(oldest.body)
+		delete(c.cache, oldest.key)
+	}
+	c.cache[entry.key] = c.lru.PushFront(entry)
+	c.cacheUsed += len(entry.body)
+}
+
+// Packument gets the metadata of a package, abbreviated if possible. The
+// abbreviated metadata doesn't have the times of the versions.
+func (c *Client) Packument(ctx context.Context, name string, abbreviated bool) (*Packument, error) {
+	accept := fullMetadata
+	if abbreviated {
+		accept = abbreviatedMetadata
+	}
+	// the / of scoped packages is escaped
+	body, err := c.get(ctx, c.Registry+"/"+url.PathEscape(name), accept, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Packument
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, MalformedError{Package: name, Err: err}
+	}
+	return &p, nil
+}
+
+// Gets a document, from the cache if it didn't change.
+func (c *Client) get(ctx context.Context, target, accept, name string) ([]byte, error) {
+	key := accept + " " + target
+	entry, hasEntry := c.cached(key)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Accept", accept)
+	if hasEntry {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get %s", target)
+	}
 	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && hasEntry:
+		return entry.body, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, NotFoundError{Package: name}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, RateLimitError{RetryAfter: util.RetryAfter(resp)}
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("%s returned %s", target, resp.Status)
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
-	util.Check(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", target)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.store(&cached{key: key, etag: etag, body: body})
+	}
+	return body, nil
+}
 
-	var counts MonthlyDownload
-	util.Check(json.Unmarshal(body, &counts))
-	return counts
+// Exists determines if an npm package exists.
+func (c *Client) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := c.Packument(ctx, name, true)
+	if _, ok := err.(NotFoundError); ok {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 // GetVersions gets all of the versions associated with an npm package,
 // as well as the latest version based on the `latest` tag.
-func GetVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, *string) {
+func (c *Client) GetVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, *string, error) {
 	name := *config.Target
-	resp, err := http.Get(util.GetProtocol() + "://registry.npmjs.org/" + name)
-	util.Check(err)
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	util.Check(err)
-
-	var r Registry
-	util.Check(json.Unmarshal(body, &r))
+	p, err := c.Packument(ctx, name, false)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	versions := make([]version.Version, 0)
-	for k, v := range r.Versions {
-		if v, ok := v.(map[string]interface{}); ok {
-			dist := v["dist"].(map[string]interface{})
-			tarball := dist["tarball"].(string)
-
-			if timeInt, ok := r.TimeStamps[k]; ok {
-				if timeStr, ok := timeInt.(string); ok {
-					// parse time.Time from time stamp
-					timeStamp, err := time.Parse(time.RFC3339, timeStr)
-					util.Check(err)
-
-					if !version.IsVersionIgnored(config, k) {
-						versions = append(versions, version.Version{
-							Version: k,
-							Tarball: tarball,
-							Date:    timeStamp,
-							Source:  "npm",
-						})
-					} else {
-						log.Printf("%s: version %s is ignored\n", name, k)
-					}
-					continue
-				}
+	for k, v := range p.Versions {
+		if v.Dist.Tarball == "" {
+			log.Printf("%s: version %s has no tarball, ignoring\n", name, k)
+			continue
+		}
+		timeStamp, ok := p.Time[k]
+		if !ok {
+			log.Printf("%s: version %s has no time stamp, ignoring\n", name, k)
+			continue
+		}
+
+		if !version.IsVersionIgnored(config, k) {
+			integrity, err := v.Dist.SRI()
+			if err != nil {
+				return nil, nil, MalformedError{Package: name, Err: err}
 			}
-			panic(fmt.Errorf("no time stamp for npm version %s/%s", name, k))
+			versions = append(versions, version.Version{
+				Version:   k,
+				Tarball:   v.Dist.Tarball,
+				Date:      timeStamp,
+				Source:    "npm",
+				Integrity: integrity,
+			})
+		} else {
+			log.Printf("%s: version %s is ignored\n", name, k)
 		}
 	}
 
 	// attempt to get latest version according to npm
-	if latest, ok := r.DistTags["latest"]; ok {
-		return versions, &latest
+	if latest, ok := p.DistTags["latest"]; ok {
+		return versions, &latest, nil
 	}
-	return versions, nil
+	return versions, nil, nil
+}
+
+// Exists determines if an npm package exists, with the DefaultClient.
+func Exists(ctx context.Context, name string) (bool, error) {
+	return DefaultClient.Exists(ctx, name)
+}
+
+// GetVersions gets the versions of an npm package with the DefaultClient.
+func GetVersions(ctx context.Context, config *packages.Autoupdate) ([]version.Version, *string, error) {
+	return DefaultClient.GetVersions(ctx, config)
+}
+
+// GetMonthlyDownload uses the npm API to get the MonthlyDownload
+// for a particular npm package.
+func GetMonthlyDownload(name string) MonthlyDownload {
+	resp, err := http.Get(util.GetProtocol() + "://api.npmjs.org/downloads/point/last-month/" + name)
+	util.Check(err)
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	util.Check(err)
+
+	var counts MonthlyDownload
+	util.Check(json.Unmarshal(body, &counts))
+	return counts
 }
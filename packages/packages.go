@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
 
+	"github.com/cdnjs/tools/manifest"
 	"github.com/cdnjs/tools/util"
 
 	"github.com/blang/semver"
+	"github.com/pkg/errors"
 )
 
 // Author represents an author.
@@ -35,6 +38,20 @@ type Optimization struct {
 	CSS *bool `json:"css,omitempty"`
 	PNG *bool `json:"png,omitempty"`
 	JPG *bool `json:"jpg,omitempty"`
+	SVG *bool `json:"svg,omitempty"`
+
+	Gzip   *GzipOptimization   `json:"gzip,omitempty"`
+	Images *ImagesOptimization `json:"images,omitempty"`
+}
+
+// GzipOptimization configures the gzip compression of published files.
+type GzipOptimization struct {
+	Iterations *int `json:"iterations,omitempty"`
+}
+
+// ImagesOptimization configures the processing of PNG and JPEG files.
+type ImagesOptimization struct {
+	Derivatives []string `json:"derivatives,omitempty"`
 }
 
 // Js returns if we should optimize JavaScript files.
@@ -57,6 +74,29 @@ func (o *Optimization) Jpg() bool {
 	return o == nil || o.JPG == nil || *o.JPG
 }
 
+// Svg returns if we should optimize SVG files.
+func (o *Optimization) Svg() bool {
+	return o == nil || o.SVG == nil || *o.SVG
+}
+
+// GzipIterations returns the number of zopfli iterations to use when
+// gzip compressing files, or 0 to use the default.
+func (o *Optimization) GzipIterations() int {
+	if o == nil || o.Gzip == nil || o.Gzip.Iterations == nil {
+		return 0
+	}
+	return *o.Gzip.Iterations
+}
+
+// ImageDerivatives returns the alternative formats to generate for PNG
+// and JPEG files, for instance `webp`. By default, none are generated.
+func (o *Optimization) ImageDerivatives() []string {
+	if o == nil || o.Images == nil {
+		return nil
+	}
+	return o.Images.Derivatives
+}
+
 // FileMap represents a number of files located
 // under a base path.
 type FileMap struct {
@@ -147,10 +187,30 @@ func (p *Package) UpdateVersion(name string, newAsset Asset) {
 	}
 }
 
+// RemoveVersion removes the asset of a version, and returns whether it
+// was found.
+func (p *Package) RemoveVersion(name string) bool {
+	for i, asset := range p.Assets {
+		if asset.Version == name {
+			p.Assets = append(p.Assets[:i], p.Assets[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // NpmFilesFrom lists files that match the npm glob pattern in the `base` directory
 // Returns a struct that represent the move semantics
-func (p *Package) NpmFilesFrom(base string) []NpmFileMoveOp {
+func (p *Package) NpmFilesFrom(base string) ([]NpmFileMoveOp, error) {
+	out, _, err := p.MatchFiles(base)
+	return out, err
+}
+
+// MatchFiles is NpmFilesFrom but also returns the patterns matching no
+// file and the files that are ignored, with the reason why.
+func (p *Package) MatchFiles(base string) ([]NpmFileMoveOp, []manifest.Skipped, error) {
 	out := make([]NpmFileMoveOp, 0)
+	skipped := make([]manifest.Skipped, 0)
 
 	// map used to determine if a file path has already been processed
 	seen := make(map[string]bool)
@@ -159,9 +219,30 @@ func (p *Package) NpmFilesFrom(base string) []NpmFileMoveOp {
 		for _, pattern := range fileMap.Files {
 			basePath := path.Join(base, *fileMap.BasePath)
 
+			// an invalid pattern only skips its files
+			if _, err := util.CompileGlob(pattern); err != nil {
+				skipped = append(skipped, manifest.Skipped{
+					Name:   pattern,
+					Reason: manifest.SkipInvalidGlob,
+					Detail: err.Error(),
+				})
+				continue
+			}
+
 			// find files that match glob
 			list, err := util.ListFilesGlob(p.ctx, basePath, pattern)
-			util.Check(err) // should have already run before in checker so panic if glob invalid
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "could not match %s", pattern)
+			}
+
+			if len(list) == 0 {
+				skipped = append(skipped, manifest.Skipped{
+					Name:   pattern,
+					Reason: manifest.SkipGlobMiss,
+					Detail: "no file matched in " + *fileMap.BasePath,
+				})
+				continue
+			}
 
 			for _, f := range list {
 				fp := path.Join(basePath, f)
@@ -182,6 +263,11 @@ func (p *Package) NpmFilesFrom(base string) []NpmFileMoveOp {
 				size := info.Size()
 				if size > util.MaxFileSize {
 					util.Warnf(p.ctx, "file %s ignored due to byte size (%d > %d)", f, size, util.MaxFileSize)
+					skipped = append(skipped, manifest.Skipped{
+						Name:   f,
+						Reason: manifest.SkipOversized,
+						Detail: fmt.Sprintf("%d > %d bytes", size, util.MaxFileSize),
+					})
 					continue
 				}
 
@@ -194,7 +280,7 @@ func (p *Package) NpmFilesFrom(base string) []NpmFileMoveOp {
 		}
 	}
 
-	return out
+	return out, skipped, nil
 }
 
 // // AllFiles lists all files in the version directory.
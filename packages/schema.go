@@ -138,9 +138,10 @@ const humanReadableProperties = `
                 },
                 "source": {
                     "type": "string",
-                    "pattern": "^git|npm$"
+                    "pattern": "^(git|npm)$"
                 },
                 "target": {
+                    "description": "Name of the package on npm, or URL of the git remote, which can be hosted anywhere.",
                     "type": "string",
                     "minLength": 1
                 }
@@ -167,6 +168,39 @@ const humanReadableProperties = `
                 },
                 "jpg": {
                     "type": "boolean"
+                },
+                "svg": {
+                    "type": "boolean"
+                },
+                "gzip": {
+                    "description": "Configures the gzip compression of published files.",
+                    "type": "object",
+                    "properties": {
+                        "iterations": {
+                            "description": "The number of zopfli iterations, more iterations produce slightly smaller files but take longer. Defaults to 15.",
+                            "type": "integer",
+                            "minimum": 1,
+                            "maximum": 1000
+                        }
+                    },
+                    "additionalProperties": false
+                },
+                "images": {
+                    "description": "Configures the processing of PNG and JPEG files.",
+                    "type": "object",
+                    "properties": {
+                        "derivatives": {
+                            "description": "Alternative formats to generate for PNG and JPEG files. Each one is published next to the original file with the format's extension appended, for instance logo.png.webp.",
+                            "type": "array",
+                            "minItems": 1,
+                            "uniqueItems": true,
+                            "items": {
+                                "type": "string",
+                                "enum": ["webp", "avif"]
+                            }
+                        }
+                    },
+                    "additionalProperties": false
                 }
             },
             "additionalProperties": false
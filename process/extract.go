@@ -0,0 +1,296 @@
+package process
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cdnjs/tools/archive"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxFiles is the default maximum number of files in a tarball.
+	DefaultMaxFiles = 50000
+	// DefaultMaxSize is the default maximum size of the extracted files.
+	DefaultMaxSize = 1 << 30
+
+	// maximum number of links followed to find the target of a link
+	maxLinkHops = 8
+)
+
+// Reasons for which a tarball is rejected.
+const (
+	// ReasonInvalidArchive means the tarball can't be read.
+	ReasonInvalidArchive = "invalid-archive"
+	// ReasonUnsafePath means an entry is absolute or outside of the version.
+	ReasonUnsafePath = "unsafe-path"
+	// ReasonTooManyFiles means the tarball exceeds the file count budget.
+	ReasonTooManyFiles = "too-many-files"
+	// ReasonTooLarge means the extracted files exceed the size budget.
+	ReasonTooLarge = "too-large"
+)
+
+// ExtractError is returned when the archive of a version is rejected.
+type ExtractError struct {
+	Reason string // one of the Reason constants
+	Entry  string // name of the offending entry, if any
+	Err    error  // underlying error, if any
+}
+
+func (e ExtractError) Error() string {
+	msg := "tarball rejected: " + e.Reason
+	if e.Entry != "" {
+		msg += fmt.Sprintf(" (%s)", e.Entry)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// ExtractOptions limits the extraction of a tarball.
+type ExtractOptions struct {
+	// MaxFiles is the maximum number of files, DefaultMaxFiles if zero.
+	MaxFiles int
+	// MaxSize is the maximum size of the files in bytes, DefaultMaxSize if zero.
+	MaxSize int64
+	// Links preserves the symbolic and hard links to files of the
+	// version as copies of the files. They are ignored otherwise.
+	Links bool
+}
+
+func removePackageDir(path string) string {
+	if len(path) < 8 {
+		return path
+	}
+	if path[0:8] == "package/" {
+		return path[8:]
+	}
+	return path
+}
+
+func removeFirstDir(path string) string {
+	parts := strings.Split(path, "/")
+	return strings.Replace(path, parts[0]+"/", "", 1)
+}
+
+// Returns the path of an entry in the version, removing the directory
+// wrapping the files of the source.
+func entryPath(name, source string) string {
+	switch source {
+	case "npm":
+		// remove package folder
+		return removePackageDir(name)
+	case "git":
+		// remove package folder
+		return removeFirstDir(name)
+	}
+	return name
+}
+
+// Returns the clean form of a path in the version, and false if the path
+// is absolute or goes up the tree.
+func safePath(name string) (string, bool) {
+	if path.IsAbs(name) || strings.Contains(name, "\\") {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return path.Clean(name), true
+}
+
+type extractor struct {
+	dir    string
+	source string
+	opts   ExtractOptions
+
+	files int
+	size  int64
+	links map[string]*tar.Header // links by path in the version
+}
+
+// Extracts the archive of a version into dir, in any of the formats
+// supported by the archive package.
+func extract(tarball io.Reader, dir, source string, opts ExtractOptions) error {
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = DefaultMaxFiles
+	}
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	e := &extractor{
+		dir:    dir,
+		source: source,
+		opts:   opts,
+		links:  make(map[string]*tar.Header),
+	}
+
+	tarReader, err := archive.NewReaderSize(tarball, opts.MaxSize)
+	if _, ok := err.(archive.TooLargeError); ok {
+		return ExtractError{Reason: ReasonTooLarge, Err: err}
+	}
+	if err != nil {
+		return ExtractError{Reason: ReasonInvalidArchive, Err: err}
+	}
+	defer tarReader.Close()
+	log.Printf("ExtractTarGz: %s archive\n", tarReader.Format)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ExtractError{Reason: ReasonInvalidArchive, Err: err}
+		}
+
+		target, ok := safePath(entryPath(header.Name, source))
+		if !ok {
+			return ExtractError{Reason: ReasonUnsafePath, Entry: header.Name}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			// ignore dirs and the comments of git archives
+		case tar.TypeReg, tar.TypeRegA:
+			if err := e.extractFile(target, header.Name, tarReader); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			if opts.Links {
+				e.links[target] = header
+			} else {
+				log.Printf("ExtractTarGz: ignoring link %s -> %s\n", header.Name, header.Linkname)
+			}
+		default:
+			log.Printf(
+				"ExtractTarGz: uknown type: %x in %s\n",
+				header.Typeflag,
+				header.Name)
+		}
+	}
+
+	if err := tarReader.Close(); err != nil {
+		return ExtractError{Reason: ReasonInvalidArchive, Err: err}
+	}
+	return e.copyLinks()
+}
+
+// Counts a file against the budgets.
+func (e *extractor) count(entry string) error {
+	e.files++
+	if e.files > e.opts.MaxFiles {
+		return ExtractError{
+			Reason: ReasonTooManyFiles,
+			Entry:  entry,
+			Err:    errors.Errorf("more than %d files", e.opts.MaxFiles),
+		}
+	}
+	return nil
+}
+
+// Writes a file of the version, the size budget is enforced on the
+// actual content regardless of the size announced by the header.
+func (e *extractor) extractFile(target, entry string, r io.Reader) error {
+	if err := e.count(entry); err != nil {
+		return err
+	}
+
+	dest := path.Join(e.dir, target)
+	if err := os.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return errors.Wrap(err, "ExtractTarGz: Mkdir() failed")
+	}
+	// an entry can replace a previous one, but not write through
+	// a directory or a link
+	os.Remove(dest)
+	outFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "ExtractTarGz: Create() failed")
+	}
+	defer outFile.Close()
+
+	remaining := e.opts.MaxSize - e.size
+	n, err := io.CopyN(outFile, r, remaining+1)
+	e.size += n
+	// the archive reader enforces the same budget
+	if _, ok := err.(archive.TooLargeError); ok || n > remaining {
+		return ExtractError{
+			Reason: ReasonTooLarge,
+			Entry:  entry,
+			Err:    errors.Errorf("more than %d bytes", e.opts.MaxSize),
+		}
+	}
+	if err != nil && err != io.EOF {
+		return ExtractError{Reason: ReasonInvalidArchive, Entry: entry, Err: err}
+	}
+	return outFile.Close()
+}
+
+// Copies the files targeted by the links, the links outside of the
+// version or to directories are ignored.
+func (e *extractor) copyLinks() error {
+	targets := make([]string, 0, len(e.links))
+	for target := range e.links {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		header := e.links[target]
+		file, ok := e.resolve(target)
+		if !ok {
+			log.Printf("ExtractTarGz: ignoring link %s -> %s: not a file of the version\n", header.Name, header.Linkname)
+			continue
+		}
+
+		src, err := os.Open(path.Join(e.dir, file))
+		if err != nil {
+			return errors.Wrap(err, "ExtractTarGz: Open() failed")
+		}
+		err = e.extractFile(target, header.Name, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("ExtractTarGz: copied %s -> %s\n", file, target)
+	}
+	return nil
+}
+
+// Follows a link to the regular file it targets in the version.
+func (e *extractor) resolve(name string) (string, bool) {
+	for hop := 0; hop < maxLinkHops; hop++ {
+		header, ok := e.links[name]
+		if !ok {
+			info, err := os.Lstat(path.Join(e.dir, name))
+			return name, err == nil && info.Mode().IsRegular()
+		}
+
+		var target string
+		if header.Typeflag == tar.TypeLink {
+			// hard links are relative to the root of the tarball
+			target = entryPath(header.Linkname, e.source)
+		} else {
+			if path.IsAbs(header.Linkname) {
+				return "", false
+			}
+			target = path.Join(path.Dir(name), header.Linkname)
+		}
+		target, ok = safePath(target)
+		if !ok || target == "." {
+			return "", false
+		}
+		name = target
+	}
+	return "", false
+}
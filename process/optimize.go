@@ -0,0 +1,316 @@
+package process
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cdnjs/tools/compress"
+	"github.com/cdnjs/tools/manifest"
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/pkg/errors"
+)
+
+type optimizeJob struct {
+	p       *processor
+	File    string
+	Dest    string
+	Started time.Time // when the processing of File started
+
+	// relationship of Dest with the original file, see manifest.File
+	MinifiedFrom string
+	Minifier     string
+	DerivedFrom  string
+}
+
+func (j optimizeJob) run() error {
+	j.Started = time.Now()
+	optimization := j.p.config.Optimization
+	intputFile := path.Join(j.p.src, j.File)
+	ext := path.Ext(j.File)
+	switch ext {
+	case ".jpg", ".jpeg":
+		if optimization.Jpg() {
+			compress.Jpeg(j.p.ctx, intputFile)
+		}
+		if err := j.emitImageDerivatives(intputFile); err != nil {
+			return err
+		}
+	case ".png":
+		if optimization.Png() {
+			compress.Png(j.p.ctx, intputFile)
+		}
+		if err := j.emitImageDerivatives(intputFile); err != nil {
+			return err
+		}
+	case ".svg":
+		if optimization.Svg() {
+			if err := compress.Svg(j.p.ctx, intputFile); err != nil {
+				// the original file is published as is
+				log.Printf("could not optimize %s: %s\n", j.File, err)
+			}
+		}
+	case ".js":
+		if optimization.Js() {
+			res := compress.Js(j.p.ctx, intputFile, j.p.jsMinifiers)
+			if err := j.emitMinifyResult(intputFile, minifiedName(j.Dest), res); err != nil {
+				return err
+			}
+		}
+		if err := j.fixSourceMappingURL(intputFile); err != nil {
+			log.Printf("could not fix sourceMappingURL of %s: %s\n", j.File, err)
+		}
+	case ".css":
+		if optimization.Css() {
+			res := compress.CSS(j.p.ctx, intputFile, j.p.cssMinifiers)
+			if err := j.emitMinifyResult(intputFile, minifiedName(j.Dest), res); err != nil {
+				return err
+			}
+		}
+		if err := j.fixSourceMappingURL(intputFile); err != nil {
+			log.Printf("could not fix sourceMappingURL of %s: %s\n", j.File, err)
+		}
+	}
+
+	return j.emitFromWorkspace(intputFile)
+}
+
+// Publishes the src file as Dest, compressed in each encoding unless it
+// is already compressed.
+func (j optimizeJob) emitFromWorkspace(src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return errors.Wrap(err, "could not stat file")
+	}
+
+	integrity, err := sri.CalculateFileIntegrity(src)
+	if err != nil {
+		return errors.Wrap(err, "could not calculate integrity")
+	}
+	j.p.integrity.Add(j.Dest, integrity)
+	log.Printf("sri %s -> %s\n", src, integrity.SHA512)
+
+	entry := manifest.File{
+		Name:         j.Dest,
+		Size:         info.Size(),
+		Integrity:    integrity,
+		MinifiedFrom: j.MinifiedFrom,
+		Minifier:     j.Minifier,
+		DerivedFrom:  j.DerivedFrom,
+	}
+
+	ext := path.Ext(src)
+	if _, ok := doNotCompress[ext]; !ok {
+		opts := compress.EncodeOptions{
+			Gzip: compress.GzipZopfli(j.p.config.Optimization.GzipIterations()),
+		}
+		for _, enc := range j.p.encodings {
+			name := j.Dest + enc.Ext
+			out := path.Join(j.p.staging, name)
+			if err := os.MkdirAll(path.Dir(out), 0755); err != nil {
+				return errors.Wrap(err, "could not create staging dir")
+			}
+			if err := enc.Encode(j.p.ctx, src, out, opts); err != nil {
+				return errors.Wrap(err, "could not compress")
+			}
+			log.Printf("%s %s -> %s\n", enc.Name, src, name)
+
+			encoded, err := os.Stat(out)
+			if err != nil {
+				return errors.Wrap(err, "could not stat compressed file")
+			}
+			if err := j.p.writeFile(name, out); err != nil {
+				return err
+			}
+			os.Remove(out)
+
+			entry.Encoded = append(entry.Encoded, manifest.Encoded{
+				Encoding: enc.Name,
+				Name:     name,
+				Size:     encoded.Size(),
+			})
+		}
+	} else {
+		if err := j.p.writeFile(j.Dest, src); err != nil {
+			return err
+		}
+		log.Printf("copy %s -> %s\n", src, j.Dest)
+	}
+
+	entry.DurationMs = manifest.Since(j.Started)
+	j.p.manifest.AddFile(entry)
+	return nil
+}
+
+// Returns the name of the minified form of a file, for instance
+// `jquery.jsonp.min.js` for `jquery.jsonp.js`.
+func minifiedName(name string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + ".min" + ext
+}
+
+// Emits the outcome of the minification of src into the dest file.
+func (j optimizeJob) emitMinifyResult(src, dest string, res *compress.MinifyResult) error {
+	logMinifyResult(res)
+	if res.Failed {
+		j.p.manifest.Skip(manifest.Skipped{
+			Name:   dest,
+			Reason: manifest.SkipMinifierFailure,
+			Detail: res.SkipReason,
+		})
+	}
+	if res.Skipped() {
+		return nil
+	}
+
+	minJob := j
+	minJob.Dest = dest
+	minJob.MinifiedFrom = j.Dest
+	minJob.Minifier = res.Minifier
+	return minJob.emitMinified(src, res)
+}
+
+// Emits a minified file and its source map, if any.
+func (j optimizeJob) emitMinified(src string, res *compress.MinifyResult) error {
+	if err := linkSourceMap(src, j.Dest, res); err != nil {
+		// the minified file is still valid without a source map
+		log.Printf("could not link source map of %s: %s\n", res.Output, err)
+		res.SourceMap = ""
+	}
+	if err := j.emitFromWorkspace(res.Output); err != nil {
+		return err
+	}
+
+	if res.SourceMap != "" {
+		mapJob := j
+		mapJob.Dest = j.Dest + ".map"
+		return mapJob.emitFromWorkspace(res.SourceMap)
+	}
+	return nil
+}
+
+// Finalizes the source map of a minified file published as dest: the map is
+// chained onto the upstream source map of src, if there is one, and the
+// minified file is made to reference it, as it is published next to it.
+// The sourceMappingURL is stripped if there is no map.
+func linkSourceMap(src, dest string, res *compress.MinifyResult) error {
+	minified, err := ioutil.ReadFile(res.Output)
+	if err != nil {
+		return errors.Wrap(err, "could not read minified file")
+	}
+
+	if res.SourceMap == "" {
+		minified = compress.SetSourceMappingURL(res.Output, minified, "")
+		return ioutil.WriteFile(res.Output, minified, 0644)
+	}
+
+	data, err := ioutil.ReadFile(res.SourceMap)
+	if err != nil {
+		return errors.Wrap(err, "could not read source map")
+	}
+	m, err := compress.ParseSourceMap(data)
+	if err != nil {
+		return errors.Wrap(err, "could not parse source map")
+	}
+	m.File = path.Base(dest)
+
+	original, err := ioutil.ReadFile(src)
+	if err != nil {
+		return errors.Wrap(err, "could not read original file")
+	}
+	if ref := compress.FindSourceMappingURL(src, original); ref != "" {
+		upstream, upstreamFile, err := compress.LoadSourceMap(src, ref)
+		if err != nil {
+			log.Printf("ignoring upstream source map of %s: %s\n", src, err)
+		} else {
+			if upstreamFile != "" {
+				upstream.RebaseSources(path.Dir(upstreamFile), path.Dir(src))
+			}
+			chained, err := compress.ChainSourceMap(m, upstream)
+			if err != nil {
+				return errors.Wrap(err, "could not chain upstream source map")
+			}
+			log.Printf("chained %s onto upstream source map %s\n", res.SourceMap, ref)
+			m = chained
+		}
+	}
+
+	data, err = m.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "could not marshal source map")
+	}
+	if err := ioutil.WriteFile(res.SourceMap, data, 0644); err != nil {
+		return errors.Wrap(err, "could not write source map")
+	}
+
+	minified = compress.SetSourceMappingURL(res.Output, minified, path.Base(dest)+".map")
+	return ioutil.WriteFile(res.Output, minified, 0644)
+}
+
+// Strips the sourceMappingURL comment of a published file if it
+// references a local source map that will not be published.
+func (j optimizeJob) fixSourceMappingURL(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "could not read file")
+	}
+	ref := compress.FindSourceMappingURL(file, content)
+	if ref == "" || compress.IsRemoteSourceMappingURL(ref) {
+		return nil
+	}
+
+	target := ref
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		target = unescaped
+	}
+	if !path.IsAbs(target) && j.p.published[path.Join(path.Dir(j.Dest), target)] {
+		return nil
+	}
+
+	log.Printf("strip sourceMappingURL %s from %s: not published\n", ref, j.Dest)
+	return ioutil.WriteFile(file, compress.SetSourceMappingURL(file, content, ""), 0644)
+}
+
+// Generates and emits the configured alternative formats of an image,
+// unless the package already publishes a file with the same name.
+func (j optimizeJob) emitImageDerivatives(src string) error {
+	for _, name := range j.p.config.Optimization.ImageDerivatives() {
+		d, ok := compress.GetImageDerivative(name)
+		if !ok {
+			log.Printf("unknown image derivative %s\n", name)
+			continue
+		}
+
+		derivativeJob := j
+		derivativeJob.Dest = j.Dest + d.Ext
+		derivativeJob.DerivedFrom = j.Dest
+		if j.p.published[derivativeJob.Dest] {
+			log.Printf("%s is already published, not generating it\n", derivativeJob.Dest)
+			continue
+		}
+
+		out := src + d.Ext
+		if err := d.Generate(j.p.ctx, src, out); err != nil {
+			// the original image is still published
+			log.Printf("could not generate %s: %s\n", derivativeJob.Dest, err)
+			continue
+		}
+		if err := derivativeJob.emitFromWorkspace(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Logs the outcome of a minification, which ends up in the processing audit.
+func logMinifyResult(res *compress.MinifyResult) {
+	log.Printf("minify %s\n", res)
+	for _, warning := range res.Warnings {
+		log.Printf("minify %s: warning: %s\n", res.File, warning)
+	}
+}
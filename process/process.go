@@ -0,0 +1,290 @@
+// Package process optimizes the files of a version of a package: it extracts
+// the archive of the version, matches the files of the fileMap, minifies and
+// compresses them, and writes the results into a sink.
+package process
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/cdnjs/tools/compress"
+	"github.com/cdnjs/tools/manifest"
+	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// these file extensions will be uploaded to KV
+	// but not compressed
+	doNotCompress = map[string]bool{
+		".woff2": true,
+	}
+)
+
+// Sink receives the processed files.
+type Sink interface {
+	// WriteFile writes a file, name is relative to the version.
+	// It is called concurrently.
+	WriteFile(name string, r io.Reader) error
+}
+
+// DirSink is a sink writing the files into a directory.
+type DirSink string
+
+// WriteFile writes a file into the directory.
+func (d DirSink) WriteFile(name string, r io.Reader) error {
+	dest := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return errors.Wrap(err, "could not create dir")
+	}
+
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return errors.Wrap(err, "could not open dest file")
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, r); err != nil {
+		return errors.Wrap(err, "could not copy")
+	}
+	if err := destFile.Sync(); err != nil {
+		return errors.Wrap(err, "could not sync")
+	}
+	return nil
+}
+
+// Options configures the processing.
+type Options struct {
+	// Workspace is the scratch directory, a temporary directory
+	// is used and removed if empty.
+	Workspace string
+	// Encodings in which compressible files are published,
+	// defaults to compress.DefaultEncodings.
+	Encodings []*compress.Encoding
+	// JsMinifiers and CSSMinifiers are tried in order until one of them
+	// succeeds, they default to compress.DefaultJsMinifiers and
+	// compress.DefaultCSSMinifiers.
+	JsMinifiers  []compress.Minifier
+	CSSMinifiers []compress.Minifier
+	// Extract limits the extraction of the tarball.
+	Extract ExtractOptions
+}
+
+// Version processes a version from its tarball, or any archive supported by
+// the archive package, writes the published files, their integrity and the
+// manifest into the sink, and returns the manifest.
+// If the tarball is rejected, an ExtractError is returned and the manifest
+// written into the sink only records the rejection.
+func Version(ctx context.Context, tarball io.Reader, config *packages.Package, sink Sink, opts Options) (*manifest.Manifest, error) {
+	// the source tells how the files are laid out in the tarball
+	if config.Autoupdate == nil || config.Autoupdate.Source == nil {
+		return nil, errors.New("package has no autoupdate source")
+	}
+
+	workspace := opts.Workspace
+	if workspace == "" {
+		dir, err := ioutil.TempDir("", "work")
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create workspace")
+		}
+		defer os.RemoveAll(dir)
+		workspace = dir
+	}
+
+	p := &processor{
+		ctx:          ctx,
+		config:       config,
+		sink:         sink,
+		encodings:    opts.Encodings,
+		jsMinifiers:  opts.JsMinifiers,
+		cssMinifiers: opts.CSSMinifiers,
+		src:          path.Join(workspace, "src"),
+		staging:      path.Join(workspace, "staging"),
+		integrity:    sri.NewManifest(),
+		manifest:     manifest.NewRecorder(),
+		published:    make(map[string]bool),
+	}
+	if p.encodings == nil {
+		p.encodings = compress.DefaultEncodings
+	}
+	if p.jsMinifiers == nil {
+		p.jsMinifiers = compress.DefaultJsMinifiers
+	}
+	if p.cssMinifiers == nil {
+		p.cssMinifiers = compress.DefaultCSSMinifiers
+	}
+	for _, dir := range []string{p.src, p.staging} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, errors.Wrap(err, "could not create workspace")
+		}
+	}
+
+	if err := extract(tarball, p.src, *config.Autoupdate.Source, opts.Extract); err != nil {
+		if extractErr, ok := err.(ExtractError); ok {
+			return nil, p.reject(extractErr)
+		}
+		return nil, errors.Wrap(err, "failed to extract input")
+	}
+	if err := p.optimize(); err != nil {
+		return nil, errors.Wrap(err, "failed to optimize files")
+	}
+
+	if len(p.published) > 0 {
+		file := path.Join(p.staging, sri.ManifestFile)
+		if err := p.integrity.WriteFile(file); err != nil {
+			return nil, errors.Wrap(err, "could not write integrity manifest")
+		}
+		if err := p.writeFile(sri.ManifestFile, file); err != nil {
+			return nil, err
+		}
+		log.Printf("integrity manifest -> %s\n", sri.ManifestFile)
+	}
+
+	m := p.manifest.Finish()
+	file := path.Join(p.staging, manifest.Name)
+	if err := m.Write(file); err != nil {
+		return nil, errors.Wrap(err, "could not write manifest")
+	}
+	if err := p.writeFile(manifest.Name, file); err != nil {
+		return nil, err
+	}
+	log.Printf("manifest -> %s\n", manifest.Name)
+	log.Printf("%d file(s) published in %dms\n", len(m.Files), m.DurationMs)
+	return m, nil
+}
+
+// Writes a manifest recording the rejection of the tarball,
+// and returns the rejection.
+func (p *processor) reject(extractErr ExtractError) error {
+	m := p.manifest.Finish()
+	m.Rejected = &manifest.Rejection{
+		Reason: extractErr.Reason,
+		Entry:  extractErr.Entry,
+	}
+	if extractErr.Err != nil {
+		m.Rejected.Detail = extractErr.Err.Error()
+	}
+
+	file := path.Join(p.staging, manifest.Name)
+	if err := m.Write(file); err != nil {
+		return errors.Wrap(err, "could not write manifest")
+	}
+	if err := p.writeFile(manifest.Name, file); err != nil {
+		return err
+	}
+	return extractErr
+}
+
+type processor struct {
+	ctx       context.Context
+	config    *packages.Package
+	sink      Sink
+	encodings []*compress.Encoding
+
+	jsMinifiers  []compress.Minifier
+	cssMinifiers []compress.Minifier
+
+	src     string // extracted version
+	staging string // compressed files, before they are written into the sink
+
+	published map[string]bool    // destinations of all the files matched by the fileMap
+	integrity *sri.Manifest      // integrity of every published file
+	manifest  *manifest.Recorder // result of the processing
+
+	mu  sync.Mutex
+	err error // first error of the workers
+}
+
+// Optimizes/minifies package's files on disk for a particular package version.
+func (p *processor) optimize() error {
+	optimization := p.config.Optimization
+	log.Printf("optimizing files (Js %t, Css %t, Png %t, Jpg %t, Svg %t, image derivatives %v)\n",
+		optimization.Js(),
+		optimization.Css(),
+		optimization.Png(),
+		optimization.Jpg(),
+		optimization.Svg(),
+		optimization.ImageDerivatives())
+
+	files, skipped, err := p.config.MatchFiles(p.src)
+	if err != nil {
+		return err
+	}
+	for _, s := range skipped {
+		p.manifest.Skip(s)
+	}
+	for _, file := range files {
+		p.published[file.To] = true
+	}
+
+	cpuCount := runtime.NumCPU()
+	jobs := make(chan optimizeJob, cpuCount)
+
+	var wg sync.WaitGroup
+	wg.Add(len(files))
+
+	for w := 1; w <= cpuCount; w++ {
+		go p.optimizeWorker(&wg, jobs)
+	}
+
+	for _, file := range files {
+		jobs <- optimizeJob{
+			p:    p,
+			File: file.From,
+			Dest: file.To,
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+	return p.err
+}
+
+func (p *processor) optimizeWorker(wg *sync.WaitGroup, jobs <-chan optimizeJob) {
+	for j := range jobs {
+		if !p.failed() {
+			if err := j.run(); err != nil {
+				p.fail(errors.Wrapf(err, "could not process %s", j.File))
+			}
+		}
+		wg.Done()
+	}
+}
+
+// Records the first error, the remaining jobs are then skipped.
+func (p *processor) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *processor) failed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err != nil
+}
+
+// Writes a file from disk into the sink.
+func (p *processor) writeFile(name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "could not open file")
+	}
+	defer f.Close()
+
+	if err := p.sink.WriteFile(name, f); err != nil {
+		return errors.Wrapf(err, "could not write %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+)
+
+var (
+	DOCKER_IMAGE = os.Getenv("DOCKER_IMAGE")
+)
+
+// Docker runs process-version in a container of the DOCKER_IMAGE image.
+type Docker struct {
+	Image string
+}
+
+// NewDocker returns the Docker runtime, using DOCKER_IMAGE.
+func NewDocker() *Docker {
+	return &Docker{Image: DOCKER_IMAGE}
+}
+
+// Init pulls the image.
+func (d *Docker) Init(ctx context.Context) error {
+	if d.Image == "" {
+		return errors.New("DOCKER_IMAGE needs to be present")
+	}
+
+	cli, err := getCli()
+	if err != nil {
+		return errors.Wrap(err, "could not create client")
+	}
+
+	reader, err := cli.ImagePull(ctx, d.Image, types.ImagePullOptions{})
+	if err != nil {
+		return errors.Wrap(err, "could not pull image")
+	}
+	if _, err := io.Copy(os.Stdout, reader); err != nil {
+		return errors.Wrap(err, "failed to display pull logs")
+	}
+	return nil
+}
+
+func getCli() (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(
+		client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+// ContainerConfig returns the configuration of the container processing the
+// in directory into the out directory: the limits of the options apply, the
+// root filesystem is read-only and every capability is dropped.
+func (d *Docker) ContainerConfig(in, out string, opts Options) (*container.Config, *container.HostConfig) {
+	networkMode := container.NetworkMode("none")
+	if opts.Network {
+		networkMode = "default"
+	}
+	pidsLimit := opts.PidsLimit
+
+	config := &container.Config{
+		Image:           d.Image,
+		Env:             opts.Env,
+		NetworkDisabled: !opts.Network,
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{
+			{
+				Type:     mount.TypeBind,
+				Source:   in,
+				Target:   "/input",
+				ReadOnly: true,
+			},
+			{
+				Type:   mount.TypeBind,
+				Source: out,
+				Target: "/output",
+			},
+		},
+		Tmpfs: map[string]string{
+			"/tmp": fmt.Sprintf("rw,noexec,nosuid,size=%d", opts.TmpfsSize),
+		},
+		ReadonlyRootfs: true,
+		NetworkMode:    networkMode,
+		CapDrop:        []string{"ALL"},
+		SecurityOpt:    []string{"no-new-privileges"},
+		Resources: container.Resources{
+			Memory:     opts.Memory,
+			MemorySwap: opts.Memory, // no swap
+			NanoCPUs:   opts.NanoCPUs,
+			PidsLimit:  &pidsLimit,
+		},
+	}
+	return config, hostConfig
+}
+
+// Run runs the container with the in directory mounted read-only on /input and
+// the out directory on /output, and returns the logs of the container.
+func (d *Docker) Run(ctx context.Context, containerName, in, out string, opts Options) (string, error) {
+	cli, err := getCli()
+	if err != nil {
+		return "", errors.Wrap(err, "could not create client")
+	}
+
+	config, hostConfig := d.ContainerConfig(in, out, opts)
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, containerName)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create container")
+	}
+
+	// once we are done remove the container to free the name in case we rerun it,
+	// using a new context as ctx may be done
+	defer func() {
+		removeopts := types.ContainerRemoveOptions{Force: true}
+		if err := cli.ContainerRemove(context.Background(), resp.ID, removeopts); err != nil {
+			log.Printf("could not remove container %s / %s: %s\n", resp.ID, containerName, err)
+		}
+	}()
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", errors.Wrap(err, "could not start container")
+	}
+
+	waitCtx, cancel := opts.runContext(ctx)
+	defer cancel()
+
+	var runErr error
+	statusCh, errCh := cli.ContainerWait(waitCtx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			if waitCtx.Err() != context.DeadlineExceeded {
+				return "", errors.Wrap(err, "failed to wait for container")
+			}
+			if err := cli.ContainerKill(context.Background(), resp.ID, "KILL"); err != nil {
+				return "", errors.Wrapf(err, "could not kill container %s", containerName)
+			}
+			runErr = TimeoutError{Container: containerName, Timeout: opts.Timeout}
+		}
+	case <-statusCh:
+	}
+
+	logsOpts := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true}
+	logsReader, err := cli.ContainerLogs(context.Background(), resp.ID, logsOpts)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to retrieve logs")
+	}
+
+	buff := new(bytes.Buffer)
+
+	_, err = stdcopy.StdCopy(buff, buff, logsReader)
+	if err != nil {
+		return "", errors.Wrap(err, "could not display logs")
+	}
+
+	return buff.String(), runErr
+}
@@ -0,0 +1,202 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// PROCESS_VERSION is the process-version binary of the local runtime.
+	PROCESS_VERSION = os.Getenv("PROCESS_VERSION")
+	// SANDBOX_ISOLATION forces the isolation of the local runtime.
+	SANDBOX_ISOLATION = os.Getenv("SANDBOX_ISOLATION")
+
+	// the only environment variables of the host passed to process-version,
+	// the others may hold secrets
+	hostEnv = []string{"PATH", "HOME"}
+)
+
+// Isolation is how the local runtime isolates a run.
+type Isolation string
+
+const (
+	// IsolationNone runs process-version as a plain subprocess.
+	IsolationNone Isolation = "none"
+	// IsolationUserns runs process-version in new user and network
+	// namespaces, using unshare.
+	IsolationUserns Isolation = "userns"
+	// IsolationBwrap runs process-version with bubblewrap, with a read-only
+	// view of the host, a private /tmp and, unless allowed, no network.
+	IsolationBwrap Isolation = "bwrap"
+)
+
+// Local runs process-version in a subprocess, without Docker. It is meant
+// for development and CI: only the deadline and the network are enforced.
+type Local struct {
+	// Bin is the process-version binary.
+	Bin string
+	// Isolation is detected by Init if empty.
+	Isolation Isolation
+}
+
+// NewLocal returns the local runtime, using PROCESS_VERSION and
+// SANDBOX_ISOLATION.
+func NewLocal() *Local {
+	bin := PROCESS_VERSION
+	if bin == "" {
+		bin = "process-version"
+	}
+	return &Local{Bin: bin, Isolation: Isolation(SANDBOX_ISOLATION)}
+}
+
+// Init finds the binary and, if needed, the strongest isolation
+// available on the host.
+func (l *Local) Init(ctx context.Context) error {
+	bin, err := exec.LookPath(l.Bin)
+	if err != nil {
+		return errors.Wrapf(err, "could not find %s", l.Bin)
+	}
+	l.Bin = bin
+
+	switch l.Isolation {
+	case "":
+		l.Isolation = IsolationNone
+		for _, isolation := range []Isolation{IsolationBwrap, IsolationUserns} {
+			if isolationWorks(ctx, isolation) {
+				l.Isolation = isolation
+				break
+			}
+		}
+	case IsolationNone, IsolationUserns, IsolationBwrap:
+		if !isolationWorks(ctx, l.Isolation) {
+			return errors.Errorf("isolation %s is not available", l.Isolation)
+		}
+	default:
+		return errors.Errorf("unknown isolation: %s", l.Isolation)
+	}
+	log.Printf("sandbox: running %s locally with isolation %s\n", l.Bin, l.Isolation)
+	return nil
+}
+
+// Checks that a trivial command can run with the isolation, the tools
+// can be missing or the user namespaces disabled.
+func isolationWorks(ctx context.Context, isolation Isolation) bool {
+	if isolation == IsolationNone {
+		return true
+	}
+	args := isolationArgs(isolation, Options{}, "/", "/")
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return false
+	}
+	args = append(args, "true")
+	return exec.CommandContext(ctx, args[0], args[1:]...).Run() == nil
+}
+
+// Returns the command line prefix running a command with the isolation.
+func isolationArgs(isolation Isolation, opts Options, in, out string) []string {
+	switch isolation {
+	case IsolationUserns:
+		args := []string{"unshare", "--user", "--map-root-user", "--kill-child"}
+		if !opts.Network {
+			args = append(args, "--net")
+		}
+		return append(args, "--")
+	case IsolationBwrap:
+		// in and out are bound after the private /tmp, which hides them
+		// if they are in the host's /tmp
+		args := []string{
+			"bwrap",
+			"--ro-bind", "/", "/",
+			"--dev", "/dev",
+			"--proc", "/proc",
+			"--tmpfs", "/tmp",
+			"--ro-bind", in, in,
+			"--bind", out, out,
+			"--unshare-all",
+			"--die-with-parent",
+			"--new-session",
+		}
+		if opts.Network {
+			args = append(args, "--share-net")
+		}
+		return append(args, "--")
+	}
+	return nil
+}
+
+// Run runs process-version with the in and out directories, and a scratch
+// workspace, and returns its combined output.
+func (l *Local) Run(ctx context.Context, name, in, out string, opts Options) (string, error) {
+	// the workspace is on the private /tmp with bubblewrap
+	workspace := "/tmp/work"
+	if l.Isolation != IsolationBwrap {
+		dir, err := ioutil.TempDir("", "work")
+		if err != nil {
+			return "", errors.Wrap(err, "failed to create workspace")
+		}
+		defer os.RemoveAll(dir)
+		workspace = path.Join(dir, "work")
+	}
+
+	runCtx, cancel := opts.runContext(ctx)
+	defer cancel()
+
+	args := append(isolationArgs(l.Isolation, opts, in, out), l.Bin)
+	cmd := exec.Command(args[0], args[1:]...)
+	// in its own process group, to kill all its processes on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	for _, key := range hostEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	cmd.Env = append(cmd.Env, opts.Env...)
+	cmd.Env = append(cmd.Env,
+		"INPUT_DIR="+in,
+		"OUTPUT_DIR="+out,
+		"WORKSPACE_DIR="+workspace,
+	)
+
+	buff := new(bytes.Buffer)
+	cmd.Stdout = buff
+	cmd.Stderr = buff
+
+	log.Printf("sandbox: run %s\n", cmd)
+	if err := cmd.Start(); err != nil {
+		return "", errors.Wrap(err, "could not start process-version")
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-runCtx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	switch runCtx.Err() {
+	case context.DeadlineExceeded:
+		return buff.String(), TimeoutError{Container: name, Timeout: opts.Timeout}
+	case context.Canceled:
+		// killed, the run didn't complete
+		return buff.String(), ctx.Err()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// like a container exiting with an error,
+		// the logs tell what happened
+		log.Printf("sandbox: %s %s\n", name, exitErr)
+	} else if err != nil {
+		return "", errors.Wrap(err, "could not run process-version")
+	}
+	return buff.String(), nil
+}
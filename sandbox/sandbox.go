@@ -1,24 +1,44 @@
 package sandbox
 
 import (
-	"bytes"
 	"context"
-	"io"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/pkg/errors"
 )
 
 var (
-	DOCKER_IMAGE = os.Getenv("DOCKER_IMAGE")
+	// SANDBOX_RUNTIME selects the runtime, `docker` (default) or `local`.
+	SANDBOX_RUNTIME = os.Getenv("SANDBOX_RUNTIME")
 )
 
+// Runtime runs process-version in isolation, with an input directory
+// containing the configuration and the tarball of the version, and an
+// output directory in which the processed files are written.
+type Runtime interface {
+	// Init prepares the runtime before the first run.
+	Init(ctx context.Context) error
+	// Run processes the version in the in directory into the out directory,
+	// and returns the logs. If the deadline of the options is exceeded, the
+	// logs so far are returned with a TimeoutError.
+	Run(ctx context.Context, name, in, out string, opts Options) (string, error)
+}
+
+// NewRuntime returns the runtime selected by SANDBOX_RUNTIME.
+func NewRuntime() (Runtime, error) {
+	switch SANDBOX_RUNTIME {
+	case "", "docker":
+		return NewDocker(), nil
+	case "local":
+		return NewLocal(), nil
+	default:
+		return nil, errors.Errorf("unknown sandbox runtime: %s", SANDBOX_RUNTIME)
+	}
+}
+
 func Setup() (string, string, error) {
 	tmpDir := os.TempDir()
 	inDir, err := ioutil.TempDir(tmpDir, "in")
@@ -33,95 +53,47 @@ func Setup() (string, string, error) {
 	return inDir, outDir, nil
 }
 
-func Init(ctx context.Context) error {
-	if DOCKER_IMAGE == "" {
-		return errors.New("DOCKER_IMAGE needs to be present")
-	}
-
-	cli, err := getCli()
-	if err != nil {
-		return errors.Wrap(err, "could not create client")
-	}
-
-	reader, err := cli.ImagePull(ctx, DOCKER_IMAGE, types.ImagePullOptions{})
-	if err != nil {
-		return errors.Wrap(err, "could not pull image")
-	}
-	if _, err := io.Copy(os.Stdout, reader); err != nil {
-		return errors.Wrap(err, "failed to display pull logs")
-	}
-	return nil
+// Options limits the resources and privileges of a sandboxed run.
+// The local runtime only enforces the deadline and, when it isolates
+// the run, the network.
+type Options struct {
+	Timeout   time.Duration // wall-clock deadline, the run is killed after it, 0 for none
+	Memory    int64         // memory limit in bytes, including the tmpfs
+	NanoCPUs  int64         // CPU quota in units of 1e-9 CPUs
+	PidsLimit int64         // maximum number of processes
+	TmpfsSize int64         // size of the tmpfs mounted on /tmp in bytes
+	Network   bool          // if set, the run has network access
+	Env       []string      // environment variables, in the form KEY=value
 }
 
-func getCli() (*client.Client, error) {
-	cli, err := client.NewClientWithOpts(
-		client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return nil, err
+// DefaultOptions returns strict options: no network, 2 CPUs, 2GiB of memory,
+// 512 processes and 10 minutes, which is enough for the largest packages.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:   10 * time.Minute,
+		Memory:    2 << 30,
+		NanoCPUs:  2e9,
+		PidsLimit: 512,
+		TmpfsSize: 1 << 30,
 	}
-	return cli, nil
 }
 
-func Run(ctx context.Context, containerName, in, out string) (string, error) {
-	cli, err := getCli()
-	if err != nil {
-		return "", errors.Wrap(err, "could not create client")
-	}
-
-	resp, err := cli.ContainerCreate(ctx,
-		&container.Config{
-			Image: DOCKER_IMAGE,
-		},
-		&container.HostConfig{
-			Mounts: []mount.Mount{
-				{
-					Type:     mount.TypeBind,
-					Source:   in,
-					Target:   "/input",
-					ReadOnly: true,
-				},
-				{
-					Type:   mount.TypeBind,
-					Source: out,
-					Target: "/output",
-				},
-			},
-		}, nil, nil, containerName)
-	if err != nil {
-		return "", errors.Wrap(err, "could not create container")
-	}
-
-	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return "", errors.Wrap(err, "could not start container")
-	}
-
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return "", errors.Wrap(err, "failed to wait for container")
-		}
-	case <-statusCh:
-	}
-
-	opts := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true}
-	logsReader, err := cli.ContainerLogs(ctx, resp.ID, opts)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to retrieve logs")
-	}
-
-	buff := new(bytes.Buffer)
-
-	_, err = stdcopy.StdCopy(buff, buff, logsReader)
-	if err != nil {
-		return "", errors.Wrap(err, "could not display logs")
+// Returns the context of a run, which is done when the deadline of the
+// options is exceeded.
+func (o Options) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, o.Timeout)
+}
 
-	// once we are done remove the container to free the name in case we rerun it
-	removeopts := types.ContainerRemoveOptions{}
-	if err := cli.ContainerRemove(ctx, resp.ID, removeopts); err != nil {
-		return "", errors.Wrapf(err, "could not remove container %s / %s", resp.ID, containerName)
-	}
+// TimeoutError is returned when a run has been killed
+// because it exceeded its deadline.
+type TimeoutError struct {
+	Container string
+	Timeout   time.Duration
+}
 
-	return buff.String(), nil
+func (t TimeoutError) Error() string {
+	return fmt.Sprintf("container %s timed out after %s", t.Container, t.Timeout)
 }
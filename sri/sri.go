@@ -1,14 +1,26 @@
 package sri
 
 import (
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/cdnjs/tools/util"
+
+	"github.com/pkg/errors"
 )
 
+// ManifestFile is the name of the per-version integrity manifest.
+const ManifestFile = "integrity.json"
+
 // CalculateFileSRI generates a Subresource Integrity string for a particular file.
 func CalculateFileSRI(filepath string, out string) {
 	bytes, err := ioutil.ReadFile(filepath)
@@ -29,3 +41,110 @@ func CalculateSRI(bytes []byte) string {
 	sri := base64.StdEncoding.EncodeToString(h.Sum(nil))
 	return fmt.Sprintf("sha512-%s", sri)
 }
+
+// Integrity holds the Subresource Integrity strings of a file
+// for each supported algorithm.
+type Integrity struct {
+	SHA256 string `json:"sha256"`
+	SHA384 string `json:"sha384"`
+	SHA512 string `json:"sha512"`
+}
+
+// String returns the integrity as a space-separated list of hashes,
+// which is a valid value for an `integrity` HTML attribute.
+func (i *Integrity) String() string {
+	return strings.Join([]string{i.SHA256, i.SHA384, i.SHA512}, " ")
+}
+
+// CalculateIntegrity calculates the sha256, sha384 and sha512
+// Subresource Integrity strings of a stream in one read.
+func CalculateIntegrity(r io.Reader) (*Integrity, error) {
+	h256, h384, h512 := sha256.New(), sha512.New384(), sha512.New()
+	if _, err := io.Copy(io.MultiWriter(h256, h384, h512), r); err != nil {
+		return nil, errors.Wrap(err, "could not hash")
+	}
+
+	encode := func(alg string, sum []byte) string {
+		return fmt.Sprintf("%s-%s", alg, base64.StdEncoding.EncodeToString(sum))
+	}
+	return &Integrity{
+		SHA256: encode("sha256", h256.Sum(nil)),
+		SHA384: encode("sha384", h384.Sum(nil)),
+		SHA512: encode("sha512", h512.Sum(nil)),
+	}, nil
+}
+
+// CalculateFileIntegrity calculates the Integrity of a particular file.
+func CalculateFileIntegrity(filepath string) (*Integrity, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open file")
+	}
+	defer f.Close()
+	return CalculateIntegrity(f)
+}
+
+// Manifest maps the files of a version to their Integrity.
+// It is safe for concurrent use.
+type Manifest struct {
+	mu    sync.Mutex
+	files map[string]*Integrity
+}
+
+// NewManifest creates an empty manifest.
+func NewManifest() *Manifest {
+	return &Manifest{files: make(map[string]*Integrity)}
+}
+
+// ParseManifest parses a manifest in its JSON form.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := NewManifest()
+	if err := json.Unmarshal(data, &m.files); err != nil {
+		return nil, errors.Wrap(err, "could not parse integrity manifest")
+	}
+	return m, nil
+}
+
+// Add sets the Integrity of a file.
+func (m *Manifest) Add(file string, integrity *Integrity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[file] = integrity
+}
+
+// Get gets the Integrity of a file, if present.
+func (m *Manifest) Get(file string) (*Integrity, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.files[file]
+	return i, ok
+}
+
+// Files lists the files of the manifest, sorted.
+func (m *Manifest) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make([]string, 0, len(m.files))
+	for file := range m.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// MarshalJSON marshals the manifest as a JSON object keyed by file name.
+// Keys are sorted, so the output is deterministic.
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.files)
+}
+
+// WriteFile writes the manifest to disk.
+func (m *Manifest) WriteFile(out string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal integrity manifest")
+	}
+	return ioutil.WriteFile(out, data, 0644)
+}
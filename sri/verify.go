@@ -0,0 +1,81 @@
+package sri
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// supported algorithms, from the weakest to the strongest
+var algorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha1", sha1.New},
+	{"sha256", sha256.New},
+	{"sha384", sha512.New384},
+	{"sha512", sha512.New},
+}
+
+// Verifier checks the content written to it against a Subresource
+// Integrity string. As in browsers, the content must match one of the
+// hashes of the strongest algorithm of the string.
+type Verifier struct {
+	hash.Hash
+	integrity string
+	expected  [][]byte
+}
+
+// NewVerifier creates a Verifier of a Subresource Integrity string,
+// which is a space-separated list of hashes.
+func NewVerifier(integrity string) (*Verifier, error) {
+	hashes := make(map[string][][]byte)
+	for _, token := range strings.Fields(integrity) {
+		parts := strings.SplitN(token, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// options, after a ?, are ignored
+		digest, err := base64.StdEncoding.DecodeString(strings.SplitN(parts[1], "?", 2)[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid hash %s", token)
+		}
+		hashes[parts[0]] = append(hashes[parts[0]], digest)
+	}
+
+	for i := len(algorithms) - 1; i >= 0; i-- {
+		alg := algorithms[i]
+		if expected, ok := hashes[alg.name]; ok {
+			return &Verifier{Hash: alg.new(), integrity: integrity, expected: expected}, nil
+		}
+	}
+	return nil, errors.Errorf("no supported hash in %s", integrity)
+}
+
+// Verify checks the content written so far.
+func (v *Verifier) Verify() error {
+	sum := v.Sum(nil)
+	for _, expected := range v.expected {
+		if bytes.Equal(sum, expected) {
+			return nil
+		}
+	}
+	return errors.Errorf("content doesn't match %s", v.integrity)
+}
+
+// FromHex returns the Subresource Integrity string of a hex digest,
+// for instance FromHex("sha1", shasum).
+func FromHex(alg, digest string) (string, error) {
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid digest")
+	}
+	return alg + "-" + base64.StdEncoding.EncodeToString(sum), nil
+}
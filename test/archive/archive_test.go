@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cdnjs/tools/archive"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+var files = map[string]string{
+	"package/a.js":      "console.log('a');",
+	"package/dist/b.js": "console.log('b');",
+}
+
+func createTar(t *testing.T) []byte {
+	buff := new(bytes.Buffer)
+	tw := tar.NewWriter(buff)
+	for _, name := range []string{"package/a.js", "package/dist/b.js"} {
+		content := files[name]
+		header := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}
+		assert.Nil(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	return buff.Bytes()
+}
+
+func createTarGz(t *testing.T) []byte {
+	buff := new(bytes.Buffer)
+	gw := gzip.NewWriter(buff)
+	_, err := gw.Write(createTar(t))
+	assert.Nil(t, err)
+	assert.Nil(t, gw.Close())
+	return buff.Bytes()
+}
+
+func createTarXz(t *testing.T) []byte {
+	buff := new(bytes.Buffer)
+	xw, err := xz.NewWriter(buff)
+	assert.Nil(t, err)
+	_, err = xw.Write(createTar(t))
+	assert.Nil(t, err)
+	assert.Nil(t, xw.Close())
+	return buff.Bytes()
+}
+
+func createZip(t *testing.T) []byte {
+	buff := new(bytes.Buffer)
+	zw := zip.NewWriter(buff)
+	_, err := zw.Create("package/dist/")
+	assert.Nil(t, err)
+	for _, name := range []string{"package/a.js", "package/dist/b.js"} {
+		w, err := zw.Create(name)
+		assert.Nil(t, err)
+		_, err = w.Write([]byte(files[name]))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+	return buff.Bytes()
+}
+
+func TestInflate(t *testing.T) {
+	cases := []struct {
+		format archive.Format
+		create func(t *testing.T) []byte
+	}{
+		{archive.Tar, createTar},
+		{archive.TarGz, createTarGz},
+		{archive.TarXz, createTarXz},
+		{archive.Zip, createZip},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(string(c.format), func(t *testing.T) {
+			data := c.create(t)
+
+			format, err := archive.Detect(data)
+			assert.Nil(t, err)
+			assert.Equal(t, c.format, format)
+
+			inflated := make(map[string]string)
+			err = archive.Inflate(bytes.NewReader(data), func(name string, r io.Reader) error {
+				content, err := ioutil.ReadAll(r)
+				inflated[name] = string(content)
+				return err
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, files, inflated)
+		})
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	cases := []struct {
+		format archive.Format
+		create func(t *testing.T) []byte
+	}{
+		{archive.Tar, createTar},
+		{archive.TarGz, createTarGz},
+		{archive.TarXz, createTarXz},
+		{archive.Zip, createZip},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(string(c.format), func(t *testing.T) {
+			data := c.create(t)
+
+			// the content of the entries is 34 bytes
+			r, err := archive.NewReaderSize(bytes.NewReader(data), 33)
+			assert.Nil(t, err)
+			defer r.Close()
+			var size int
+			for {
+				_, err = r.Next()
+				if err != nil {
+					break
+				}
+				var content []byte
+				content, err = ioutil.ReadAll(r)
+				size += len(content)
+				if err != nil {
+					break
+				}
+			}
+			assert.Equal(t, archive.TooLargeError{MaxSize: 33}, err)
+			assert.Equal(t, 34, size)
+
+			r, err = archive.NewReaderSize(bytes.NewReader(data), 34)
+			assert.Nil(t, err)
+			defer r.Close()
+			for {
+				if _, err = r.Next(); err != nil {
+					break
+				}
+				_, err = ioutil.ReadAll(r)
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestZipInMemory(t *testing.T) {
+	data := createZip(t)
+
+	// a zip is read in memory if it can't be read at random
+	_, err := archive.NewReaderSize(bytes.NewBuffer(data), int64(len(data)-1))
+	assert.Equal(t, archive.TooLargeError{MaxSize: int64(len(data) - 1)}, err)
+
+	r, err := archive.NewReaderSize(bytes.NewBuffer(data), int64(len(data)))
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+
+	// or else read in place
+	f, err := ioutil.TempFile("", "archive")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.Write(data)
+	assert.Nil(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	assert.Nil(t, err)
+
+	r, err = archive.NewReaderSize(f, 1)
+	assert.Nil(t, err)
+	defer r.Close()
+	header, err := r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "package/dist/", header.Name)
+}
+
+func TestZipSymlink(t *testing.T) {
+	buff := new(bytes.Buffer)
+	zw := zip.NewWriter(buff)
+	header := &zip.FileHeader{Name: "package/b.js"}
+	header.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(header)
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("a.js"))
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+
+	r, err := archive.NewReader(buff)
+	assert.Nil(t, err)
+	defer r.Close()
+
+	h, err := r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "package/b.js", h.Name)
+	assert.Equal(t, byte(tar.TypeSymlink), h.Typeflag)
+	assert.Equal(t, "a.js", h.Linkname)
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := archive.Detect([]byte("not an archive"))
+	assert.Equal(t, archive.ErrUnknownFormat, err)
+
+	_, err = archive.NewReader(bytes.NewBufferString("not an archive"))
+	assert.Equal(t, archive.ErrUnknownFormat, err)
+}
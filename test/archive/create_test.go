@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cdnjs/tools/archive"
+	"github.com/cdnjs/tools/manifest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes an output of process-version, with a manifest.
+func createOutput(t *testing.T, durationMs int64) string {
+	dir, err := ioutil.TempDir("", "out")
+	assert.Nil(t, err)
+
+	m := &manifest.Manifest{
+		Files: []manifest.File{
+			{Name: "a.js", Size: 17, DurationMs: durationMs},
+			{Name: "dist/b.js", Size: 17, DurationMs: durationMs},
+		},
+		DurationMs: durationMs,
+	}
+	assert.Nil(t, m.Write(path.Join(dir, manifest.Name)))
+	assert.Nil(t, os.MkdirAll(path.Join(dir, "dist"), 0700))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "a.js.gz"), []byte("a"), 0600))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "dist/b.js.gz"), []byte("b"), 0644))
+	return dir
+}
+
+func TestCreateReproducible(t *testing.T) {
+	var outputs [][]byte
+	var digests []string
+	for i := 0; i < 2; i++ {
+		// different timings and modification times
+		dir := createOutput(t, int64(10*i))
+		defer os.RemoveAll(dir)
+		mtime := time.Now().Add(time.Duration(i) * time.Hour)
+		assert.Nil(t, os.Chtimes(path.Join(dir, "a.js.gz"), mtime, mtime))
+
+		buff := new(bytes.Buffer)
+		digest, err := archive.Create(dir, buff)
+		assert.Nil(t, err)
+		outputs = append(outputs, buff.Bytes())
+		digests = append(digests, digest)
+	}
+	assert.Equal(t, outputs[0], outputs[1])
+	assert.Equal(t, digests[0], digests[1])
+}
+
+func TestCreate(t *testing.T) {
+	dir := createOutput(t, 42)
+	defer os.RemoveAll(dir)
+
+	buff := new(bytes.Buffer)
+	_, err := archive.Create(dir, buff)
+	assert.Nil(t, err)
+
+	ar, err := archive.NewReader(buff)
+	assert.Nil(t, err)
+	defer ar.Close()
+
+	var names []string
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		names = append(names, header.Name)
+		assert.Equal(t, int64(0), header.ModTime.Unix())
+		assert.Equal(t, 0, header.Uid)
+
+		if header.Name == "/"+manifest.Name {
+			content, err := ioutil.ReadAll(ar)
+			assert.Nil(t, err)
+			m, err := manifest.Parse(content)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"a.js", "dist/b.js"}, m.Names())
+			assert.Equal(t, int64(0), m.DurationMs)
+			assert.Equal(t, int64(0), m.Files[0].DurationMs)
+		}
+	}
+
+	// the manifest is first, then the entries in lexical order
+	assert.Equal(t, []string{"/" + manifest.Name, "/a.js.gz", "/dist", "/dist/b.js.gz"}, names)
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 )
 
 func createFakeBotPath() string {
@@ -49,10 +50,18 @@ func runChecker(fakeBotPath string, proxy string, validatePath bool, args ...str
 		args = append([]string{"-no-path-validation"}, args...)
 	}
 
+	processVersion, err := filepath.Abs("../../bin/process-version")
+	if err != nil {
+		panic(err)
+	}
+
 	cmd := exec.Command("../../bin/checker", args...)
 	cmd.Env = append(os.Environ(),
 		"HTTP_PROXY="+proxy,
 		"BOT_BASE_PATH="+fakeBotPath,
+		// process versions without a Docker daemon
+		"SANDBOX_RUNTIME=local",
+		"PROCESS_VERSION="+processVersion,
 	)
 
 	out, _ := cmd.CombinedOutput()
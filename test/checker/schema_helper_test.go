@@ -9,7 +9,7 @@ import (
 )
 
 const (
-	autoupdateSourceRegex = "^git|npm$"
+	autoupdateSourceRegex = "^(git|npm)$"
 	licenseRegex          = "^(\\(.+ (OR|AND) .+\\)|[a-zA-Z0-9-].*)$"
 	nameRegex             = "^[a-zA-Z0-9._-]+$"
 	repositoryTypeRegex   = "^git|hg|svn$"
@@ -102,6 +102,10 @@ func TestHumanReadableSchema(t *testing.T) {
 			filePath: "schema_tests/human_schema_tests/autoupdate/valid/source_git.json",
 			valid:    true,
 		},
+		{
+			filePath: "schema_tests/human_schema_tests/autoupdate/valid/source_git_remote.json",
+			valid:    true,
+		},
 		{
 			filePath: "schema_tests/human_schema_tests/autoupdate/valid/source_npm.json",
 			valid:    true,
@@ -170,6 +174,10 @@ func TestHumanReadableSchema(t *testing.T) {
 			filePath: "schema_tests/human_schema_tests/autoupdate/invalid/source_svn.json",
 			errors:   []string{"autoupdate.source: Does not match pattern '" + autoupdateSourceRegex + "'"},
 		},
+		{
+			filePath: "schema_tests/human_schema_tests/autoupdate/invalid/source_gitlab.json",
+			errors:   []string{"autoupdate.source: Does not match pattern '" + autoupdateSourceRegex + "'"},
+		},
 		// description valid
 		{
 			filePath: "schema_tests/human_schema_tests/description/valid/valid_description.json",
@@ -348,6 +356,14 @@ func TestHumanReadableSchema(t *testing.T) {
 			filePath: "schema_tests/human_schema_tests/optimization/valid/empty_optimization.json",
 			valid:    true,
 		},
+		{
+			filePath: "schema_tests/human_schema_tests/optimization/valid/gzip_iterations.json",
+			valid:    true,
+		},
+		{
+			filePath: "schema_tests/human_schema_tests/optimization/valid/image_derivatives.json",
+			valid:    true,
+		},
 		{
 			filePath: "schema_tests/human_schema_tests/optimization/valid/js_only.json",
 			valid:    true,
@@ -365,6 +381,18 @@ func TestHumanReadableSchema(t *testing.T) {
 			filePath: "schema_tests/human_schema_tests/optimization/invalid/not_boolean.json",
 			errors:   []string{"optimization.js: Invalid type. Expected: boolean, given: string"},
 		},
+		{
+			filePath: "schema_tests/human_schema_tests/optimization/invalid/gzip_iterations_too_low.json",
+			errors:   []string{"optimization.gzip.iterations: Must be greater than or equal to 1"},
+		},
+		{
+			filePath: "schema_tests/human_schema_tests/optimization/invalid/gzip_iterations_too_high.json",
+			errors:   []string{"optimization.gzip.iterations: Must be less than or equal to 1000"},
+		},
+		{
+			filePath: "schema_tests/human_schema_tests/optimization/invalid/unknown_image_derivative.json",
+			errors:   []string{`optimization.images.derivatives.0: optimization.images.derivatives.0 must be one of the following: "webp", "avif"`},
+		},
 	}
 
 	runSchemaTestCases(t, packages.HumanReadableSchema, cases)
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Inputs around the bounds of the window picked for brotli.
+func brotliInputs() map[string][]byte {
+	random := make([]byte, 70000)
+	rand.New(rand.NewSource(1)).Read(random)
+	return map[string][]byte{
+		"empty":  {},
+		"small":  []byte("console.log('a');"),
+		"window": []byte(strings.Repeat("a", 1<<10-16+1)),
+		"text":   []byte(strings.Repeat("function hello(name) { return 'hello ' + name; }\n", 2000)),
+		"random": random,
+	}
+}
+
+func TestBrotliRoundTrip(t *testing.T) {
+	for name, input := range brotliInputs() {
+		compressed, err := compress.Brotli11Bytes(input)
+		assert.Nil(t, err, name)
+
+		uncompressed, err := compress.UnBrotli(compressed)
+		assert.Nil(t, err, name)
+		assert.Equal(t, input, uncompressed, name)
+	}
+}
+
+func TestBrotliFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brotli")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	content := []byte(strings.Repeat("console.log('a happy tyler');\n", 100))
+	src := path.Join(dir, "a.js")
+	assert.Nil(t, ioutil.WriteFile(src, content, 0644))
+
+	out := src + compress.Brotli.Ext
+	assert.Nil(t, compress.Brotli.Encode(context.Background(), src, out, compress.EncodeOptions{}))
+
+	compressed, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(content))
+
+	uncompressed, err := compress.Brotli.Decode(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, content, uncompressed)
+}
+
+// The files used to be compressed with the brotli CLI, which has to be able
+// to decompress the new ones.
+func TestBrotliCLI(t *testing.T) {
+	bin, err := exec.LookPath("brotli")
+	if err != nil {
+		t.Skip("brotli CLI not found")
+	}
+
+	for name, input := range brotliInputs() {
+		compressed, err := compress.Brotli11Bytes(input)
+		assert.Nil(t, err, name)
+
+		cmd := exec.Command(bin, "-d", "-c")
+		cmd.Stdin = bytes.NewReader(compressed)
+		out, err := cmd.Output()
+		assert.Nil(t, err, name)
+		assert.Equal(t, input, out, name)
+	}
+}
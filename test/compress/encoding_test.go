@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultEncodings(t *testing.T) {
+	// zstd is opt-in
+	assert.Equal(t, []*compress.Encoding{compress.Brotli, compress.Gzip}, compress.DefaultEncodings)
+}
+
+func TestParseEncodings(t *testing.T) {
+	list, err := compress.ParseEncodings("br, gzip,zstd")
+	assert.Nil(t, err)
+	assert.Equal(t, []*compress.Encoding{compress.Brotli, compress.Gzip, compress.Zstd}, list)
+
+	list, err = compress.ParseEncodings("zstd")
+	assert.Nil(t, err)
+	assert.Equal(t, []*compress.Encoding{compress.Zstd}, list)
+
+	_, err = compress.ParseEncodings("br,deflate")
+	assert.NotNil(t, err)
+
+	_, err = compress.ParseEncodings(" , ")
+	assert.NotNil(t, err)
+}
+
+func TestSplitEncodingExt(t *testing.T) {
+	name, enc := compress.SplitEncodingExt("a.min.js.zst")
+	assert.Equal(t, "a.min.js", name)
+	assert.Equal(t, compress.Zstd, enc)
+
+	name, enc = compress.SplitEncodingExt("f.woff2")
+	assert.Equal(t, "f.woff2", name)
+	assert.Nil(t, enc)
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zstd")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("console.log('a happy tyler');\n", 100)
+	src := path.Join(dir, "a.js")
+	assert.Nil(t, ioutil.WriteFile(src, []byte(content), 0644))
+
+	out := src + compress.Zstd.Ext
+	assert.Nil(t, compress.Zstd.Encode(context.Background(), src, out, compress.EncodeOptions{}))
+
+	compressed, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(content))
+
+	uncompressed, err := compress.Zstd.Decode(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, content, string(uncompressed))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipWith(t *testing.T, backend compress.GzipBackend, input []byte) []byte {
+	compressed, err := compress.GzipBytes(backend, input)
+	assert.Nil(t, err)
+
+	uncompressed, err := compress.Gzip.Decode(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, input, uncompressed)
+	return compressed
+}
+
+func TestGzipZopfliIterations(t *testing.T) {
+	input := []byte(strings.Repeat("function hello(name) { return 'hello ' + name; }\n", 20))
+
+	def := gzipWith(t, compress.GzipZopfli(compress.DefaultZopfliIterations), input)
+	max := gzipWith(t, compress.GzipZopfli(compress.MaxZopfliIterations), input)
+	gzipWith(t, compress.GzipZopfli(1), input)
+
+	// out of bounds iterations use the default or the maximum
+	assert.Equal(t, def, gzipWith(t, compress.GzipZopfli(0), input))
+	assert.Equal(t, def, gzipWith(t, compress.GzipZopfli(-1), input))
+	assert.Equal(t, max, gzipWith(t, compress.GzipZopfli(compress.MaxZopfliIterations+1), input))
+}
+
+func TestGzipStdlib(t *testing.T) {
+	input := []byte(strings.Repeat("console.log('a');\n", 20))
+	compressed := gzipWith(t, compress.GzipStdlib, input)
+	assert.True(t, len(compressed) < len(input))
+}
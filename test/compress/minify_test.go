@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// A minifier writing a fixed output, or failing if it has none.
+type fakeMinifier struct {
+	name     string
+	output   string
+	warnings []string
+}
+
+func (m fakeMinifier) Name() string { return m.name }
+
+func (m fakeMinifier) Minify(ctx context.Context, src, dest string) ([]string, error) {
+	if m.output == "" {
+		// a partial output is cleaned up
+		ioutil.WriteFile(dest, []byte("partial"), 0644)
+		return m.warnings, errors.New("unexpected token")
+	}
+	return m.warnings, ioutil.WriteFile(dest, []byte(m.output), 0644)
+}
+
+func writeSource(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "minify")
+	assert.Nil(t, err)
+	file := path.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestParseMinifiers(t *testing.T) {
+	list, err := compress.ParseMinifiers("uglify-js, uglify-es")
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "uglify-js", list[0].Name())
+	assert.Equal(t, "uglify-es", list[1].Name())
+
+	_, err = compress.ParseMinifiers("uglify-js,terser")
+	assert.NotNil(t, err)
+
+	_, err = compress.ParseMinifiers(",")
+	assert.NotNil(t, err)
+}
+
+func TestMinifyAlreadyMinified(t *testing.T) {
+	res := compress.Js(context.Background(), "/dist/a.min.js", compress.DefaultJsMinifiers)
+	assert.True(t, res.Skipped())
+	assert.False(t, res.Failed)
+
+	res = compress.CSS(context.Background(), "/dist/a.min.css", compress.DefaultCSSMinifiers)
+	assert.True(t, res.Skipped())
+	assert.False(t, res.Failed)
+}
+
+func TestMinifyOutputExists(t *testing.T) {
+	file, cleanup := writeSource(t, "a.js", "a();")
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(path.Join(path.Dir(file), "a.min.js"), []byte("upstream"), 0644))
+
+	res := compress.Js(context.Background(), file, []compress.Minifier{fakeMinifier{name: "ok", output: "a()"}})
+	assert.True(t, res.Skipped())
+	assert.False(t, res.Failed)
+
+	// the upstream minified file is kept
+	content, err := ioutil.ReadFile(path.Join(path.Dir(file), "a.min.js"))
+	assert.Nil(t, err)
+	assert.Equal(t, "upstream", string(content))
+}
+
+func TestMinifyFallback(t *testing.T) {
+	file, cleanup := writeSource(t, "a.js", "a();\n")
+	defer cleanup()
+
+	res := compress.Js(context.Background(), file, []compress.Minifier{
+		fakeMinifier{name: "broken", warnings: []string{"first"}},
+		fakeMinifier{name: "ok", output: "a()", warnings: []string{"second"}},
+	})
+	assert.False(t, res.Skipped())
+	assert.Equal(t, "ok", res.Minifier)
+	assert.Equal(t, path.Join(path.Dir(file), "a.min.js"), res.Output)
+	assert.Equal(t, "", res.SourceMap)
+	assert.Equal(t, int64(5), res.InputSize)
+	assert.Equal(t, int64(3), res.OutputSize)
+	assert.Equal(t, []string{"first", "second"}, res.Warnings)
+
+	content, err := ioutil.ReadFile(res.Output)
+	assert.Nil(t, err)
+	assert.Equal(t, "a()", string(content))
+}
+
+func TestMinifyFailure(t *testing.T) {
+	file, cleanup := writeSource(t, "a.css", "a {")
+	defer cleanup()
+
+	res := compress.CSS(context.Background(), file, []compress.Minifier{
+		fakeMinifier{name: "broken"},
+		fakeMinifier{name: "also-broken"},
+	})
+	assert.True(t, res.Skipped())
+	assert.True(t, res.Failed)
+	assert.Contains(t, res.SkipReason, "broken: unexpected token")
+	assert.Contains(t, res.SkipReason, "also-broken: unexpected token")
+
+	// the partial output is removed
+	_, err := os.Stat(path.Join(path.Dir(file), "a.min.css"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMinifyMissingCLI(t *testing.T) {
+	file, cleanup := writeSource(t, "a.js", "a();")
+	defer cleanup()
+
+	defer func(bin string) { compress.UGLIFYJS = bin }(compress.UGLIFYJS)
+	compress.UGLIFYJS = path.Join(path.Dir(file), "uglifyjs")
+
+	res := compress.Js(context.Background(), file, []compress.Minifier{compress.UglifyJS})
+	assert.True(t, res.Failed)
+	assert.Contains(t, res.SkipReason, "uglify-js: ")
+}
+
+func TestESBuildJS(t *testing.T) {
+	source := "function hello(name) {\n  return 'hello ' + name;\n}\nhello('tyler');\n"
+	file, cleanup := writeSource(t, "a.js", source)
+	defer cleanup()
+
+	res := compress.Js(context.Background(), file, []compress.Minifier{compress.ESBuildJS})
+	assert.False(t, res.Skipped(), res.SkipReason)
+	assert.Equal(t, "esbuild-js", res.Minifier)
+	assert.Equal(t, res.Output+".map", res.SourceMap)
+	assert.True(t, res.OutputSize < res.InputSize)
+
+	content, err := ioutil.ReadFile(res.Output)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "\n  ")
+
+	data, err := ioutil.ReadFile(res.SourceMap)
+	assert.Nil(t, err)
+	_, err = compress.ParseSourceMap(data)
+	assert.Nil(t, err)
+}
+
+func TestESBuildJSSyntaxError(t *testing.T) {
+	file, cleanup := writeSource(t, "a.js", "function hello(name {")
+	defer cleanup()
+
+	res := compress.Js(context.Background(), file, []compress.Minifier{compress.ESBuildJS})
+	assert.True(t, res.Failed)
+	assert.Contains(t, res.SkipReason, "esbuild-js: ")
+
+	_, err := os.Stat(path.Join(path.Dir(file), "a.min.js"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestESBuildCSS(t *testing.T) {
+	source := "a {\n  color: #ff0000;\n}\n"
+	file, cleanup := writeSource(t, "a.css", source)
+	defer cleanup()
+
+	res := compress.CSS(context.Background(), file, []compress.Minifier{compress.ESBuildCSS})
+	assert.False(t, res.Skipped(), res.SkipReason)
+	assert.Equal(t, "esbuild-css", res.Minifier)
+	assert.True(t, res.OutputSize < res.InputSize)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// encodes a value as a base64 VLQ, as described in the source map
+// specification
+func vlq(value int) string {
+	v := value << 1
+	if value < 0 {
+		v = (-value << 1) | 1
+	}
+	var s string
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		s += string(base64Chars[digit])
+		if v == 0 {
+			return s
+		}
+	}
+}
+
+// absolute segment: generated column, source line and column in the first
+// source, and name index or -1
+type segment struct {
+	col, srcLine, srcCol, name int
+}
+
+// encodes lines of segments into a mappings field
+func mappings(lines [][]segment) string {
+	var srcLine, srcCol, name int
+	var out []string
+	for _, line := range lines {
+		var col int
+		var segs []string
+		for _, s := range line {
+			seg := vlq(s.col-col) + "A" + vlq(s.srcLine-srcLine) + vlq(s.srcCol-srcCol)
+			col, srcLine, srcCol = s.col, s.srcLine, s.srcCol
+			if s.name >= 0 {
+				seg += vlq(s.name - name)
+				name = s.name
+			}
+			segs = append(segs, seg)
+		}
+		out = append(out, strings.Join(segs, ","))
+	}
+	return strings.Join(out, ";")
+}
+
+func TestVLQ(t *testing.T) {
+	// known values, as encoded by the source-map library
+	cases := map[int]string{
+		0:           "A",
+		1:           "C",
+		-1:          "D",
+		15:          "e",
+		16:          "gB",
+		-16:         "hB",
+		123:         "2H",
+		1000:        "w+B",
+		-1000:       "x+B",
+		2147483647:  "+/////D",
+		-2147483647: "//////D",
+	}
+	for value, encoded := range cases {
+		assert.Equal(t, encoded, vlq(value), "%d", value)
+	}
+}
+
+func TestVLQRoundTrip(t *testing.T) {
+	// large values and negative deltas in every field
+	lines := [][]segment{
+		{{0, 0, 0, -1}, {16, 3, 1000, 0}, {2147483647, 70000, 2147483647, 1}},
+		{},
+		{{5, 0, 2, 0}, {6, 1, 0, -1}},
+		{{0, 70000, 0, 1}},
+	}
+	outer := &compress.SourceMap{
+		Version:  3,
+		File:     "a.min.js",
+		Sources:  []string{"a.js"},
+		Names:    []string{"a", "b"},
+		Mappings: mappings(lines),
+	}
+
+	// maps each source position of outer to itself
+	identityLines := make([][]segment, 70001)
+	for _, line := range lines {
+		for _, s := range line {
+			identityLines[s.srcLine] = append(identityLines[s.srcLine], segment{s.srcCol, s.srcLine, s.srcCol, -1})
+		}
+	}
+	for _, line := range identityLines {
+		sort.Slice(line, func(i, j int) bool { return line[i].col < line[j].col })
+	}
+	identity := &compress.SourceMap{
+		Version:  3,
+		Sources:  []string{"a.js"},
+		Mappings: mappings(identityLines),
+	}
+
+	for _, m := range []*compress.SourceMap{outer, identity} {
+		data, err := m.Marshal()
+		assert.Nil(t, err)
+		_, err = compress.ParseSourceMap(data)
+		assert.Nil(t, err)
+	}
+
+	// the mappings are decoded and encoded back unchanged
+	chained, err := compress.ChainSourceMap(outer, identity)
+	assert.Nil(t, err)
+	assert.Equal(t, outer.Mappings, chained.Mappings)
+	assert.Equal(t, outer.Names, chained.Names)
+	assert.Equal(t, []string{"a.js"}, chained.Sources)
+}
+
+func TestParseSourceMapInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"version": 2, "sources": [], "names": [], "mappings": ""}`,
+		`{"version": 3, "sections": [{}], "sources": [], "names": [], "mappings": ""}`,
+		`{"version": 3, "sources": [], "names": [], "mappings": "A!AA"}`, // invalid character
+		`{"version": 3, "sources": [], "names": [], "mappings": "AAg"}`,  // truncated
+		`{"version": 3, "sources": [], "names": [], "mappings": "AA"}`,   // 2 fields
+	} {
+		_, err := compress.ParseSourceMap([]byte(data))
+		assert.NotNil(t, err, data)
+	}
+
+	// with the XSSI prefix
+	m, err := compress.ParseSourceMap([]byte(`)]}'{"version": 3, "sources": ["a.js"], "names": [], "mappings": "AAAA"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.js"}, m.Sources)
+}
+
+func TestChainSourceMap(t *testing.T) {
+	content := "let foo = 1;"
+	// a.js, compiled from a.ts:
+	// - 0:0 is a.ts 0:0
+	// - 0:4 is a.ts 1:2, named foo
+	// - 1:0 is a.ts 3:0
+	upstream, err := compress.ParseSourceMap([]byte(`{
+		"version": 3,
+		"file": "a.js",
+		"sourceRoot": "../src",
+		"sources": ["a.ts"],
+		"sourcesContent": ["let foo = 1;"],
+		"names": ["foo"],
+		"mappings": "AAAA,IACEA;AAEF"
+	}`))
+	assert.Nil(t, err)
+
+	// a.min.js, minified from a.js:
+	// - 0:0 is a.js 0:0
+	// - 0:3 is a.js 0:6, named x
+	// - 0:9 is a.js 1:0
+	// - 0:12 is a.js 5:0, which doesn't exist
+	// - 0:14 has no source
+	minified, err := compress.ParseSourceMap([]byte(`{
+		"version": 3,
+		"file": "a.min.js",
+		"sources": ["a.js"],
+		"names": ["x"],
+		"mappings": "AAAA,GAAMA,MACN,GAIA,E"
+	}`))
+	assert.Nil(t, err)
+
+	chained, err := compress.ChainSourceMap(minified, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, &compress.SourceMap{
+		Version:        3,
+		File:           "a.min.js",
+		SourceRoot:     "../src",
+		Sources:        []string{"a.ts"},
+		SourcesContent: []*string{&content},
+		Names:          []string{"foo"}, // the original name is preferred
+		// 0:0 is a.ts 0:0, 0:3 is a.ts 1:2 named foo, 0:9 is a.ts 3:0
+		Mappings: "AAAA,GACEA,MAEF",
+	}, chained)
+
+	// the intermediate names are kept when there are no original ones
+	upstream.Mappings = "AAAA,IACE;AAEF"
+	chained, err = compress.ChainSourceMap(minified, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x"}, chained.Names)
+	assert.Equal(t, "AAAA,GACEA,MAEF", chained.Mappings)
+}
+
+func TestRebaseSources(t *testing.T) {
+	m := &compress.SourceMap{Sources: []string{
+		"../../src/a.js",
+		"b.js",
+		"webpack://lib/c.js",
+		"/d.js",
+	}}
+	m.RebaseSources("dist/js", "dist")
+	assert.Equal(t, []string{
+		"../src/a.js",
+		"js/b.js",
+		"webpack://lib/c.js",
+		"/d.js",
+	}, m.Sources)
+
+	m.RebaseSources("dist", "dist/min/js")
+	assert.Equal(t, "../../../src/a.js", m.Sources[0])
+	assert.Equal(t, "../../js/b.js", m.Sources[1])
+
+	// the sources are resolved from the sourceRoot, which isn't moved
+	m = &compress.SourceMap{SourceRoot: "../src", Sources: []string{"a.js"}}
+	m.RebaseSources("dist/js", "dist")
+	assert.Equal(t, []string{"a.js"}, m.Sources)
+}
+
+func TestLoadSourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcemap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := `{"version": 3, "sources": ["a.js"], "names": [], "mappings": "AAAA"}`
+	assert.Nil(t, os.MkdirAll(path.Join(dir, "maps"), 0755))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "maps", "a b.js.map"), []byte(data), 0644))
+
+	file := path.Join(dir, "a.min.js")
+	m, mapFile, err := compress.LoadSourceMap(file, "maps/a%20b.js.map")
+	assert.Nil(t, err)
+	assert.Equal(t, path.Join(dir, "maps", "a b.js.map"), mapFile)
+	assert.Equal(t, []string{"a.js"}, m.Sources)
+
+	m, mapFile, err = compress.LoadSourceMap(file, "data:application/json;base64,"+base64.StdEncoding.EncodeToString([]byte(data)))
+	assert.Nil(t, err)
+	assert.Equal(t, "", mapFile)
+	assert.Equal(t, []string{"a.js"}, m.Sources)
+
+	for _, ref := range []string{"https://example.com/a.js.map", "/a.js.map", "missing.js.map"} {
+		_, _, err = compress.LoadSourceMap(file, ref)
+		assert.NotNil(t, err, ref)
+	}
+}
+
+func TestSetSourceMappingURL(t *testing.T) {
+	js := []byte("a();\n//# sourceMappingURL=old.js.map\nb();\n//@ sourceMappingURL=legacy.js.map")
+	assert.Equal(t, "legacy.js.map", compress.FindSourceMappingURL("a.js", js))
+	assert.Equal(t, "a();\nb();\n//# sourceMappingURL=a.min.js.map\n",
+		string(compress.SetSourceMappingURL("a.js", js, "a.min.js.map")))
+	assert.Equal(t, "a();\nb();\n", string(compress.SetSourceMappingURL("a.js", js, "")))
+
+	css := []byte("a{}\n/*# sourceMappingURL=old.css.map */")
+	assert.Equal(t, "old.css.map", compress.FindSourceMappingURL("a.css", css))
+	assert.Equal(t, "a{}\n/*# sourceMappingURL=a.min.css.map */\n",
+		string(compress.SetSourceMappingURL("a.css", css, "a.min.css.map")))
+
+	// a trailing newline is added
+	assert.Equal(t, "a();\n//# sourceMappingURL=a.js.map\n",
+		string(compress.SetSourceMappingURL("a.js", []byte("a();"), "a.js.map")))
+	assert.Equal(t, "", compress.FindSourceMappingURL("a.js", []byte("a();")))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cdnjs/tools/git"
+	"github.com/cdnjs/tools/packages"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fake GraphQL API, serving the tags in pages
+type fakeGitHub struct {
+	tags      []map[string]interface{}
+	remaining int
+	requests  int
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req git.GraphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	f.requests++
+
+	first := int(req.Variables["first"].(float64))
+	start := 0
+	if after, ok := req.Variables["after"].(string); ok {
+		start, _ = strconv.Atoi(after)
+	}
+	end := start + first
+	if end > len(f.tags) {
+		end = len(f.tags)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"rateLimit": map[string]interface{}{
+				"cost":      1,
+				"remaining": f.remaining,
+				"resetAt":   "2021-01-01T00:00:00Z",
+			},
+			"repository": map[string]interface{}{
+				"refs": map[string]interface{}{
+					"pageInfo": map[string]interface{}{
+						"hasNextPage": end < len(f.tags),
+						"endCursor":   strconv.Itoa(end),
+					},
+					"nodes": f.tags[start:end],
+				},
+			},
+		},
+	})
+}
+
+func commit(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"__typename":    "Commit",
+		"tarballUrl":    fmt.Sprintf("https://codeload.github.com/a/b/legacy.tar.gz/%d", i),
+		"committedDate": time.Date(2021, 1, 1, 0, 0, i, 0, time.UTC).Format(time.RFC3339),
+	}
+}
+
+func lightweightTag(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   fmt.Sprintf("v1.0.%d", i),
+		"target": commit(i),
+	}
+}
+
+func annotatedTag(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"name": fmt.Sprintf("v1.0.%d", i),
+		"target": map[string]interface{}{
+			"__typename": "Tag",
+			"target":     commit(i),
+		},
+	}
+}
+
+// the proxy is read from the environment once, by the first request
+var current *fakeGitHub
+
+func TestMain(m *testing.M) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.ServeHTTP(w, r)
+	}))
+	os.Setenv("HTTP_PROXY", server.URL)
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+func getVersions(fake *fakeGitHub, window string) ([]string, error) {
+	current = fake
+	git.GH_TAGS_WINDOW = window
+
+	target := "https://github.com/a/b"
+	versions, err := git.GetGitHubVersions(context.Background(), &packages.Autoupdate{
+		Target:         &target,
+		IgnoreVersions: []string{"*-ignored"},
+	})
+	names := make([]string, 0)
+	for _, v := range versions {
+		names = append(names, v.Version)
+	}
+	return names, err
+}
+
+func TestGetVersionsPaginated(t *testing.T) {
+	fake := &fakeGitHub{remaining: 5000}
+	expected := make([]string, 0)
+	for i := 0; i < 150; i++ {
+		if i%2 == 0 {
+			fake.tags = append(fake.tags, lightweightTag(i))
+		} else {
+			fake.tags = append(fake.tags, annotatedTag(i))
+		}
+		expected = append(expected, fmt.Sprintf("1.0.%d", i))
+	}
+	// tags of trees and ignored versions are skipped
+	fake.tags = append(fake.tags, map[string]interface{}{
+		"name":   "tree",
+		"target": map[string]interface{}{"__typename": "Tree"},
+	})
+	ignored := lightweightTag(150)
+	ignored["name"] = "v1.0.150-ignored"
+	fake.tags = append(fake.tags, ignored)
+
+	versions, err := getVersions(fake, "")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, versions)
+	assert.Equal(t, 2, fake.requests)
+
+	// only the most recent tags of the window
+	fake.requests = 0
+	versions, err = getVersions(fake, "120")
+	assert.Nil(t, err)
+	assert.Equal(t, expected[:120], versions)
+	assert.Equal(t, 2, fake.requests)
+}
+
+func TestGetVersionsRateLimited(t *testing.T) {
+	fake := &fakeGitHub{remaining: 0}
+	for i := 0; i < 150; i++ {
+		fake.tags = append(fake.tags, lightweightTag(i))
+	}
+
+	_, err := getVersions(fake, "")
+	rateLimitErr, ok := err.(git.RateLimitError)
+	assert.True(t, ok, "expected a RateLimitError, got %v", err)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), rateLimitErr.ResetAt)
+	assert.Equal(t, 1, fake.requests)
+}
+
+func TestGetVersionsInvalidDate(t *testing.T) {
+	tag := lightweightTag(0)
+	tag["target"].(map[string]interface{})["committedDate"] = "yesterday"
+	fake := &fakeGitHub{remaining: 5000, tags: []map[string]interface{}{tag}}
+
+	versions, err := getVersions(fake, "")
+	assert.NotNil(t, err)
+	assert.Empty(t, versions)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/cdnjs/tools/archive"
+	"github.com/cdnjs/tools/git"
+	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/version"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Name", "GIT_AUTHOR_EMAIL=Email",
+		"GIT_COMMITTER_NAME=Name", "GIT_COMMITTER_EMAIL=Email",
+		"GIT_COMMITTER_DATE=2021-01-01T00:00:00Z")
+	out, err := cmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+}
+
+// Creates a bare repository with a lightweight tag, an annotated tag, a
+// tag of a tree and an ignored tag.
+func createBareRepo(t *testing.T) string {
+	work, err := ioutil.TempDir("", "work")
+	assert.Nil(t, err)
+	defer os.RemoveAll(work)
+
+	runGit(t, work, "init", "--quiet")
+	assert.Nil(t, ioutil.WriteFile(path.Join(work, "a.js"), []byte("a"), 0644))
+	runGit(t, work, "add", "a.js")
+	runGit(t, work, "commit", "--quiet", "-m", "a")
+	runGit(t, work, "tag", "v1.0.0")
+
+	assert.Nil(t, ioutil.WriteFile(path.Join(work, "a.js"), []byte("b"), 0644))
+	runGit(t, work, "commit", "--quiet", "-am", "b")
+	runGit(t, work, "tag", "-a", "v1.1.0", "-m", "v1.1.0")
+	runGit(t, work, "tag", "v1.2.0-ignored")
+	runGit(t, work, "tag", "tree", "HEAD^{tree}")
+
+	bare, err := ioutil.TempDir("", "bare")
+	assert.Nil(t, err)
+	runGit(t, work, "clone", "--quiet", "--bare", work, bare)
+	return bare
+}
+
+func TestGetRemoteVersions(t *testing.T) {
+	bare := createBareRepo(t)
+	defer os.RemoveAll(bare)
+
+	// the test remote is a local path
+	defer func(allowed string) { version.GitAllowProtocol = allowed }(version.GitAllowProtocol)
+	version.GitAllowProtocol = "file"
+
+	versions, err := git.GetVersions(context.Background(), &packages.Autoupdate{
+		Target:         &bare,
+		IgnoreVersions: []string{"*-ignored"},
+	})
+	assert.Nil(t, err)
+
+	// the tag of a tree and the ignored tag aren't versions
+	var names []string
+	for _, v := range versions {
+		names = append(names, v.Version)
+	}
+	assert.ElementsMatch(t, []string{"1.0.0", "1.1.0"}, names)
+
+	contents := make(map[string]string)
+	for _, v := range versions {
+		assert.Equal(t, "git", v.Source)
+		assert.False(t, v.Date.IsZero())
+
+		assert.NotEmpty(t, v.Commit)
+
+		dest := path.Join(bare, v.FileName())
+		assert.Nil(t, version.Download(context.Background(), &v, dest))
+		assert.Equal(t, archive.TarGz, v.Format)
+
+		f, err := os.Open(dest)
+		assert.Nil(t, err)
+		err = archive.Inflate(f, func(name string, r io.Reader) error {
+			content, err := ioutil.ReadAll(r)
+			contents[v.Version+":"+name] = string(content)
+			return err
+		})
+		f.Close()
+		assert.Nil(t, err)
+	}
+
+	// the files are in a top-level directory, as in GitHub tarballs
+	repo := path.Base(bare)
+	assert.Equal(t, map[string]string{
+		"1.0.0:" + repo + "-v1.0.0/a.js": "a",
+		"1.1.0:" + repo + "-v1.1.0/a.js": "b",
+	}, contents)
+}
+
+func TestGetRemoteVersionsRefused(t *testing.T) {
+	bare := createBareRepo(t)
+	defer os.RemoveAll(bare)
+
+	for _, remote := range []string{
+		// local paths and commands aren't allowed protocols
+		bare,
+		"file://" + bare,
+		"ext::sh -c touch% " + path.Join(bare, "pwned"),
+		// options aren't parsed from the remote
+		"--upload-pack=touch " + path.Join(bare, "pwned"),
+	} {
+		remote := remote
+		_, err := git.GetVersions(context.Background(), &packages.Autoupdate{Target: &remote})
+		assert.NotNil(t, err, remote)
+	}
+	_, err := os.Stat(path.Join(bare, "pwned"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/cdnjs/tools/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Synthetic corpus of patterns in the style of fileMap entries, with the
+// files listed for them by node-glob 7.2.3 with the nodir option, the
+// version /glob/index.js used. The leading `./` it keeps is dropped.
+type corpus struct {
+	Files    []string          `json:"files"`
+	Symlinks map[string]string `json:"symlinks"`
+	Cases    []struct {
+		Pattern  string   `json:"pattern"`
+		Expected []string `json:"expected"`
+	} `json:"cases"`
+}
+
+func readCorpus(t *testing.T) corpus {
+	bytes, err := ioutil.ReadFile(path.Join("testdata", "corpus.json"))
+	assert.Nil(t, err)
+
+	var c corpus
+	assert.Nil(t, json.Unmarshal(bytes, &c))
+	return c
+}
+
+func createTree(t *testing.T, c corpus) string {
+	dir, err := ioutil.TempDir("", "glob")
+	assert.Nil(t, err)
+
+	for _, file := range c.Files {
+		absfile := path.Join(dir, file)
+		assert.Nil(t, os.MkdirAll(path.Dir(absfile), 0755))
+		assert.Nil(t, ioutil.WriteFile(absfile, []byte(file), 0644))
+	}
+	for link, target := range c.Symlinks {
+		assert.Nil(t, os.Symlink(target, path.Join(dir, link)))
+	}
+	return dir
+}
+
+func TestGlobCorpus(t *testing.T) {
+	c := readCorpus(t)
+	dir := createTree(t, c)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range c.Cases {
+		tc := tc
+		t.Run(tc.Pattern, func(t *testing.T) {
+			list, err := util.ListFilesGlob(context.Background(), dir, tc.Pattern)
+			assert.Nil(t, err)
+			assert.Equal(t, append([]string{}, tc.Expected...), list)
+		})
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.js", "a.js", true},
+		{"*.js", "dir/a.js", false},
+		{"*.js", ".a.js", false},
+		{"**/*.js", "a/b/c.js", true},
+		{"**/*.js", "a/.b/c.js", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/{b,c/{d,e}}.js", "a/c/e.js", true},
+		{"a/{b,c/{d,e}}.js", "a/c.js", false},
+		// a leading ! is literal
+		{"!*.map", "a.js", false},
+		{"!*.map", "!a.map", true},
+		{"*.!(map)", "a.js.map", true},
+		{"!(*.map)", "a.js.map", false},
+		{"!(*.map)", "a.js", true},
+		{"*(a|b)c", "ababc", true},
+		{"+(a|b)c", "c", false},
+		{"[^a]*", "abc", false},
+		{"[]]", "]", true},
+		{"a{1..9..4}.js", "a5.js", true},
+		{"a{9..1..-4}.js", "a5.js", true},
+		{"a{1..3..-9223372036854775808}.js", "a1.js", true},
+		{"{a..c}.js", "b.js", true},
+		// no POSIX classes, [[:alpha:] is a class followed by a ]
+		{"[[:alpha:]].js", "a.js", false},
+		{"[[:alpha:]].js", "a].js", true},
+	}
+
+	for _, tc := range cases {
+		g, err := util.CompileGlob(tc.pattern)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.match, g.Match(tc.name), "%s ~ %s", tc.pattern, tc.name)
+	}
+}
+
+func TestGlobInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		// too many expansions
+		"{1..9223372036854775807}",
+		"{-9223372036854775808..9223372036854775807}",
+		"{0..100000..2}",
+		"{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}",
+	} {
+		_, err := util.CompileGlob(pattern)
+		assert.NotNil(t, err, pattern)
+	}
+}
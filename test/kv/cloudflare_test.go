@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cdnjs/tools/kv"
+	"github.com/cdnjs/tools/util"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fake Workers KV API, which fails the first requests with the given
+// responses
+type fakeAPI struct {
+	requests []*http.Request
+	bodies   []string
+	failures []failure
+}
+
+type failure struct {
+	status     int
+	code       int
+	retryAfter string
+}
+
+const namespacePath = "/accounts/account/storage/kv/namespaces/namespace/"
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, string(body))
+
+	if len(f.failures) > 0 {
+		fail := f.failures[0]
+		f.failures = f.failures[1:]
+		if fail.retryAfter != "" {
+			w.Header().Set("Retry-After", fail.retryAfter)
+		}
+		w.WriteHeader(fail.status)
+		fmt.Fprintf(w, `{"success": false, "errors": [{"code": %d, "message": "failure"}]}`, fail.code)
+		return
+	}
+
+	switch r.URL.EscapedPath() {
+	case namespacePath + "values/a-happy-tyler%2F1.0.0":
+		w.Write([]byte("value"))
+	case namespacePath + "keys":
+		if r.URL.Query().Get("cursor") == "" {
+			w.Write([]byte(`{"success": true, "result": [{"name": "a/1.0.0/a.js", "metadata": {"etag": "1"}}], "result_info": {"cursor": "next"}}`))
+		} else {
+			w.Write([]byte(`{"success": true, "result": [{"name": "a/1.0.0/b.js"}], "result_info": {"cursor": ""}}`))
+		}
+	case namespacePath + "bulk":
+		w.Write([]byte(`{"success": true, "errors": []}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"success": false, "errors": [{"code": 10009, "message": "get: 'key not found'"}]}`))
+	}
+}
+
+func newCloudflareStore(fake *fakeAPI) (*kv.CloudflareStore, func()) {
+	server := httptest.NewServer(fake)
+	client := kv.NewClient("account", "token")
+	client.BaseURL = server.URL
+	client.Backoff = kv.Backoff{Attempts: 3, Base: time.Millisecond, Max: time.Minute}
+	return kv.NewCloudflareStore(client, "namespace"), server.Close
+}
+
+func TestCloudflareRead(t *testing.T) {
+	fake := &fakeAPI{}
+	store, close := newCloudflareStore(fake)
+	defer close()
+
+	value, err := store.Read(context.Background(), "a-happy-tyler/1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, "Bearer token", fake.requests[0].Header.Get("Authorization"))
+
+	_, err = store.Read(context.Background(), "a-sad-tyler/1.0.0")
+	assert.IsType(t, kv.KeyNotFoundError{}, err)
+}
+
+func TestCloudflareErrors(t *testing.T) {
+	fake := &fakeAPI{failures: []failure{{status: http.StatusForbidden, code: 10000}}}
+	store, close := newCloudflareStore(fake)
+	defer close()
+
+	_, err := store.Read(context.Background(), "a-happy-tyler/1.0.0")
+	assert.IsType(t, kv.AuthError{}, err)
+
+	// not retried
+	fake.failures = []failure{{status: http.StatusBadRequest, code: 10001}}
+	err = store.WriteBulk(context.Background(), []*kv.Pair{{Key: "a", Value: []byte("a")}})
+	kvErr, ok := err.(kv.Error)
+	assert.True(t, ok, "expected an Error, got %v", err)
+	assert.Equal(t, http.StatusBadRequest, kvErr.Status)
+	assert.Equal(t, []kv.APIError{{Code: 10001, Message: "failure"}}, kvErr.Errors)
+	assert.False(t, kvErr.Temporary())
+	assert.Len(t, fake.requests, 2)
+}
+
+func TestCloudflareRetry(t *testing.T) {
+	fake := &fakeAPI{failures: []failure{
+		{status: http.StatusBadGateway},
+		{status: http.StatusTooManyRequests, retryAfter: "1"},
+	}}
+	store, close := newCloudflareStore(fake)
+	defer close()
+
+	start := time.Now()
+	value, err := store.Read(context.Background(), "a-happy-tyler/1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Len(t, fake.requests, 3)
+	assert.True(t, time.Since(start) >= time.Second, "Retry-After ignored")
+
+	// the attempts are exhausted
+	fake.failures = []failure{{status: 500}, {status: 500}, {status: 500}}
+	_, err = store.Read(context.Background(), "a-happy-tyler/1.0.0")
+	assert.Equal(t, 500, err.(kv.Error).Status)
+}
+
+func TestCloudflareRetryCancelled(t *testing.T) {
+	fake := &fakeAPI{failures: []failure{{status: http.StatusServiceUnavailable}}}
+	store, close := newCloudflareStore(fake)
+	defer close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fake.failures[0].retryAfter = "30"
+	_, err := store.Read(ctx, "a-happy-tyler/1.0.0")
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
+func TestCloudflareWriteAndList(t *testing.T) {
+	fake := &fakeAPI{}
+	store, close := newCloudflareStore(fake)
+	defer close()
+
+	err := store.WriteBulk(context.Background(), []*kv.Pair{
+		{Key: "a/1.0.0/a.js", Value: []byte("a"), Meta: &kv.FileMetadata{ETag: "1"}},
+	})
+	assert.Nil(t, err)
+	var pairs []map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(fake.bodies[0]), &pairs))
+	assert.Equal(t, []map[string]interface{}{{
+		"key":      "a/1.0.0/a.js",
+		"value":    "YQ==",
+		"base64":   true,
+		"metadata": map[string]interface{}{"etag": "1"},
+	}}, pairs)
+
+	keys, err := store.ListByPrefix(context.Background(), "a/")
+	assert.Nil(t, err)
+	assert.Equal(t, []kv.Key{
+		{Name: "a/1.0.0/a.js", Meta: &kv.FileMetadata{ETag: "1"}},
+		{Name: "a/1.0.0/b.js"},
+	}, keys)
+	assert.Equal(t, "a/", fake.requests[1].URL.Query().Get("prefix"))
+}
+
+// store failing the writes after the first one
+type failingStore struct {
+	kv.Store
+	writes int
+}
+
+func (s *failingStore) WriteBulk(ctx context.Context, pairs []*kv.Pair) error {
+	s.writes++
+	if s.writes > 1 {
+		return kv.Error{Status: http.StatusInternalServerError}
+	}
+	return s.Store.WriteBulk(ctx, pairs)
+}
+
+// creates write requests for 3 bulks
+func writeRequests() []kv.WriteRequest {
+	var reqs []kv.WriteRequest
+	for i := int64(0); i < 2*util.MaxBulkKeys+1; i++ {
+		key := fmt.Sprintf("a/1.0.0/%d.js", i)
+		reqs = append(reqs, &kv.ConsumableWriteRequest{Key: key, Name: key, Value: []byte("a")})
+	}
+	return reqs
+}
+
+func TestBulkResults(t *testing.T) {
+	store := &failingStore{Store: kv.NewMemoryStore()}
+	reqs := writeRequests()
+
+	results, err := kv.EncodeAndWriteKVBulk(context.Background(), store, reqs, kv.BulkOptions{Concurrency: 1})
+	assert.NotNil(t, err)
+	assert.Len(t, results, 3)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, kv.Error{Status: http.StatusInternalServerError}, results[1].Err)
+	assert.Equal(t, kv.ErrSkipped, results[2].Err)
+	assert.Len(t, results[0].Keys, int(util.MaxBulkKeys))
+	assert.Len(t, results[2].Keys, 1)
+
+	// only the first bulk landed
+	assert.Equal(t, results[0].Names, kv.Written(results))
+	keys, err := store.ListByPrefix(context.Background(), "a/")
+	assert.Nil(t, err)
+	assert.Len(t, keys, int(util.MaxBulkKeys))
+}
+
+func TestBulkProgress(t *testing.T) {
+	store := kv.NewMemoryStore()
+	reqs := writeRequests()
+
+	var progress []kv.Progress
+	results, err := kv.EncodeAndWriteKVBulk(context.Background(), store, reqs, kv.BulkOptions{
+		Concurrency: 2,
+		Progress:    func(p kv.Progress) { progress = append(progress, p) },
+	})
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+	assert.Len(t, kv.Written(results), len(reqs))
+
+	assert.Len(t, progress, 3)
+	last := progress[len(progress)-1]
+	assert.Equal(t, 3, last.Bulks)
+	assert.Equal(t, len(reqs), last.Keys)
+	assert.Equal(t, int64(4*len(reqs)), last.Bytes) // "a" is "YQ==" in base64
+
+	// the values are released once written
+	for _, req := range reqs {
+		assert.Nil(t, req.(*kv.ConsumableWriteRequest).Value)
+	}
+	keys, err := store.ListByPrefix(context.Background(), "a/")
+	assert.Nil(t, err)
+	assert.Len(t, keys, len(reqs))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cdnjs/tools/kv"
+	"github.com/cdnjs/tools/packages"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const happyTyler = `{
+	"name": "a-happy-tyler",
+	"description": "Tyler is happy.",
+	"keywords": ["tyler", "happy"],
+	"version": "1.1.0",
+	"filename": "happy.min.js"
+}`
+
+// writes the versions of a-happy-tyler in KV
+func publish(t *testing.T, ns *kv.Namespaces, files map[string][]string) {
+	ctx := context.Background()
+	for version, names := range files {
+		var reqs, sris []kv.WriteRequest
+		for _, name := range names {
+			key := "a-happy-tyler/" + version + "/" + name
+			reqs = append(reqs, &kv.ConsumableWriteRequest{Key: key, Name: key, Value: []byte(name)})
+			sris = append(sris, &kv.MetaWriteRequest{Key: key, Name: key, Meta: &kv.FileMetadata{SRI: "sha512-" + name}})
+		}
+		sris = append(sris, &kv.ConsumableWriteRequest{Key: "a-happy-tyler/" + version, Value: []byte("{}")})
+		_, err := kv.EncodeAndWriteKVBulk(ctx, ns.Files, reqs, kv.BulkOptions{})
+		assert.Nil(t, err)
+		_, err = kv.EncodeAndWriteKVBulk(ctx, ns.SRIs, sris, kv.BulkOptions{})
+		assert.Nil(t, err)
+		_, err = kv.UpdateKVVersion(ctx, ns.Versions, "a-happy-tyler", version, names)
+		assert.Nil(t, err)
+
+		var pkg packages.Package
+		assert.Nil(t, json.Unmarshal([]byte(happyTyler), &pkg))
+		_, _, err = kv.UpdateAggregatedMetadata(ctx, ns.AggregatedMetadata, &pkg, version,
+			packages.Asset{Version: version, Files: names})
+		assert.Nil(t, err)
+	}
+
+	var pkg packages.Package
+	assert.Nil(t, json.Unmarshal([]byte(happyTyler), &pkg))
+	assert.Nil(t, kv.UpdateKVPackage(ctx, ns.Packages, &pkg))
+}
+
+func keys(t *testing.T, store kv.Store) []string {
+	list, err := store.ListByPrefix(context.Background(), "")
+	assert.Nil(t, err)
+	names := make([]string, 0)
+	for _, k := range list {
+		names = append(names, k.Name)
+	}
+	return names
+}
+
+func TestDeleteVersion(t *testing.T) {
+	ctx := context.Background()
+	ns := kv.NewMemoryNamespaces()
+	publish(t, ns, map[string][]string{
+		"1.0.0":      {"tyler.min.js"},
+		"1.1.0":      {"happy.min.js", "happy.css"},
+		"1.1.0-beta": {"happy.min.js"},
+	})
+
+	// nothing changes in a dry run
+	d, err := kv.DeleteVersion(ctx, ns, "a-happy-tyler", "1.1.0", true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a-happy-tyler/1.1.0/happy.css", "a-happy-tyler/1.1.0/happy.min.js"}, d.Files)
+	assert.Len(t, keys(t, ns.Files), 4)
+	assert.Equal(t, []string{"a-happy-tyler"}, keys(t, ns.Packages))
+
+	d, err = kv.DeleteVersion(ctx, ns, "a-happy-tyler", "1.1.0", false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"a-happy-tyler/1.1.0/happy.css",
+		"a-happy-tyler/1.1.0/happy.min.js",
+		"a-happy-tyler/1.1.0/happy.css",
+		"a-happy-tyler/1.1.0/happy.min.js",
+		"a-happy-tyler/1.1.0",
+		"a-happy-tyler/1.1.0",
+	}, d.Keys())
+
+	// the versions with the same prefix are kept
+	assert.Equal(t, []string{"a-happy-tyler/1.0.0/tyler.min.js", "a-happy-tyler/1.1.0-beta/happy.min.js"}, keys(t, ns.Files))
+	assert.Equal(t, []string{
+		"a-happy-tyler/1.0.0",
+		"a-happy-tyler/1.0.0/tyler.min.js",
+		"a-happy-tyler/1.1.0-beta",
+		"a-happy-tyler/1.1.0-beta/happy.min.js",
+	}, keys(t, ns.SRIs))
+	assert.Equal(t, []string{"a-happy-tyler/1.0.0", "a-happy-tyler/1.1.0-beta"}, keys(t, ns.Versions))
+
+	// the latest stable version and its filename
+	pkg, err := kv.GetPackage(ctx, ns.Packages, "a-happy-tyler")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0.0", *pkg.Version)
+	assert.Equal(t, "tyler.min.js", *pkg.Filename)
+
+	aggregated := d.AggregatedMetadata
+	assert.Equal(t, "1.0.0", *aggregated.Version)
+	assert.False(t, aggregated.HasVersion("1.1.0"))
+	assert.True(t, aggregated.HasVersion("1.1.0-beta"))
+
+	// the tombstone keeps the version from being published again
+	assert.Equal(t, "yanked/a-happy-tyler/1.1.0", d.Tombstone)
+	// apart from the keys under the name of the package
+	assert.Equal(t, []string{"a-happy-tyler", "yanked/a-happy-tyler/1.1.0"}, keys(t, ns.Packages))
+	yanked, err := kv.GetYankedVersions(ctx, ns, "a-happy-tyler")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1.1.0"}, yanked)
+	yanked, err = kv.GetYankedVersions(ctx, ns, "a-happy")
+	assert.Nil(t, err)
+	assert.Empty(t, yanked)
+
+	// already deleted
+	_, err = kv.DeleteVersion(ctx, ns, "a-happy-tyler", "1.1.0", false)
+	assert.NotNil(t, err)
+}
+
+func TestDeleteVersionInvalid(t *testing.T) {
+	ctx := context.Background()
+	ns := kv.NewMemoryNamespaces()
+	publish(t, ns, map[string][]string{
+		"1.0.0": {"tyler.min.js"},
+	})
+
+	// none of them may refer to the package or to another version
+	for _, version := range []string{"", ".", "..", "1.0.0/..", "../a-sad-tyler", "1.0.0/"} {
+		_, err := kv.DeleteVersion(ctx, ns, "a-happy-tyler", version, false)
+		assert.NotNil(t, err, "%q", version)
+	}
+	assert.Len(t, keys(t, ns.Files), 1)
+	assert.Len(t, keys(t, ns.Versions), 1)
+}
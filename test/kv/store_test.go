@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cdnjs/tools/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runs a test against each local store
+func eachStore(t *testing.T, test func(t *testing.T, store kv.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, kv.NewMemoryStore())
+	})
+	t.Run("dir", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "kv")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		store, err := kv.NewDirStore(dir)
+		assert.Nil(t, err)
+		test(t, store)
+	})
+}
+
+func TestStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, store kv.Store) {
+		ctx := context.Background()
+		meta := &kv.FileMetadata{ETag: "1"}
+		err := store.WriteBulk(ctx, []*kv.Pair{
+			{Key: "a/1.0.0", Value: []byte("version")},
+			{Key: "a/1.0.0/a.js", Value: []byte("a"), Meta: meta},
+			{Key: "b/1.0.0/b.js", Value: []byte("b")},
+		})
+		assert.Nil(t, err)
+
+		value, err := store.Read(ctx, "a/1.0.0/a.js")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("a"), value)
+
+		_, err = store.Read(ctx, "a/2.0.0")
+		assert.IsType(t, kv.KeyNotFoundError{}, err)
+
+		keys, err := store.ListByPrefix(ctx, "a/")
+		assert.Nil(t, err)
+		assert.Equal(t, []kv.Key{
+			{Name: "a/1.0.0"},
+			{Name: "a/1.0.0/a.js", Meta: meta},
+		}, keys)
+
+		// the metadata is replaced
+		assert.Nil(t, store.WriteBulk(ctx, []*kv.Pair{{Key: "a/1.0.0/a.js", Value: []byte("c")}}))
+		keys, err = store.ListByPrefix(ctx, "a/1.0.0/")
+		assert.Nil(t, err)
+		assert.Equal(t, []kv.Key{{Name: "a/1.0.0/a.js"}}, keys)
+
+		assert.Nil(t, store.Delete(ctx, []string{"a/1.0.0", "a/1.0.0/a.js", "c"}))
+		keys, err = store.ListByPrefix(ctx, "")
+		assert.Nil(t, err)
+		assert.Equal(t, []kv.Key{{Name: "b/1.0.0/b.js"}}, keys)
+	})
+}
+
+func TestVersions(t *testing.T) {
+	eachStore(t, func(t *testing.T, store kv.Store) {
+		ctx := context.Background()
+		for _, v := range []string{"1.0.0", "1.1.0"} {
+			_, err := kv.UpdateKVVersion(ctx, store, "a-happy-tyler", v, []string{"a.js"})
+			assert.Nil(t, err)
+		}
+		_, err := kv.UpdateKVVersion(ctx, store, "a-happy-tyler-2", "2.0.0", []string{"a.js"})
+		assert.Nil(t, err)
+
+		versions, err := kv.GetVersions(ctx, store, "a-happy-tyler")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0", "1.1.0"}, versions)
+
+		files, err := kv.GetVersion(ctx, store, "a-happy-tyler/1.1.0")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a.js"}, files)
+	})
+}
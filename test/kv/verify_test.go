@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cdnjs/tools/kv"
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func types(inconsistencies []kv.Inconsistency) map[string][]string {
+	m := make(map[string][]string)
+	for _, i := range inconsistencies {
+		m[i.Type] = append(m[i.Type], i.Key)
+	}
+	return m
+}
+
+// writes the integrity manifest of a version, as kv-pump does last
+func writeIntegrity(t *testing.T, ns *kv.Namespaces, version string, names ...string) {
+	m := sri.NewManifest()
+	for _, name := range names {
+		integrity, err := sri.CalculateIntegrity(strings.NewReader(name))
+		assert.Nil(t, err)
+		m.Add(name, integrity)
+	}
+	data, err := json.Marshal(m)
+	assert.Nil(t, err)
+	assert.Nil(t, ns.SRIs.WriteBulk(context.Background(), []*kv.Pair{
+		{Key: "a-happy-tyler/" + version, Value: data},
+	}))
+}
+
+func TestVerifyPackage(t *testing.T) {
+	ctx := context.Background()
+	ns := kv.NewMemoryNamespaces()
+	publish(t, ns, map[string][]string{
+		"1.0.0":      {"tyler.min.js"},
+		"1.1.0":      {"happy.min.js", "happy.css"},
+		"1.1.0-beta": {"happy.min.js"},
+	})
+
+	inconsistencies, err := kv.VerifyPackage(ctx, ns, "a-happy-tyler", false)
+	assert.Nil(t, err)
+	assert.Empty(t, inconsistencies)
+
+	// the compressed variants are served under the original name
+	assert.Nil(t, ns.Files.WriteBulk(ctx, []*kv.Pair{
+		{Key: "a-happy-tyler/1.1.0/happy.css.br", Value: []byte("br")},
+	}))
+	// the files of a version were deleted halfway
+	assert.Nil(t, ns.Files.Delete(ctx, []string{"a-happy-tyler/1.1.0-beta/happy.min.js"}))
+	// a version was published, but its entry was lost
+	assert.Nil(t, ns.Files.WriteBulk(ctx, []*kv.Pair{
+		{Key: "a-happy-tyler/2.0.0/happy.min.js.gz", Value: []byte("gz")},
+		{Key: "a-happy-tyler/2.0.0/happy.min.js.br", Value: []byte("br")},
+	}))
+	writeIntegrity(t, ns, "2.0.0", "happy.min.js")
+	// the files of versions were written, but kv-pump stopped before their
+	// integrity manifest, or the manifest lists other files
+	assert.Nil(t, ns.Files.WriteBulk(ctx, []*kv.Pair{
+		{Key: "a-happy-tyler/2.1.0/tyler.js", Value: []byte("a")},
+		{Key: "a-happy-tyler/2.2.0/tyler.js", Value: []byte("a")},
+		{Key: "a-happy-tyler/2.2.0/tyler.js.br", Value: []byte("br")},
+	}))
+	writeIntegrity(t, ns, "2.2.0", "tyler.js", "tyler.css")
+
+	inconsistencies, err = kv.VerifyPackage(ctx, ns, "a-happy-tyler", false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		kv.VersionWithoutFiles: {"a-happy-tyler/1.1.0-beta"},
+		kv.FilesWithoutVersion: {"a-happy-tyler/2.0.0"},
+		kv.OrphanFile: {
+			"a-happy-tyler/2.1.0/tyler.js",
+			"a-happy-tyler/2.2.0/tyler.js",
+			"a-happy-tyler/2.2.0/tyler.js.br",
+		},
+		kv.AggregateExtraVersion:   {"a-happy-tyler/1.1.0-beta"},
+		kv.AggregateMissingVersion: {"a-happy-tyler/2.0.0"},
+		kv.StaleLatestVersion:      {"a-happy-tyler"},
+		kv.SRIForMissingFile: {
+			"a-happy-tyler/1.1.0-beta",
+			"a-happy-tyler/1.1.0-beta/happy.min.js",
+		},
+	}, types(inconsistencies))
+	for _, i := range inconsistencies {
+		assert.False(t, i.Repaired)
+	}
+	assert.Len(t, keys(t, ns.Versions), 3) // nothing changed
+	assert.Len(t, keys(t, ns.Files), 9)
+
+	inconsistencies, err = kv.VerifyPackage(ctx, ns, "a-happy-tyler", true)
+	assert.Nil(t, err)
+	assert.Len(t, inconsistencies, 10)
+	for _, i := range inconsistencies {
+		// kv-pump may still be publishing the orphans
+		assert.Equal(t, i.Type != kv.OrphanFile, i.Repaired, i.String())
+	}
+
+	inconsistencies, err = kv.VerifyPackage(ctx, ns, "a-happy-tyler", false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		kv.OrphanFile: {
+			"a-happy-tyler/2.1.0/tyler.js",
+			"a-happy-tyler/2.2.0/tyler.js",
+			"a-happy-tyler/2.2.0/tyler.js.br",
+		},
+	}, types(inconsistencies))
+
+	assert.Equal(t, []string{"a-happy-tyler/1.0.0", "a-happy-tyler/1.1.0", "a-happy-tyler/2.0.0"}, keys(t, ns.Versions))
+	assert.Equal(t, []string{
+		"a-happy-tyler/1.0.0/tyler.min.js",
+		"a-happy-tyler/1.1.0/happy.css",
+		"a-happy-tyler/1.1.0/happy.css.br",
+		"a-happy-tyler/1.1.0/happy.min.js",
+		"a-happy-tyler/2.0.0/happy.min.js.br",
+		"a-happy-tyler/2.0.0/happy.min.js.gz",
+		"a-happy-tyler/2.1.0/tyler.js",
+		"a-happy-tyler/2.2.0/tyler.js",
+		"a-happy-tyler/2.2.0/tyler.js.br",
+	}, keys(t, ns.Files))
+	// nor their SRIs
+	assert.Contains(t, keys(t, ns.SRIs), "a-happy-tyler/2.2.0")
+	files, err := kv.GetVersion(ctx, ns.Versions, "a-happy-tyler/2.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"happy.min.js"}, files)
+	pkg, err := kv.GetPackage(ctx, ns.Packages, "a-happy-tyler")
+	assert.Nil(t, err)
+	assert.Equal(t, "2.0.0", *pkg.Version)
+}
+
+func TestVerifyMissingPackage(t *testing.T) {
+	ctx := context.Background()
+	ns := kv.NewMemoryNamespaces()
+	assert.Nil(t, ns.Files.WriteBulk(ctx, []*kv.Pair{
+		{Key: "a-happy-tyler/1.0.0/happy.min.js", Value: []byte("a")},
+	}))
+	writeIntegrity(t, ns, "1.0.0", "happy.min.js")
+
+	// the package metadata can't be recreated
+	inconsistencies, err := kv.VerifyPackage(ctx, ns, "a-happy-tyler", true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		kv.FilesWithoutVersion: {"a-happy-tyler/1.0.0"},
+		kv.MissingAggregate:    {"a-happy-tyler"},
+		kv.MissingPackage:      {"a-happy-tyler"},
+	}, types(inconsistencies))
+	assert.False(t, inconsistencies[1].Repaired)
+	assert.False(t, inconsistencies[2].Repaired)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cdnjs/tools/npm"
+	"github.com/cdnjs/tools/packages"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const packument = `{
+	"name": "a-happy-tyler",
+	"dist-tags": { "latest": "1.1.0" },
+	"versions": {
+		"1.0.0": {
+			"version": "1.0.0",
+			"dist": {
+				"tarball": "https://registry.npmjs.org/a-happy-tyler/-/a-happy-tyler-1.0.0.tgz",
+				"shasum": "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+			}
+		},
+		"1.1.0": {
+			"version": "1.1.0",
+			"dist": {
+				"tarball": "https://registry.npmjs.org/a-happy-tyler/-/a-happy-tyler-1.1.0.tgz",
+				"integrity": "sha512-z4PhNX7vuL3xVChQ1m2AB9Yg5AULVxXcg/SpIdNs6c5H0NE8XYXysP+DGNKHfuwvY7kxvUdBeoGlODJ6+SfaPg==",
+				"shasum": "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+			}
+		},
+		"2.0.0-no-time": {
+			"version": "2.0.0-no-time",
+			"dist": {
+				"tarball": "https://registry.npmjs.org/a-happy-tyler/-/a-happy-tyler-2.0.0-no-time.tgz"
+			}
+		}
+	},
+	"time": {
+		"created": "2020-01-01T00:00:00.000Z",
+		"1.0.0": "2020-01-01T00:00:00.000Z",
+		"1.1.0": "2020-02-01T00:00:00.000Z",
+		"unpublished": { "time": "2020-03-01T00:00:00.000Z" }
+	}
+}`
+
+// fake registry, which serves a-happy-tyler with an ETag
+type fakeRegistry struct {
+	requests []*http.Request
+	status   int
+	header   http.Header
+	body     string
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r)
+	for k, v := range f.header {
+		w.Header()[k] = v
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	if r.URL.Path != "/a-happy-tyler" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"1"`)
+	if r.Header.Get("If-None-Match") == `"1"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body := f.body
+	if body == "" {
+		body = packument
+	}
+	w.Write([]byte(body))
+}
+
+func newClient(fake *fakeRegistry) (*npm.Client, func()) {
+	server := httptest.NewServer(fake)
+	client := npm.NewClient()
+	client.Registry = server.URL
+	return client, server.Close
+}
+
+func autoupdate(target string) *packages.Autoupdate {
+	return &packages.Autoupdate{Target: &target}
+}
+
+func TestGetVersions(t *testing.T) {
+	fake := &fakeRegistry{}
+	client, close := newClient(fake)
+	defer close()
+
+	versions, latest, err := client.GetVersions(context.Background(), autoupdate("a-happy-tyler"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1.1.0", *latest)
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "1.0.0", versions[0].Version)
+	assert.Equal(t, "https://registry.npmjs.org/a-happy-tyler/-/a-happy-tyler-1.0.0.tgz", versions[0].Tarball)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), versions[0].Date)
+	assert.Equal(t, "1.1.0", versions[1].Version)
+
+	// the integrity of old versions comes from their shasum
+	assert.Equal(t, "sha1-2jmj7l5rSw0yVb/vlWAYkK/YBwk=", versions[0].Integrity)
+	assert.Equal(t, "sha512-z4PhNX7vuL3xVChQ1m2AB9Yg5AULVxXcg/SpIdNs6c5H0NE8XYXysP+DGNKHfuwvY7kxvUdBeoGlODJ6+SfaPg==", versions[1].Integrity)
+
+	// the full metadata has the times of the versions
+	assert.Equal(t, "application/json", fake.requests[0].Header.Get("Accept"))
+
+	// revalidated with the ETag
+	again, _, err := client.GetVersions(context.Background(), autoupdate("a-happy-tyler"))
+	assert.Nil(t, err)
+	assert.Len(t, again, 2)
+	assert.Equal(t, `"1"`, fake.requests[1].Header.Get("If-None-Match"))
+}
+
+func TestCacheEviction(t *testing.T) {
+	fake := &fakeRegistry{}
+	client, close := newClient(fake)
+	defer close()
+	// room for one of the full and abbreviated metadata
+	client.CacheSize = len(packument)
+
+	ctx := context.Background()
+	for _, abbreviated := range []bool{false, true, false, false} {
+		_, err := client.Packument(ctx, "a-happy-tyler", abbreviated)
+		assert.Nil(t, err)
+	}
+	// the full metadata was evicted by the abbreviated one, then cached again
+	assert.Len(t, fake.requests, 4)
+	assert.Equal(t, "", fake.requests[1].Header.Get("If-None-Match"))
+	assert.Equal(t, "", fake.requests[2].Header.Get("If-None-Match"))
+	assert.Equal(t, `"1"`, fake.requests[3].Header.Get("If-None-Match"))
+
+	// nothing is cached without room
+	client.CacheSize = len(packument) - 1
+	_, err := client.Packument(ctx, "a-happy-tyler", true)
+	assert.Nil(t, err)
+	_, err = client.Packument(ctx, "a-happy-tyler", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "", fake.requests[5].Header.Get("If-None-Match"))
+}
+
+func TestPackument(t *testing.T) {
+	fake := &fakeRegistry{}
+	client, close := newClient(fake)
+	defer close()
+
+	p, err := client.Packument(context.Background(), "a-happy-tyler", true)
+	assert.Nil(t, err)
+	assert.Contains(t, fake.requests[0].Header.Get("Accept"), "application/vnd.npm.install-v1+json")
+
+	dist := p.Versions["1.1.0"].Dist
+	assert.Equal(t, "sha512-z4PhNX7vuL3xVChQ1m2AB9Yg5AULVxXcg/SpIdNs6c5H0NE8XYXysP+DGNKHfuwvY7kxvUdBeoGlODJ6+SfaPg==", dist.Integrity)
+	assert.Equal(t, "da39a3ee5e6b4b0d3255bfef95601890afd80709", dist.Shasum)
+}
+
+func TestExists(t *testing.T) {
+	client, close := newClient(&fakeRegistry{})
+	defer close()
+
+	exists, err := client.Exists(context.Background(), "a-happy-tyler")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	exists, err = client.Exists(context.Background(), "a-sad-tyler")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestErrors(t *testing.T) {
+	client, close := newClient(&fakeRegistry{})
+	_, _, err := client.GetVersions(context.Background(), autoupdate("a-sad-tyler"))
+	assert.Equal(t, npm.NotFoundError{Package: "a-sad-tyler"}, err)
+	close()
+
+	client, close = newClient(&fakeRegistry{
+		status: http.StatusTooManyRequests,
+		header: http.Header{"Retry-After": []string{"120"}},
+	})
+	_, _, err = client.GetVersions(context.Background(), autoupdate("a-happy-tyler"))
+	assert.Equal(t, npm.RateLimitError{RetryAfter: 2 * time.Minute}, err)
+	close()
+
+	client, close = newClient(&fakeRegistry{body: `{"versions": []}`})
+	_, _, err = client.GetVersions(context.Background(), autoupdate("a-happy-tyler"))
+	_, ok := err.(npm.MalformedError)
+	assert.True(t, ok, "expected a MalformedError, got %v", err)
+	close()
+}
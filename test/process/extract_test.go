@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+	"github.com/cdnjs/tools/manifest"
+	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/process"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type entry struct {
+	header  tar.Header
+	content string
+}
+
+func file(name, content string) entry {
+	return entry{tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}, content}
+}
+
+func symlink(name, target string) entry {
+	return entry{tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}, ""}
+}
+
+func hardlink(name, target string) entry {
+	return entry{tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}, ""}
+}
+
+func createTarballEntries(t *testing.T, entries []entry) *bytes.Buffer {
+	buff := new(bytes.Buffer)
+	gw := gzip.NewWriter(buff)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		header := e.header
+		assert.Nil(t, tw.WriteHeader(&header))
+		_, err := tw.Write([]byte(e.content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gw.Close())
+	return buff
+}
+
+func processEntries(t *testing.T, entries []entry, extract process.ExtractOptions) (*memSink, *manifest.Manifest, error) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(config), pckg))
+
+	sink := &memSink{files: make(map[string][]byte)}
+	opts := process.Options{
+		Encodings: []*compress.Encoding{compress.Gzip},
+		Extract:   extract,
+	}
+	m, err := process.Version(context.Background(), createTarballEntries(t, entries), pckg, sink, opts)
+	return sink, m, err
+}
+
+func TestExtractRejects(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []entry
+		extract process.ExtractOptions
+		reason  string
+		entry   string
+	}{
+		{
+			name:    "parent directory",
+			entries: []entry{file("package/../../etc/passwd.js", "a")},
+			reason:  process.ReasonUnsafePath,
+			entry:   "package/../../etc/passwd.js",
+		},
+		{
+			name:    "absolute path",
+			entries: []entry{file("/etc/passwd.js", "a")},
+			reason:  process.ReasonUnsafePath,
+			entry:   "/etc/passwd.js",
+		},
+		{
+			name:    "too many files",
+			entries: []entry{file("package/dist/a.js", "a"), file("package/dist/b.js", "b")},
+			extract: process.ExtractOptions{MaxFiles: 1},
+			reason:  process.ReasonTooManyFiles,
+			entry:   "package/dist/b.js",
+		},
+		{
+			name:    "too large",
+			entries: []entry{file("package/dist/a.js", "aaaa"), file("package/dist/b.js", "bbbb")},
+			extract: process.ExtractOptions{MaxSize: 6},
+			reason:  process.ReasonTooLarge,
+			entry:   "package/dist/b.js",
+		},
+		{
+			name:    "too large with links",
+			entries: []entry{file("package/dist/a.js", "aaaa"), symlink("package/dist/b.js", "a.js")},
+			extract: process.ExtractOptions{MaxSize: 6, Links: true},
+			reason:  process.ReasonTooLarge,
+			entry:   "package/dist/b.js",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			sink, _, err := processEntries(t, c.entries, c.extract)
+
+			extractErr, ok := err.(process.ExtractError)
+			assert.True(t, ok, "expected an ExtractError, got %v", err)
+			assert.Equal(t, c.reason, extractErr.Reason)
+			assert.Equal(t, c.entry, extractErr.Entry)
+
+			// only the manifest, recording the rejection, is written
+			assert.Equal(t, []string{manifest.Name}, sink.names())
+			m, err := manifest.Parse(sink.files[manifest.Name])
+			assert.Nil(t, err)
+			assert.NotNil(t, m.Rejected)
+			assert.Equal(t, c.reason, m.Rejected.Reason)
+			assert.Empty(t, m.Files)
+		})
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	entries := []entry{
+		file("package/dist/a.js", "a"),
+		symlink("package/dist/b.js", "a.js"),
+		symlink("package/dist/c.js", "b.js"),
+		hardlink("package/dist/d.js", "package/dist/a.js"),
+		symlink("package/dist/outside.js", "../../../etc/passwd"),
+		symlink("package/dist/absolute.js", "/etc/passwd"),
+		symlink("package/dist/loop.js", "loop.js"),
+	}
+
+	// ignored by default
+	_, m, err := processEntries(t, entries, process.ExtractOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.js"}, m.Names())
+
+	// copied when requested, if they target a file of the version
+	sink, m, err := processEntries(t, entries, process.ExtractOptions{Links: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.js", "b.js", "c.js", "d.js"}, m.Names())
+	for _, name := range m.Names() {
+		content, err := compress.Gzip.Decode(bytes.NewReader(sink.files[name+".gz"]))
+		assert.Nil(t, err)
+		assert.Equal(t, "a", string(content), name)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(config), pckg))
+
+	buff := new(bytes.Buffer)
+	zw := zip.NewWriter(buff)
+	w, err := zw.Create("package/dist/a.js")
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+
+	data := buff.Bytes()
+
+	sink := &memSink{files: make(map[string][]byte)}
+	opts := process.Options{Encodings: []*compress.Encoding{compress.Gzip}}
+	m, err := process.Version(context.Background(), bytes.NewBuffer(data), pckg, sink, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.js"}, m.Names())
+
+	// the zip is read in memory up to the size budget
+	sink = &memSink{files: make(map[string][]byte)}
+	opts.Extract = process.ExtractOptions{MaxSize: int64(len(data) - 1)}
+	_, err = process.Version(context.Background(), bytes.NewBuffer(data), pckg, sink, opts)
+	extractErr, ok := err.(process.ExtractError)
+	assert.True(t, ok, "expected an ExtractError, got %v", err)
+	assert.Equal(t, process.ReasonTooLarge, extractErr.Reason)
+}
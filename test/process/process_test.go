@@ -0,0 +1,371 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+	"github.com/cdnjs/tools/manifest"
+	"github.com/cdnjs/tools/packages"
+	"github.com/cdnjs/tools/process"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const config = `{
+	"name": "a-happy-tyler",
+	"autoupdate": {
+		"source": "npm",
+		"target": "a-happy-tyler",
+		"fileMap": [
+			{ "basePath": "dist", "files": ["*.js", "*.woff2", "*.css"] }
+		]
+	},
+	"optimization": {
+		"js": false,
+		"gzip": { "iterations": 1 }
+	}
+}`
+
+// Keeps the processed files in memory.
+type memSink struct {
+	sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memSink) WriteFile(name string, r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.files[name] = content
+	return nil
+}
+
+func (s *memSink) names() []string {
+	names := make([]string, 0)
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func createTarball(t *testing.T, files map[string]string) *bytes.Buffer {
+	buff := new(bytes.Buffer)
+	gw := gzip.NewWriter(buff)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		header := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}
+		assert.Nil(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gw.Close())
+	return buff
+}
+
+func TestProcessVersion(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(config), pckg))
+
+	tarball := createTarball(t, map[string]string{
+		"package/dist/a.js":    "console.log('a');",
+		"package/dist/f.woff2": "font",
+		"package/src/a.js":     "not matched",
+	})
+
+	sink := &memSink{files: make(map[string][]byte)}
+	opts := process.Options{Encodings: []*compress.Encoding{compress.Gzip}}
+	m, err := process.Version(context.Background(), tarball, pckg, sink, opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"a.js", "f.woff2"}, m.Names())
+	assert.Equal(t, []manifest.Skipped{
+		{Name: "*.css", Reason: manifest.SkipGlobMiss, Detail: "no file matched in dist"},
+	}, m.Skipped)
+	assert.Equal(t, []string{
+		"a.js.gz",
+		"f.woff2",
+		"integrity.json",
+		"manifest.json",
+	}, sink.names())
+
+	js, ok := m.Get("a.js")
+	assert.True(t, ok)
+	assert.Equal(t, int64(len("console.log('a');")), js.Size)
+	assert.Equal(t, []string{"a.js.gz"}, js.Entries())
+	assert.Equal(t, int64(len(sink.files["a.js.gz"])), js.Encoded[0].Size)
+
+	uncompressed, err := compress.Gzip.Decode(bytes.NewReader(sink.files["a.js.gz"]))
+	assert.Nil(t, err)
+	assert.Equal(t, "console.log('a');", string(uncompressed))
+
+	written, err := manifest.Parse(sink.files[manifest.Name])
+	assert.Nil(t, err)
+	assert.Equal(t, m.Names(), written.Names())
+}
+
+func TestProcessVersionInvalidTarball(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(config), pckg))
+
+	sink := &memSink{files: make(map[string][]byte)}
+	_, err := process.Version(context.Background(), bytes.NewBufferString("not a tarball"), pckg, sink, process.Options{})
+	extractErr, ok := err.(process.ExtractError)
+	assert.True(t, ok, "expected an ExtractError, got %v", err)
+	assert.Equal(t, process.ReasonInvalidArchive, extractErr.Reason)
+	assert.Equal(t, []string{manifest.Name}, sink.names())
+}
+
+func TestProcessVersionReproducible(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(config), pckg))
+
+	// the output is archived, with the manifest without its timings
+	var outputs []map[string][]byte
+	for i := 0; i < 2; i++ {
+		tarball := createTarball(t, map[string]string{
+			"package/dist/a.js":    "console.log('a');",
+			"package/dist/f.woff2": "font",
+		})
+		sink := &memSink{files: make(map[string][]byte)}
+		opts := process.Options{Encodings: []*compress.Encoding{compress.Gzip}}
+		m, err := process.Version(context.Background(), tarball, pckg, sink, opts)
+		assert.Nil(t, err)
+		sink.files[manifest.Name], err = m.WithoutTimings().Marshal()
+		assert.Nil(t, err)
+		outputs = append(outputs, sink.files)
+	}
+	assert.Equal(t, outputs[0], outputs[1])
+}
+
+func TestProcessVersionNoAutoupdate(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(`{"name": "a-happy-tyler"}`), pckg))
+
+	tarball := createTarball(t, map[string]string{"package/dist/a.js": "console.log('a');"})
+	sink := &memSink{files: make(map[string][]byte)}
+	_, err := process.Version(context.Background(), tarball, pckg, sink, process.Options{})
+	assert.NotNil(t, err)
+	assert.Empty(t, sink.names())
+}
+
+func TestProcessVersionInvalidPattern(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"name": "a-happy-tyler",
+		"autoupdate": {
+			"source": "npm",
+			"target": "a-happy-tyler",
+			"fileMap": [
+				{ "basePath": "dist", "files": ["{1..9223372036854775807}", "*.woff2"] }
+			]
+		}
+	}`), pckg))
+
+	tarball := createTarball(t, map[string]string{"package/dist/f.woff2": "font"})
+	sink := &memSink{files: make(map[string][]byte)}
+	m, err := process.Version(context.Background(), tarball, pckg, sink, process.Options{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"f.woff2"}, m.Names())
+	assert.Len(t, m.Skipped, 1)
+	assert.Equal(t, "{1..9223372036854775807}", m.Skipped[0].Name)
+	assert.Equal(t, manifest.SkipInvalidGlob, m.Skipped[0].Reason)
+}
+
+func TestProcessVersionMatchError(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(config), pckg))
+
+	// the base path of the fileMap is a file, it can't be listed
+	tarball := createTarball(t, map[string]string{"package/dist": "not a dir"})
+	sink := &memSink{files: make(map[string][]byte)}
+	_, err := process.Version(context.Background(), tarball, pckg, sink, process.Options{})
+	assert.NotNil(t, err)
+	assert.Empty(t, sink.names())
+}
+
+// Minifies by keeping the first line of the file.
+type firstLineMinifier struct{}
+
+func (firstLineMinifier) Name() string { return "first-line" }
+
+func (firstLineMinifier) Minify(ctx context.Context, src, dest string) ([]string, error) {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return nil, ioutil.WriteFile(dest, bytes.SplitN(content, []byte("\n"), 2)[0], 0644)
+}
+
+func TestProcessVersionMinifiers(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"name": "a-happy-tyler",
+		"autoupdate": {
+			"source": "npm",
+			"target": "a-happy-tyler",
+			"fileMap": [{ "basePath": "dist", "files": ["*.js"] }]
+		}
+	}`), pckg))
+
+	tarball := createTarball(t, map[string]string{"package/dist/a.js": "a();\nb();"})
+	sink := &memSink{files: make(map[string][]byte)}
+	opts := process.Options{
+		Encodings:   []*compress.Encoding{compress.Gzip},
+		JsMinifiers: []compress.Minifier{firstLineMinifier{}},
+	}
+	m, err := process.Version(context.Background(), tarball, pckg, sink, opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"a.js", "a.min.js"}, m.Names())
+	min, ok := m.Get("a.min.js")
+	assert.True(t, ok)
+	assert.Equal(t, "first-line", min.Minifier)
+	assert.Equal(t, "a.js", min.MinifiedFrom)
+
+	content, err := compress.Gzip.Decode(bytes.NewReader(sink.files["a.min.js.gz"]))
+	assert.Nil(t, err)
+	assert.Equal(t, "a();", string(content))
+}
+
+func TestProcessVersionMinifiedNames(t *testing.T) {
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"name": "a-happy-tyler",
+		"autoupdate": {
+			"source": "npm",
+			"target": "a-happy-tyler",
+			"fileMap": [{ "basePath": "dist", "files": ["**/*.js"] }]
+		}
+	}`), pckg))
+
+	tarball := createTarball(t, map[string]string{
+		"package/dist/highlight.js/lib/x.js": "function hello(name) { return 'hello ' + name; }",
+		"package/dist/jquery.jsonp.js":       "function hello(name) { return 'hello ' + name; }",
+	})
+	sink := &memSink{files: make(map[string][]byte)}
+	opts := process.Options{
+		Encodings:   []*compress.Encoding{compress.Gzip},
+		JsMinifiers: []compress.Minifier{compress.ESBuildJS},
+	}
+	m, err := process.Version(context.Background(), tarball, pckg, sink, opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"highlight.js/lib/x.js",
+		"highlight.js/lib/x.min.js",
+		"highlight.js/lib/x.min.js.map",
+		"jquery.jsonp.js",
+		"jquery.jsonp.min.js",
+		"jquery.jsonp.min.js.map",
+	}, m.Names())
+
+	for name, url := range map[string]string{
+		"highlight.js/lib/x.min.js": "x.min.js.map",
+		"jquery.jsonp.min.js":       "jquery.jsonp.min.js.map",
+	} {
+		content, err := compress.Gzip.Decode(bytes.NewReader(sink.files[name+".gz"]))
+		assert.Nil(t, err)
+		assert.Equal(t, url, compress.FindSourceMappingURL(name, content))
+	}
+}
+
+func TestManifestWithoutTimings(t *testing.T) {
+	m := &manifest.Manifest{
+		Files:      []manifest.File{{Name: "a.js", Size: 17, DurationMs: 12}},
+		DurationMs: 34,
+	}
+	stripped := m.WithoutTimings()
+	assert.Equal(t, int64(0), stripped.DurationMs)
+	assert.Equal(t, int64(0), stripped.Files[0].DurationMs)
+	assert.Equal(t, "a.js", stripped.Files[0].Name)
+
+	// the manifest itself keeps them
+	assert.Equal(t, int64(34), m.DurationMs)
+	assert.Equal(t, int64(12), m.Files[0].DurationMs)
+
+	data, err := stripped.Marshal()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "durationMs")
+}
+
+// Installs a fake cwebp in the PATH, which copies the image.
+func fakeCwebp(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "bin")
+	assert.Nil(t, err)
+	script := "#!/bin/sh\nwhile [ \"$1\" != \"-o\" ]; do shift; done\ncp \"$3\" \"$2\"\n"
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "cwebp"), []byte(script), 0755))
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+	return func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProcessVersionImageDerivatives(t *testing.T) {
+	defer fakeCwebp(t)()
+
+	pckg := new(packages.Package)
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"name": "a-happy-tyler",
+		"autoupdate": {
+			"source": "npm",
+			"target": "a-happy-tyler",
+			"fileMap": [{ "basePath": "dist", "files": ["*"] }]
+		},
+		"optimization": {
+			"png": false,
+			"images": { "derivatives": ["webp"] }
+		}
+	}`), pckg))
+
+	tarball := createTarball(t, map[string]string{
+		"package/dist/a.png":      "a png",
+		"package/dist/a.png.webp": "upstream webp",
+		"package/dist/b.png":      "b png",
+	})
+	sink := &memSink{files: make(map[string][]byte)}
+	opts := process.Options{Encodings: []*compress.Encoding{compress.Gzip}}
+	m, err := process.Version(context.Background(), tarball, pckg, sink, opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"a.png", "a.png.webp", "b.png", "b.png.webp"}, m.Names())
+
+	// already published, the upstream file is kept
+	upstream, ok := m.Get("a.png.webp")
+	assert.True(t, ok)
+	assert.Equal(t, "", upstream.DerivedFrom)
+	content, err := compress.Gzip.Decode(bytes.NewReader(sink.files["a.png.webp.gz"]))
+	assert.Nil(t, err)
+	assert.Equal(t, "upstream webp", string(content))
+
+	derived, ok := m.Get("b.png.webp")
+	assert.True(t, ok)
+	assert.Equal(t, "b.png", derived.DerivedFrom)
+	content, err = compress.Gzip.Decode(bytes.NewReader(sink.files["b.png.webp.gz"]))
+	assert.Nil(t, err)
+	assert.Equal(t, "b png", string(content))
+}
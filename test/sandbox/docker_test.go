@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cdnjs/tools/sandbox"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerConfig(t *testing.T) {
+	d := &sandbox.Docker{Image: "process-version"}
+	opts := sandbox.DefaultOptions()
+	opts.Env = []string{"MINIFY_JS=uglify-js"}
+	config, hostConfig := d.ContainerConfig("/in", "/out", opts)
+
+	assert.Equal(t, "process-version", config.Image)
+	assert.Equal(t, []string{"MINIFY_JS=uglify-js"}, config.Env)
+
+	// no network
+	assert.True(t, config.NetworkDisabled)
+	assert.Equal(t, "none", string(hostConfig.NetworkMode))
+
+	// resource limits
+	assert.Equal(t, opts.Memory, hostConfig.Resources.Memory)
+	assert.Equal(t, opts.Memory, hostConfig.Resources.MemorySwap)
+	assert.Equal(t, opts.NanoCPUs, hostConfig.Resources.NanoCPUs)
+	assert.Equal(t, opts.PidsLimit, *hostConfig.Resources.PidsLimit)
+	assert.Equal(t, "rw,noexec,nosuid,size=1073741824", hostConfig.Tmpfs["/tmp"])
+
+	// privileges
+	assert.True(t, hostConfig.ReadonlyRootfs)
+	assert.Equal(t, []string{"ALL"}, hostConfig.CapDrop)
+	assert.Equal(t, []string{"no-new-privileges"}, hostConfig.SecurityOpt)
+
+	assert.Len(t, hostConfig.Mounts, 2)
+	assert.Equal(t, "/in", hostConfig.Mounts[0].Source)
+	assert.Equal(t, "/input", hostConfig.Mounts[0].Target)
+	assert.True(t, hostConfig.Mounts[0].ReadOnly)
+	assert.Equal(t, "/out", hostConfig.Mounts[1].Source)
+	assert.Equal(t, "/output", hostConfig.Mounts[1].Target)
+	assert.False(t, hostConfig.Mounts[1].ReadOnly)
+}
+
+func TestContainerConfigNetwork(t *testing.T) {
+	d := &sandbox.Docker{Image: "process-version"}
+	opts := sandbox.DefaultOptions()
+	opts.Network = true
+	config, hostConfig := d.ContainerConfig("/in", "/out", opts)
+
+	assert.False(t, config.NetworkDisabled)
+	assert.Equal(t, "default", string(hostConfig.NetworkMode))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateIntegrity(t *testing.T) {
+	cases := []struct {
+		content  string
+		expected sri.Integrity
+	}{
+		{"", sri.Integrity{
+			SHA256: "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+			SHA384: "sha384-OLBgp1GsljhM2TJ+sbHjaiH9txEUvgdDTAzHv2P24donTt6/529l+9Ua0vFImLlb",
+			SHA512: "sha512-z4PhNX7vuL3xVChQ1m2AB9Yg5AULVxXcg/SpIdNs6c5H0NE8XYXysP+DGNKHfuwvY7kxvUdBeoGlODJ6+SfaPg==",
+		}},
+		{"abc", sri.Integrity{
+			SHA256: "sha256-ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=",
+			SHA384: "sha384-ywB1P0WjXou1oD1pmsZQBycsMqsO3tFjGotgWkP/W+2AhgcroefMI1i67KE0yCWn",
+			SHA512: "sha512-3a81oZNherrMQXNJriBBMRLm+k6JqX6iCp7u5ktV05ohkpkqJ0/BqDa6PCOj/uu9RU1EI2Q86A4qmslPpUyknw==",
+		}},
+		{"alert(1);\n", sri.Integrity{
+			SHA256: "sha256-vyWxnR5/SfkpBXTjK7tyUvoeEZCT+fK2ayxsse+sBvs=",
+			SHA384: "sha384-bGe/RBNQDjw1oSdQQ9Orj3inXga8nL70PiYuibiYD7weMiTyu/Y+coqsWPmeVsqL",
+			SHA512: "sha512-Cy5VwhyPkWNk/wkbL8Luyl2TsfLkqqMVHEUz2kIJ8Ua+qYLXuaCUjTTj+zm+WbQbFux2eIk7EGMDxOqC9F4aFw==",
+		}},
+	}
+
+	for _, tc := range cases {
+		integrity, err := sri.CalculateIntegrity(strings.NewReader(tc.content))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, *integrity, "%q", tc.content)
+		assert.Equal(t, tc.expected.SHA512, sri.CalculateSRI([]byte(tc.content)))
+	}
+
+	integrity, err := sri.CalculateIntegrity(strings.NewReader("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join([]string{
+		cases[1].expected.SHA256, cases[1].expected.SHA384, cases[1].expected.SHA512,
+	}, " "), integrity.String())
+}
+
+func TestCalculateFileIntegrity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sri")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "a.js")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("abc"), 0644))
+	integrity, err := sri.CalculateFileIntegrity(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256-ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=", integrity.SHA256)
+
+	_, err = sri.CalculateFileIntegrity(path.Join(dir, "missing.js"))
+	assert.NotNil(t, err)
+}
+
+func TestManifestConcurrent(t *testing.T) {
+	m := sri.NewManifest()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			file := fmt.Sprintf("%02d.js", i)
+			integrity, err := sri.CalculateIntegrity(strings.NewReader(file))
+			assert.Nil(t, err)
+			m.Add(file, integrity)
+			got, ok := m.Get(file)
+			assert.True(t, ok)
+			assert.Equal(t, integrity, got)
+		}(i)
+	}
+	wg.Wait()
+
+	files := m.Files()
+	assert.Len(t, files, 50)
+	assert.Equal(t, "00.js", files[0])
+	assert.Equal(t, "49.js", files[49])
+	_, ok := m.Get("50.js")
+	assert.False(t, ok)
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := sri.NewManifest()
+	for _, file := range []string{"b.js", "a.js", "dist/c.css"} {
+		integrity, err := sri.CalculateIntegrity(strings.NewReader(file))
+		assert.Nil(t, err)
+		m.Add(file, integrity)
+	}
+
+	data, err := json.Marshal(m)
+	assert.Nil(t, err)
+	// sorted by file name
+	assert.True(t, strings.Index(string(data), `"a.js"`) < strings.Index(string(data), `"b.js"`))
+
+	parsed, err := sri.ParseManifest(data)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Files(), parsed.Files())
+	for _, file := range m.Files() {
+		expected, _ := m.Get(file)
+		got, ok := parsed.Get(file)
+		assert.True(t, ok)
+		assert.Equal(t, expected, got)
+	}
+	again, err := json.Marshal(parsed)
+	assert.Nil(t, err)
+	assert.Equal(t, data, again)
+
+	// written to disk
+	dir, err := ioutil.TempDir("", "sri")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	out := path.Join(dir, sri.ManifestFile)
+	assert.Nil(t, m.WriteFile(out))
+	written, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	parsed, err = sri.ParseManifest(written)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Files(), parsed.Files())
+
+	_, err = sri.ParseManifest([]byte(`["a.js"]`))
+	assert.NotNil(t, err)
+}
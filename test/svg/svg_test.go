@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdnjs/tools/compress"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// Minifies each testdata/*.svg file and compares the result
+// with the matching .golden file.
+func TestMinifySVG(t *testing.T) {
+	files, err := filepath.Glob(path.Join("testdata", "*.svg"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		file := file
+		t.Run(path.Base(file), func(t *testing.T) {
+			src, err := ioutil.ReadFile(file)
+			assert.Nil(t, err)
+
+			out, err := compress.MinifySVG(src)
+			assert.Nil(t, err)
+
+			golden := strings.TrimSuffix(file, ".svg") + ".golden"
+			if *update {
+				assert.Nil(t, ioutil.WriteFile(golden, out, 0644))
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			assert.Nil(t, err)
+			assert.Equal(t, string(expected), string(out))
+
+			// minifying is idempotent
+			again, err := compress.MinifySVG(out)
+			assert.Nil(t, err)
+			assert.Equal(t, string(out), string(again))
+		})
+	}
+}
+
+func TestMinifySVGInvalid(t *testing.T) {
+	cases := []string{
+		`<svg><g></svg>`,
+		`<svg><path d="M0 0/></svg>`,
+		`<svg><!-- unterminated</svg>`,
+		`<svg>`,
+	}
+
+	for _, c := range cases {
+		_, err := compress.MinifySVG([]byte(c))
+		assert.NotNil(t, err, c)
+	}
+}
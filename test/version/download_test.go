@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/cdnjs/tools/archive"
+	"github.com/cdnjs/tools/version"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// creates a tarball with a single file
+func createTarball(t *testing.T) []byte {
+	var buff bytes.Buffer
+	gz := gzip.NewWriter(&buff)
+	tw := tar.NewWriter(gz)
+	content := []byte("a")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "package/a.js", Mode: 0644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	return buff.Bytes()
+}
+
+func integrity(content []byte) string {
+	sum := sha512.Sum512(content)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// downloads the file served by a test server into a temporary directory
+func download(t *testing.T, handler http.HandlerFunc, v *version.Version) (string, error) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	assert.Nil(t, err)
+
+	v.Tarball = server.URL + "/a.tgz"
+	dest := path.Join(dir, "new-version")
+	return dest, version.Download(context.Background(), v, dest)
+}
+
+func serve(content []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}
+}
+
+func reason(t *testing.T, err error) string {
+	downloadErr, ok := err.(version.DownloadError)
+	assert.True(t, ok, "expected a DownloadError, got %v", err)
+	return downloadErr.Reason
+}
+
+func TestDownload(t *testing.T) {
+	tarball := createTarball(t)
+	v := version.Version{Version: "1.0.0", Integrity: integrity(tarball)}
+
+	dest, err := download(t, serve(tarball), &v)
+	defer os.RemoveAll(path.Dir(dest))
+	assert.Nil(t, err)
+	assert.Equal(t, archive.TarGz, v.Format)
+
+	content, err := ioutil.ReadFile(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, tarball, content)
+}
+
+func TestDownloadIntegrityMismatch(t *testing.T) {
+	tarball := createTarball(t)
+	v := version.Version{Version: "1.0.0", Integrity: integrity([]byte("b"))}
+
+	dest, err := download(t, serve(tarball), &v)
+	defer os.RemoveAll(path.Dir(dest))
+	assert.Equal(t, version.ReasonIntegrity, reason(t, err))
+
+	// nothing is left behind
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	v := version.Version{Version: "1.0.0"}
+
+	dest, err := download(t, http.NotFound, &v)
+	defer os.RemoveAll(path.Dir(dest))
+	assert.Equal(t, version.ReasonFailed, reason(t, err))
+}
+
+func TestDownloadTooLarge(t *testing.T) {
+	defer func(max int64) { version.MaxDownloadSize = max }(version.MaxDownloadSize)
+	version.MaxDownloadSize = 10
+
+	v := version.Version{Version: "1.0.0"}
+	dest, err := download(t, serve(createTarball(t)), &v)
+	defer os.RemoveAll(path.Dir(dest))
+	assert.Equal(t, version.ReasonTooLarge, reason(t, err))
+}
+
+func TestDownloadInvalidArchive(t *testing.T) {
+	v := version.Version{Version: "1.0.0"}
+
+	dest, err := download(t, serve([]byte("<html>Not Found</html>")), &v)
+	defer os.RemoveAll(path.Dir(dest))
+	assert.Equal(t, version.ReasonInvalidArchive, reason(t, err))
+}
@@ -1,19 +1,22 @@
 package util
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/karrick/godirwalk"
+	"github.com/pkg/errors"
 )
 
-// ListFilesGlob is the legacy, slower version that uses
-// the node glob tool found here: https://github.com/cdnjs/glob
+// ListFilesGlob lists the files in the `base` directory matching a
+// node-glob pattern, as relative paths sorted lexically.
+// Directories are never listed, symbolic links to files are, as well as
+// broken ones, and symbolic links to directories are not crawled by `**`.
 func ListFilesGlob(ctx context.Context, base string, pattern string) ([]string, error) {
 	list := make([]string, 0)
 
@@ -28,33 +31,73 @@ func ListFilesGlob(ctx context.Context, base string, pattern string) ([]string,
 		return list, nil
 	}
 
-	cmd := exec.Command("/glob/index.js", pattern)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	cmd.Dir = base
-	err := cmd.Run()
+	g, err := CompileGlob(pattern)
 	if err != nil {
-		fmt.Printf("%s: %s\n", err, out.String())
 		return list, err
 	}
 
-	for _, line := range strings.Split(out.String(), "\n") {
-		if strings.Trim(line, " ") != "" {
-			list = append(list, line)
+	seen := make(map[string]bool)
+	for _, segments := range g.set {
+		err := walkGlob(base, "", segments, globClosure(segments, map[int]bool{0: true}), func(name string) {
+			seen[name] = true
+		})
+		if err != nil {
+			return list, err
 		}
+	}
 
+	for name := range seen {
+		list = append(list, name)
 	}
+	sort.Strings(list)
 	return list, nil
 }
 
+// Walks a directory, only descending into the directories that can still
+// lead to a match, and calls onMatch for every matching file.
+func walkGlob(dir, rel string, segments []globSegment, states map[int]bool, onMatch func(string)) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "could not read dir %s", dir)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		fp := path.Join(dir, name)
+		relName := path.Join(rel, name)
+
+		isSymlink := entry.Mode()&os.ModeSymlink != 0
+		info := entry
+		if isSymlink {
+			// follow the link, node-glob lists the broken ones as files
+			if target, err := os.Stat(fp); err == nil {
+				info = target
+			}
+		}
+
+		next := globStep(segments, states, name, isSymlink && info.IsDir())
+		if len(next) == 0 {
+			continue
+		}
+
+		if info.IsDir() {
+			if err := walkGlob(fp, relName, segments, next, onMatch); err != nil {
+				return err
+			}
+		} else if (info.Mode().IsRegular() || isSymlink) && next[len(segments)] {
+			onMatch(relName)
+		}
+	}
+	return nil
+}
+
 // Determines if a file path contains a hidden file or directory.
 // Either it starts with . or contains '/.' to be considered hidden.
 func isHidden(fp string) bool {
 	return strings.HasPrefix(fp, ".") || strings.Contains(fp, "/.")
 }
 
-// ListFilesInVersion is the new, optimized version of ListFilesGlob created for
+// ListFilesInVersion is an optimized alternative to ListFilesGlob created for
 // package generation. It lists all of the files within a particular cdnjs package version in
 // the same manner as ListFilesGlob with a '**' glob pattern.
 // Note that hidden cdnjs versions and hidden files/directories are ignored.
@@ -72,7 +115,7 @@ func ListFilesInVersion(ctx context.Context, base string) ([]string, error) {
 	err := godirwalk.Walk(base, &godirwalk.Options{
 		Callback: func(fp string, de *godirwalk.Dirent) error {
 			// trim a full path to a path relative to the base directory (inside package version dir)
-			// trim any leading '/' for consistency with legacy ListFilesGlob implementation
+			// trim any leading '/' for consistency with ListFilesGlob
 			fp = strings.TrimLeft(strings.TrimPrefix(fp, base), "/")
 			// path must not be a directory, not be hidden, and not be empty
 			if !de.IsDir() && !isHidden(fp) && fp != "" {
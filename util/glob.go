@@ -0,0 +1,553 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Glob is a compiled file pattern following the semantics of node-glob 7
+// (minimatch 3), which /glob/index.js used to list the files of fileMap
+// entries in cdnjs/packages.
+//
+// The following is supported:
+//   - `*`, `?` and `[...]` character classes (including `[!...]` and `[^...]`)
+//   - `**` as a whole path segment, matching zero or more directories
+//   - brace expansion, including nested braces and `{1..3}` sequences
+//   - extended globs: `?(...)`, `*(...)`, `+(...)`, `@(...)` and `!(...)`
+//
+// As with node-glob's default options, a path segment starting with a `.`
+// is only matched if the pattern segment explicitly starts with a `.`,
+// and `**` never matches such a segment. As in node-glob 7, a leading `!`
+// doesn't negate the pattern but is matched literally, and POSIX classes
+// such as `[[:alpha:]]` aren't supported.
+type Glob struct {
+	pattern string
+	set     [][]globSegment // one entry per brace-expanded alternative
+}
+
+// A single path segment of a compiled pattern.
+type globSegment struct {
+	globstar bool
+	dot      bool // the segment explicitly starts with a `.`
+	tokens   []globToken
+}
+
+type globTokenKind int
+
+const (
+	globLiteral globTokenKind = iota
+	globAny                   // *
+	globOne                   // ?
+	globClass                 // [...]
+	globExt                   // ?(...), *(...), +(...), @(...), !(...)
+)
+
+type globToken struct {
+	kind    globTokenKind
+	r       rune
+	negate  bool          // for classes
+	ranges  [][2]rune     // for classes
+	ext     rune          // for extended globs, one of ?*+@!
+	options [][]globToken // for extended globs
+}
+
+// CompileGlob compiles a node-glob pattern. Unlike node-glob, which would
+// hang, it rejects the patterns expanding to more than MaxGlobExpansion
+// patterns.
+func CompileGlob(pattern string) (*Glob, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("glob: empty pattern")
+	}
+
+	g := &Glob{pattern: pattern}
+
+	alts, err := expandBraces(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob: %s: %s", err, pattern)
+	}
+	for _, alt := range alts {
+		var segments []globSegment
+		for _, raw := range splitGlobPath(alt) {
+			segments = append(segments, compileGlobSegment(raw))
+		}
+		if len(segments) == 0 {
+			continue
+		}
+		g.set = append(g.set, segments)
+	}
+	if len(g.set) == 0 {
+		return nil, fmt.Errorf("glob: pattern matches nothing: %s", pattern)
+	}
+	return g, nil
+}
+
+// String returns the original pattern.
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// Match reports whether a slash-separated path, relative to the
+// glob's base directory, matches the pattern.
+func (g *Glob) Match(name string) bool {
+	names := splitGlobPath(name)
+	for _, segments := range g.set {
+		if matchGlobSegments(segments, names) {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a path into its segments, dropping empty and `.` segments.
+func splitGlobPath(p string) []string {
+	var out []string
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		out = append(out, part)
+	}
+	return out
+}
+
+// Matches all segments of a path against the segments of a pattern.
+func matchGlobSegments(segments []globSegment, names []string) bool {
+	states := globClosure(segments, map[int]bool{0: true})
+	for _, name := range names {
+		states = globStep(segments, states, name, false)
+		if len(states) == 0 {
+			return false
+		}
+	}
+	return states[len(segments)]
+}
+
+// Adds the states reachable without consuming a path segment,
+// which is only possible by skipping over a `**`.
+func globClosure(segments []globSegment, states map[int]bool) map[int]bool {
+	for i := 0; i < len(segments); i++ {
+		if states[i] && segments[i].globstar {
+			states[i+1] = true
+		}
+	}
+	return states
+}
+
+// Advances the set of pattern positions by consuming one path segment.
+// A `**` does not consume segments starting with a `.`. Like node-glob,
+// which doesn't crawl symbolic links to directories, a `**` consumes one as
+// its last segment only.
+func globStep(segments []globSegment, states map[int]bool, name string, symlinkDir bool) map[int]bool {
+	next := make(map[int]bool)
+	for i := range states {
+		if i >= len(segments) {
+			continue
+		}
+		seg := segments[i]
+		if seg.globstar {
+			switch {
+			case strings.HasPrefix(name, "."):
+			case symlinkDir:
+				next[i+1] = true
+			default:
+				next[i] = true
+			}
+			continue
+		}
+		if seg.match(name) {
+			next[i+1] = true
+		}
+	}
+	return globClosure(segments, next)
+}
+
+func (s globSegment) match(name string) bool {
+	if name == "." || name == ".." {
+		// never matched by magic
+		return isGlobLiteral(s.tokens) && matchGlobTokens(s.tokens, []rune(name))
+	}
+	if strings.HasPrefix(name, ".") && !s.dot {
+		return false
+	}
+	return matchGlobTokens(s.tokens, []rune(name))
+}
+
+func isGlobLiteral(tokens []globToken) bool {
+	for _, t := range tokens {
+		if t.kind != globLiteral {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches a full string against a sequence of tokens by backtracking.
+func matchGlobTokens(tokens []globToken, s []rune) bool {
+	if len(tokens) == 0 {
+		return len(s) == 0
+	}
+	t, rest := tokens[0], tokens[1:]
+	switch t.kind {
+	case globLiteral:
+		return len(s) > 0 && s[0] == t.r && matchGlobTokens(rest, s[1:])
+	case globOne:
+		return len(s) > 0 && matchGlobTokens(rest, s[1:])
+	case globClass:
+		return len(s) > 0 && t.matchClass(s[0]) && matchGlobTokens(rest, s[1:])
+	case globAny:
+		for i := 0; i <= len(s); i++ {
+			if matchGlobTokens(rest, s[i:]) {
+				return true
+			}
+		}
+		return false
+	case globExt:
+		for i := 0; i <= len(s); i++ {
+			if t.matchExt(s[:i]) && matchGlobTokens(rest, s[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func (t globToken) matchClass(r rune) bool {
+	found := false
+	for _, rg := range t.ranges {
+		if r >= rg[0] && r <= rg[1] {
+			found = true
+			break
+		}
+	}
+	return found != t.negate
+}
+
+// Determines if a string is fully matched by an extended glob.
+func (t globToken) matchExt(s []rune) bool {
+	matchOne := func(s []rune) bool {
+		for _, option := range t.options {
+			if matchGlobTokens(option, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch t.ext {
+	case '@':
+		return matchOne(s)
+	case '?':
+		return len(s) == 0 || matchOne(s)
+	case '!':
+		return !matchOne(s)
+	case '*', '+':
+		if len(s) == 0 {
+			return t.ext == '*'
+		}
+		// one or more repetitions
+		for i := 1; i <= len(s); i++ {
+			if matchOne(s[:i]) && (i == len(s) || t.matchExtRepeat(s[i:])) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func (t globToken) matchExtRepeat(s []rune) bool {
+	plus := t
+	plus.ext = '+'
+	return plus.matchExt(s)
+}
+
+func compileGlobSegment(raw string) globSegment {
+	if raw == "**" {
+		return globSegment{globstar: true}
+	}
+	tokens, _ := parseGlobTokens([]rune(raw), 0, false)
+	return globSegment{
+		dot:    strings.HasPrefix(raw, "."),
+		tokens: tokens,
+	}
+}
+
+// Parses tokens starting at index i. When inExt is set, parsing stops
+// at the closing `)` or a `|` of the enclosing extended glob and the
+// index of that character is returned.
+func parseGlobTokens(p []rune, i int, inExt bool) ([]globToken, int) {
+	var tokens []globToken
+	for i < len(p) {
+		c := p[i]
+		switch {
+		case inExt && (c == ')' || c == '|'):
+			return tokens, i
+		case c == '\\' && i+1 < len(p):
+			tokens = append(tokens, globToken{kind: globLiteral, r: p[i+1]})
+			i += 2
+			continue
+		case strings.ContainsRune("?*+@!", c) && i+1 < len(p) && p[i+1] == '(':
+			if t, end, ok := parseGlobExt(p, i); ok {
+				tokens = append(tokens, t)
+				i = end + 1
+				continue
+			}
+			tokens = append(tokens, globToken{kind: globLiteral, r: c})
+		case c == '*':
+			// consecutive stars are equivalent to a single one
+			if len(tokens) == 0 || tokens[len(tokens)-1].kind != globAny {
+				tokens = append(tokens, globToken{kind: globAny})
+			}
+		case c == '?':
+			tokens = append(tokens, globToken{kind: globOne})
+		case c == '[':
+			if t, end, ok := parseGlobClass(p, i); ok {
+				tokens = append(tokens, t)
+				i = end + 1
+				continue
+			}
+			tokens = append(tokens, globToken{kind: globLiteral, r: c})
+		default:
+			tokens = append(tokens, globToken{kind: globLiteral, r: c})
+		}
+		i++
+	}
+	return tokens, i
+}
+
+// Parses an extended glob starting at p[i] (the `?*+@!` character).
+// Returns the index of the closing `)`. If the group is not closed,
+// ok is false and the characters are treated literally.
+func parseGlobExt(p []rune, i int) (globToken, int, bool) {
+	t := globToken{kind: globExt, ext: p[i]}
+	j := i + 2
+	for {
+		option, end := parseGlobTokens(p, j, true)
+		if end >= len(p) {
+			return globToken{}, 0, false
+		}
+		t.options = append(t.options, option)
+		if p[end] == ')' {
+			return t, end, true
+		}
+		j = end + 1
+	}
+}
+
+// Parses a character class starting at p[i] (the `[` character).
+// Returns the index of the closing `]`. If the class is not closed,
+// ok is false and the `[` is treated literally.
+func parseGlobClass(p []rune, i int) (globToken, int, bool) {
+	t := globToken{kind: globClass}
+	j := i + 1
+	if j < len(p) && (p[j] == '!' || p[j] == '^') {
+		t.negate = true
+		j++
+	}
+	first := true
+	for j < len(p) {
+		c := p[j]
+		if c == ']' && !first {
+			return t, j, true
+		}
+		first = false
+		if c == '\\' && j+1 < len(p) {
+			j++
+			c = p[j]
+		}
+		lo, hi := c, c
+		if j+2 < len(p) && p[j+1] == '-' && p[j+2] != ']' {
+			hi = p[j+2]
+			j += 2
+		}
+		t.ranges = append(t.ranges, [2]rune{lo, hi})
+		j++
+	}
+	return globToken{}, 0, false
+}
+
+// MaxGlobExpansion is the maximum number of patterns a brace expansion
+// can produce, so that patterns such as `{1..9223372036854775807}` are
+// rejected instead of exhausting the memory.
+const MaxGlobExpansion = 10000
+
+// Expands braces in a pattern the same way as the brace-expansion
+// module used by minimatch. For instance, `a{b,c{d,e}}` expands to
+// `ab`, `acd` and `ace`, and `a{1..3}` expands to `a1`, `a2` and `a3`.
+// A brace without a comma or a sequence, such as `{a}`, is kept as is.
+func expandBraces(p string) ([]string, error) {
+	start := -1
+	depth := 0
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			prefix, body, suffix := p[:start], p[start+1:i], p[i+1:]
+			options := splitBraceBody(body)
+			if len(options) < 2 {
+				seq, ok, err := expandBraceSequence(body)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					// not an expansion, keep the braces and expand what follows
+					rests, err := expandBraces(suffix)
+					if err != nil {
+						return nil, err
+					}
+					inners, err := expandBraces(body)
+					if err != nil {
+						return nil, err
+					}
+					if len(rests)*len(inners) > MaxGlobExpansion {
+						return nil, errTooManyExpansions
+					}
+					var out []string
+					for _, rest := range rests {
+						for _, inner := range inners {
+							out = append(out, prefix+"{"+inner+"}"+rest)
+						}
+					}
+					return out, nil
+				}
+				options = seq
+			}
+			var out []string
+			for _, option := range options {
+				expanded, err := expandBraces(prefix + option + suffix)
+				if err != nil {
+					return nil, err
+				}
+				if len(out)+len(expanded) > MaxGlobExpansion {
+					return nil, errTooManyExpansions
+				}
+				out = append(out, expanded...)
+			}
+			return out, nil
+		}
+	}
+	return []string{p}, nil
+}
+
+var errTooManyExpansions = fmt.Errorf("brace expansion produces more than %d patterns", MaxGlobExpansion)
+
+// Splits the body of a brace on top-level commas.
+func splitBraceBody(body string) []string {
+	var out []string
+	depth, last := 0, 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, body[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(out, body[last:])
+}
+
+// Expands a numeric (`1..10`, `01..10..2`) or alphabetic (`a..e`) sequence.
+// Returns false if the body isn't a sequence.
+func expandBraceSequence(body string) ([]string, bool, error) {
+	parts := strings.Split(body, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, false, nil
+	}
+
+	var step uint64 = 1
+	if len(parts) == 3 {
+		s, err := strconv.Atoi(parts[2])
+		if err != nil || s == 0 {
+			return nil, false, nil
+		}
+		if s < 0 {
+			step = -uint64(s) // also correct for the minimum int
+		} else {
+			step = uint64(s)
+		}
+	}
+
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	if errFrom == nil && errTo == nil {
+		width := 0
+		if (len(parts[0]) > 1 && strings.TrimLeft(parts[0], "-")[0] == '0') ||
+			(len(parts[1]) > 1 && strings.TrimLeft(parts[1], "-")[0] == '0') {
+			width = len(parts[0])
+			if len(parts[1]) > width {
+				width = len(parts[1])
+			}
+		}
+		seq, err := braceRange(from, to, step)
+		if err != nil {
+			return nil, false, err
+		}
+		var out []string
+		for _, n := range seq {
+			out = append(out, fmt.Sprintf("%0*d", width, n))
+		}
+		return out, true, nil
+	}
+
+	if len(parts[0]) == 1 && len(parts[1]) == 1 {
+		seq, err := braceRange(int(parts[0][0]), int(parts[1][0]), step)
+		if err != nil {
+			return nil, false, err
+		}
+		var out []string
+		for _, n := range seq {
+			out = append(out, string(rune(n)))
+		}
+		return out, true, nil
+	}
+	return nil, false, nil
+}
+
+// Returns the numbers from `from` to `to` included, by step. The length is
+// computed in unsigned arithmetic, which can't overflow, before anything
+// is allocated.
+func braceRange(from, to int, step uint64) ([]int, error) {
+	var span uint64
+	if from <= to {
+		span = uint64(to) - uint64(from)
+	} else {
+		span = uint64(from) - uint64(to)
+	}
+	count := span/step + 1
+	if count > MaxGlobExpansion {
+		return nil, errTooManyExpansions
+	}
+
+	out := make([]int, count)
+	for i := range out {
+		offset := uint64(i) * step
+		if from <= to {
+			out[i] = int(uint64(from) + offset)
+		} else {
+			out[i] = int(uint64(from) - offset)
+		}
+	}
+	return out, nil
+}
@@ -1,26 +1,186 @@
 package version
 
 import (
-	"bytes"
+	"archive/tar"
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 
-	"github.com/cdnjs/tools/util"
+	"github.com/cdnjs/tools/archive"
+	"github.com/cdnjs/tools/sri"
+
+	"github.com/pkg/errors"
 )
 
-func DownloadTar(ctx context.Context, v Version) bytes.Buffer {
-	if v.Tarball == "" {
-		panic("no tarball url provided for " + v.Version)
+// MaxDownloadSize is the maximum size of the archive of a version.
+var MaxDownloadSize int64 = 256 << 20
+
+// Reasons for which a download fails.
+const (
+	// ReasonFailed means the archive couldn't be fetched.
+	ReasonFailed = "download-failed"
+	// ReasonTooLarge means the archive exceeds MaxDownloadSize.
+	ReasonTooLarge = "too-large"
+	// ReasonInvalidArchive means the archive isn't in a supported format.
+	ReasonInvalidArchive = "invalid-archive"
+	// ReasonIntegrity means the archive doesn't match the expected
+	// integrity or commit.
+	ReasonIntegrity = "integrity-mismatch"
+)
+
+// DownloadError is returned when the archive of a version can't be
+// downloaded or verified.
+type DownloadError struct {
+	Reason  string // one of the Reason constants
+	Version string
+	Err     error
+}
+
+func (e DownloadError) Error() string {
+	return fmt.Sprintf("download of %s failed: %s: %s", e.Version, e.Reason, e.Err)
+}
+
+// Download downloads the archive of a version into dest, from its Tarball
+// or else from its git Remote. The archive is limited to MaxDownloadSize
+// bytes and verified against the Integrity or the Commit of the version,
+// and its format is recorded in v. On failure, a DownloadError is returned
+// and dest is removed.
+func Download(ctx context.Context, v *Version, dest string) error {
+	err := download(ctx, v, dest)
+	if err != nil {
+		os.Remove(dest)
+		if _, ok := err.(DownloadError); !ok {
+			err = DownloadError{Reason: ReasonFailed, Version: v.Version, Err: err}
+		}
+	}
+	return err
+}
+
+func download(ctx context.Context, v *Version, dest string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return errors.Wrap(err, "could not create file")
+	}
+	defer out.Close()
+
+	limited := &limitWriter{w: out, n: MaxDownloadSize}
+	var w io.Writer = limited
+	var verifier *sri.Verifier
+	if v.Integrity != "" {
+		verifier, err = sri.NewVerifier(v.Integrity)
+		if err != nil {
+			return DownloadError{Reason: ReasonIntegrity, Version: v.Version, Err: err}
+		}
+		w = io.MultiWriter(limited, verifier)
+	}
+
+	switch {
+	case v.Tarball != "":
+		log.Printf("download %s\n", v.Tarball)
+		err = downloadHTTP(ctx, v.Tarball, w)
+	case v.Remote != "":
+		log.Printf("download %s from %s\n", v.Ref, v.Remote)
+		err = downloadGit(ctx, v, w)
+	default:
+		return errors.Errorf("no tarball url provided for %s", v.Version)
+	}
+	if limited.exceeded {
+		return DownloadError{
+			Reason:  ReasonTooLarge,
+			Version: v.Version,
+			Err:     errors.Errorf("more than %d bytes", MaxDownloadSize),
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrap(err, "could not write file")
+	}
+
+	if verifier != nil {
+		if err := verifier.Verify(); err != nil {
+			return DownloadError{Reason: ReasonIntegrity, Version: v.Version, Err: err}
+		}
+	}
+	if err := inspect(v, dest); err != nil {
+		return err
 	}
-	log.Printf("download %s\n", v.Tarball)
+	log.Printf("downloaded %s (%s)\n", v.Version, v.Format)
+	return nil
+}
 
-	resp, err := http.Get(v.Tarball)
-	util.Check(err)
+// Fetches an HTTP URL into w.
+func downloadHTTP(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
 	defer resp.Body.Close()
 
-	var buff bytes.Buffer
-	buff.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s returned %s", url, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
 
-	return buff
+// Records the format of the downloaded archive, and checks that it is the
+// archive of the expected commit, if any. Git archives, including the
+// tarballs of GitHub, have the commit in the comment of their first header.
+func inspect(v *Version, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "could not open file")
+	}
+	defer f.Close()
+
+	r, err := archive.NewReader(f)
+	if err != nil {
+		return DownloadError{Reason: ReasonInvalidArchive, Version: v.Version, Err: err}
+	}
+	defer r.Close()
+	v.Format = r.Format
+
+	if v.Commit == "" {
+		return nil
+	}
+	header, err := r.Next()
+	if err != nil {
+		return DownloadError{Reason: ReasonInvalidArchive, Version: v.Version, Err: err}
+	}
+	if header.Typeflag != tar.TypeXGlobalHeader || header.PAXRecords["comment"] != v.Commit {
+		return DownloadError{
+			Reason:  ReasonIntegrity,
+			Version: v.Version,
+			Err:     errors.Errorf("not the archive of commit %s", v.Commit),
+		}
+	}
+	return nil
+}
+
+// Writes up to n bytes into w, and fails after.
+type limitWriter struct {
+	w        io.Writer
+	n        int64
+	exceeded bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		l.exceeded = true
+		n, _ := l.w.Write(p[:l.n])
+		l.n -= int64(n)
+		return n, io.ErrShortWrite
+	}
+	n, err := l.w.Write(p)
+	l.n -= int64(n)
+	return n, err
 }
@@ -0,0 +1,64 @@
+package version
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GitAllowProtocol is the colon-separated list of the protocols git may use
+// to reach a remote. The remotes come from the package configurations, so
+// only public https remotes are allowed: ext:: commands, local paths, and
+// the unauthenticated git protocol are refused.
+var GitAllowProtocol = "https"
+
+// Builds the tarball of a version from a shallow fetch of its ref into w.
+// The files are in a top-level directory, as in the tarballs of git hosts.
+func downloadGit(ctx context.Context, v *Version, w io.Writer) error {
+	dir, err := ioutil.TempDir("", "version")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary repository")
+	}
+	defer os.RemoveAll(dir)
+
+	if err := RunGit(ctx, dir, nil, "init", "--bare", "--quiet"); err != nil {
+		return err
+	}
+	if err := RunGit(ctx, dir, nil, "fetch", "--quiet", "--depth=1", "--no-tags", "--", v.Remote, v.Ref); err != nil {
+		return errors.Wrapf(err, "could not fetch %s", v.Ref)
+	}
+
+	prefix := strings.TrimSuffix(v.FileName(), ".tar.gz") + "/"
+	if err := RunGit(ctx, dir, w, "archive", "--format=tar.gz", "--prefix="+prefix, "FETCH_HEAD"); err != nil {
+		return errors.Wrapf(err, "could not archive %s", v.Ref)
+	}
+	return nil
+}
+
+// RunGit runs a git command in dir, writing its output to stdout if not nil.
+// It never prompts for credentials and only uses the GitAllowProtocol
+// protocols.
+func RunGit(ctx context.Context, dir string, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+GitAllowProtocol)
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = &stderr
+	log.Printf("%s: run %s\n", path.Base(dir), cmd)
+	if err := cmd.Run(); err != nil {
+		return errors.Errorf("%s failed: %s: %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
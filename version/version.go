@@ -1,8 +1,12 @@
 package version
 
 import (
+	"fmt"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/cdnjs/tools/archive"
 	"github.com/cdnjs/tools/packages"
 
 	"github.com/gobwas/glob"
@@ -13,7 +17,27 @@ type Version struct {
 	Version string
 	Tarball string
 	Date    time.Time
-	Source  string // npm or git
+	Source  string         // npm or git
+	Format  archive.Format // format of the Tarball, once downloaded
+
+	// expected Subresource Integrity of the Tarball, for npm, or
+	// commit of the archive, for git
+	Integrity string
+	Commit    string
+
+	// git remote and ref of the version, for the versions downloaded
+	// with git since they don't have a Tarball
+	Remote string
+	Ref    string
+}
+
+// FileName returns the name of the file of the downloaded version.
+func (v Version) FileName() string {
+	if v.Tarball != "" {
+		return path.Base(v.Tarball)
+	}
+	repo := strings.TrimSuffix(path.Base(v.Remote), ".git")
+	return fmt.Sprintf("%s-%s.tar.gz", repo, path.Base(v.Ref))
 }
 
 func IsVersionIgnored(config *packages.Autoupdate, version string) bool {